@@ -18,11 +18,17 @@ package v1
 
 import (
 	"encoding/json"
+	"sort"
+	"strings"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	appsv1apply "k8s.io/client-go/applyconfigurations/apps/v1"
 	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
 	networkv1apply "k8s.io/client-go/applyconfigurations/networking/v1"
+
+	"github.com/jnytnai0613/ssa-nginx-controller/pkg/constants"
+	"github.com/jnytnai0613/ssa-nginx-controller/pkg/imageref"
 )
 
 type DeploymentSpecApplyConfiguration appsv1apply.DeploymentSpecApplyConfiguration
@@ -68,6 +74,607 @@ func (c *IngressSpecApplyConfiguration) DeepCopy() *IngressSpecApplyConfiguratio
 	return out
 }
 
+//+kubebuilder:validation:Enum=InPlace;RollingRestart;None
+
+// ReloadStrategy describes how the pods pick up a change of the ConfigMap
+type ReloadStrategy string
+
+const (
+	// ReloadStrategyInPlace reloads nginx in the running pods with the reloader sidecar.
+	ReloadStrategyInPlace ReloadStrategy = "InPlace"
+	// ReloadStrategyRollingRestart stamps a checksum of the ConfigMap onto the pod template,
+	// so that the Deployment rolls the pods when the content changes.
+	ReloadStrategyRollingRestart ReloadStrategy = "RollingRestart"
+	// ReloadStrategyNone leaves the pods as they are. The files of the ConfigMap volume are
+	// still updated, but nginx reads them only when it is reloaded or restarted.
+	ReloadStrategyNone ReloadStrategy = "None"
+)
+
+//+kubebuilder:validation:Enum=Default;Restricted
+
+// SecurityProfile describes the security context generated for the pods
+type SecurityProfile string
+
+const (
+	// SecurityProfileDefault leaves the security context of the pod template as written.
+	SecurityProfileDefault SecurityProfile = "Default"
+	// SecurityProfileRestricted makes the pods comply with the "restricted" Pod Security Standard.
+	// nginx runs as a non-root user with a read-only root filesystem and listens on an unprivileged port.
+	SecurityProfileRestricted SecurityProfile = "Restricted"
+)
+
+//+kubebuilder:validation:Enum=Delete;Orphan;RetainSecrets
+
+// DeletionPolicy describes how the resources owned by SSANginx are handled when the SSANginx is deleted
+type DeletionPolicy string
+
+const (
+	// DeletionPolicyDelete deletes all owned resources.
+	DeletionPolicyDelete DeletionPolicy = "Delete"
+	// DeletionPolicyOrphan keeps all owned resources, including the Service and its allocated IP.
+	DeletionPolicyOrphan DeletionPolicy = "Orphan"
+	// DeletionPolicyRetainSecrets keeps the certificate Secrets and deletes the others.
+	DeletionPolicyRetainSecrets DeletionPolicy = "RetainSecrets"
+)
+
+// PKISpec configures the certificates generated by the controller when ingressSecureEnabled is true
+type PKISpec struct {
+	// CADuration is the validity of the CA certificate. Defaults to 87600h (10 years).
+	//+optional
+	CADuration *metav1.Duration `json:"caDuration,omitempty"`
+	// Duration is the validity of the server and client certificates. Defaults to 8760h (1 year).
+	//+optional
+	Duration *metav1.Duration `json:"duration,omitempty"`
+	// RenewBefore is how long before expiry the certificates are reissued. Defaults to 720h (30 days).
+	//+optional
+	RenewBefore *metav1.Duration `json:"renewBefore,omitempty"`
+	// RotateCA also reissues the CA within renewBefore of its expiry.
+	// The server and client certificates are then reissued with the new CA,
+	// so clients have to fetch the new client certificate.
+	//+optional
+	RotateCA bool `json:"rotateCA,omitempty"`
+	// KeyAlgorithm of the generated private keys. Defaults to RSA2048.
+	// The CA key is only affected when the CA is created or rotated.
+	//+optional
+	KeyAlgorithm KeyAlgorithm `json:"keyAlgorithm,omitempty"`
+	// KeyEncoding of the generated private keys. Defaults to PKCS8.
+	// Ed25519 keys can only be written as PKCS8.
+	//+optional
+	KeyEncoding KeyEncoding `json:"keyEncoding,omitempty"`
+	// Subject of the generated certificates.
+	// Defaults to O=Example Org, OU=Example Org Unit, C=JP.
+	//+optional
+	Subject *SubjectSpec `json:"subject,omitempty"`
+}
+
+//+kubebuilder:validation:Enum=RSA2048;RSA3072;RSA4096;ECDSAP256;ECDSAP384;Ed25519
+
+// KeyAlgorithm selects the type and size of a generated private key
+type KeyAlgorithm string
+
+const (
+	KeyAlgorithmRSA2048   KeyAlgorithm = "RSA2048"
+	KeyAlgorithmRSA3072   KeyAlgorithm = "RSA3072"
+	KeyAlgorithmRSA4096   KeyAlgorithm = "RSA4096"
+	KeyAlgorithmECDSAP256 KeyAlgorithm = "ECDSAP256"
+	KeyAlgorithmECDSAP384 KeyAlgorithm = "ECDSAP384"
+	KeyAlgorithmEd25519   KeyAlgorithm = "Ed25519"
+)
+
+//+kubebuilder:validation:Enum=PKCS1;PKCS8
+
+// KeyEncoding selects the PEM encoding of a generated private key
+type KeyEncoding string
+
+const (
+	// KeyEncodingPKCS1 writes RSA keys as "RSA PRIVATE KEY" and ECDSA keys as "EC PRIVATE KEY".
+	KeyEncodingPKCS1 KeyEncoding = "PKCS1"
+	// KeyEncodingPKCS8 writes every key as "PRIVATE KEY".
+	KeyEncodingPKCS8 KeyEncoding = "PKCS8"
+)
+
+// SubjectSpec holds the subject fields of the generated certificates other than the CommonName
+type SubjectSpec struct {
+	//+optional
+	Organizations []string `json:"organizations,omitempty"`
+	//+optional
+	OrganizationalUnits []string `json:"organizationalUnits,omitempty"`
+	//+optional
+	Countries []string `json:"countries,omitempty"`
+	//+optional
+	Provinces []string `json:"provinces,omitempty"`
+	//+optional
+	Localities []string `json:"localities,omitempty"`
+}
+
+// ClientCertificate describes a client certificate issued by the CA of the SSANginx.
+// It is stored with the keys client.crt and client.key in the Secret "<SSANginx name>-client-<name>".
+type ClientCertificate struct {
+	// Name identifies the client within the SSANginx.
+	//+kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	//+kubebuilder:validation:MaxLength=63
+	Name string `json:"name"`
+	// CommonName of the subject. Defaults to name.
+	//+optional
+	CommonName string `json:"commonName,omitempty"`
+	// Organizations of the subject. Defaults to spec.pki.subject.organizations.
+	//+optional
+	Organizations []string `json:"organizations,omitempty"`
+	// Duration is the validity of the certificate. Defaults to spec.pki.duration.
+	//+optional
+	Duration *metav1.Duration `json:"duration,omitempty"`
+	// KeyAlgorithm defaults to spec.pki.keyAlgorithm.
+	//+optional
+	KeyAlgorithm KeyAlgorithm `json:"keyAlgorithm,omitempty"`
+}
+
+//+kubebuilder:validation:Enum=SelfSigned;CertManager
+
+// TLSMode selects how the certificates for Ingress TLS are issued
+type TLSMode string
+
+const (
+	// TLSModeSelfSigned issues the certificates with the CA generated by the controller.
+	TLSModeSelfSigned TLSMode = "SelfSigned"
+	// TLSModeCertManager delegates the issuance to cert-manager.
+	TLSModeCertManager TLSMode = "CertManager"
+)
+
+// IssuerReference refers to a cert-manager Issuer or ClusterIssuer
+type IssuerReference struct {
+	Name string `json:"name"`
+	//+kubebuilder:validation:Enum=Issuer;ClusterIssuer
+	//+kubebuilder:default=Issuer
+	//+optional
+	Kind string `json:"kind,omitempty"`
+	//+kubebuilder:default=cert-manager.io
+	//+optional
+	Group string `json:"group,omitempty"`
+}
+
+// CertManagerSpec configures the cert-manager resources created by the controller
+type CertManagerSpec struct {
+	// IssuerRef signs the server certificate of the Ingress.
+	IssuerRef IssuerReference `json:"issuerRef"`
+	// ClientCAIssuerRef signs the CA used to verify client certificates.
+	// If not specified, the controller creates a self-signed Issuer for it.
+	//+optional
+	ClientCAIssuerRef *IssuerReference `json:"clientCAIssuerRef,omitempty"`
+}
+
+// TLSSpec configures how the certificates for Ingress TLS are issued
+type TLSSpec struct {
+	// Mode defaults to SelfSigned.
+	//+kubebuilder:default=SelfSigned
+	//+optional
+	Mode TLSMode `json:"mode,omitempty"`
+	// CertManager is required when mode is CertManager.
+	//+optional
+	CertManager *CertManagerSpec `json:"certManager,omitempty"`
+	// ServerSecretName refers to an existing kubernetes.io/tls Secret in the namespace of the SSANginx
+	// used as the server keypair of the Ingress instead of the generated certificate.
+	//+optional
+	ServerSecretName string `json:"serverSecretName,omitempty"`
+	// ClientCASecretName refers to an existing Secret in the namespace of the SSANginx
+	// whose ca.crt verifies client certificates. The controller then issues no client certificate.
+	//+optional
+	ClientCASecretName string `json:"clientCASecretName,omitempty"`
+	// DNSNames are added to the SANs of the server certificate in addition to the hosts of the Ingress rules.
+	//+optional
+	DNSNames []string `json:"dnsNames,omitempty"`
+	// IPAddresses are added to the SANs of the server certificate.
+	//+optional
+	IPAddresses []string `json:"ipAddresses,omitempty"`
+}
+
+//+kubebuilder:validation:Enum=on;optional;optional_no_ca;off
+
+// VerifyClientMode selects how nginx verifies client certificates
+type VerifyClientMode string
+
+const (
+	// VerifyClientOn requires a client certificate signed by the CA.
+	VerifyClientOn VerifyClientMode = "on"
+	// VerifyClientOptional verifies the client certificate only if one is presented.
+	VerifyClientOptional VerifyClientMode = "optional"
+	// VerifyClientOptionalNoCA requests a client certificate but does not verify it with the CA.
+	VerifyClientOptionalNoCA VerifyClientMode = "optional_no_ca"
+	// VerifyClientOff does not request a client certificate.
+	VerifyClientOff VerifyClientMode = "off"
+)
+
+// MTLSSpec tunes the client certificate authentication of the Ingress
+type MTLSSpec struct {
+	// VerifyClient defaults to on.
+	//+kubebuilder:default=on
+	//+optional
+	VerifyClient VerifyClientMode `json:"verifyClient,omitempty"`
+	// VerifyDepth is the maximum length of the client certificate chain. Defaults to 1 in ingress-nginx.
+	//+kubebuilder:validation:Minimum=1
+	//+optional
+	VerifyDepth *int32 `json:"verifyDepth,omitempty"`
+	// PassCertificateToUpstream passes the client certificate to the upstream in the ssl-client-cert header.
+	//+optional
+	PassCertificateToUpstream bool `json:"passCertificateToUpstream,omitempty"`
+	// ErrorPage is the URL the client is redirected to when the authentication fails.
+	//+optional
+	ErrorPage string `json:"errorPage,omitempty"`
+}
+
+//+kubebuilder:validation:Enum=Ingress;GatewayAPI
+
+// RoutingMode selects the resources routing traffic to the Service
+type RoutingMode string
+
+const (
+	// RoutingModeIngress creates a networking.k8s.io/v1 Ingress.
+	RoutingModeIngress RoutingMode = "Ingress"
+	// RoutingModeGatewayAPI creates a Gateway API HTTPRoute, and optionally a Gateway.
+	RoutingModeGatewayAPI RoutingMode = "GatewayAPI"
+)
+
+// GatewayParentReference refers to a Gateway the HTTPRoute attaches to
+type GatewayParentReference struct {
+	Name string `json:"name"`
+	// Namespace defaults to the namespace of the SSANginx.
+	//+optional
+	Namespace string `json:"namespace,omitempty"`
+	// SectionName selects a listener of the Gateway.
+	//+optional
+	SectionName string `json:"sectionName,omitempty"`
+}
+
+// GatewaySpec configures the Gateway created by the controller
+type GatewaySpec struct {
+	GatewayClassName string `json:"gatewayClassName"`
+	// Port of the HTTP listener. Defaults to 80.
+	//+kubebuilder:validation:Minimum=1
+	//+kubebuilder:validation:Maximum=65535
+	//+optional
+	Port *int32 `json:"port,omitempty"`
+}
+
+// RoutingSpec selects the resources routing traffic to the Service
+type RoutingSpec struct {
+	// Mode defaults to Ingress.
+	//+kubebuilder:default=Ingress
+	//+optional
+	Mode RoutingMode `json:"mode,omitempty"`
+	// ParentRefs are the Gateways the HTTPRoute attaches to in GatewayAPI mode.
+	//+optional
+	ParentRefs []GatewayParentReference `json:"parentRefs,omitempty"`
+	// Gateway creates a Gateway named spec.ingressName in GatewayAPI mode, and attaches the HTTPRoute to it.
+	// With ingressSecureEnabled its listener terminates TLS with the server Secret.
+	//+optional
+	Gateway *GatewaySpec `json:"gateway,omitempty"`
+}
+
+//+kubebuilder:validation:Enum=Conf;HTML
+
+// FileTarget selects the directory of the nginx container a key of configMapData is mounted in
+type FileTarget string
+
+const (
+	// FileTargetConf mounts the key in /etc/nginx/conf.d.
+	FileTargetConf FileTarget = "Conf"
+	// FileTargetHTML mounts the key in the html root /usr/share/nginx/html.
+	FileTargetHTML FileTarget = "HTML"
+)
+
+// ConfigMapFile places a key of configMapData in the nginx container
+type ConfigMapFile struct {
+	Key string `json:"key"`
+	// Target defaults to Conf for keys ending in ".conf", otherwise HTML.
+	//+optional
+	Target FileTarget `json:"target,omitempty"`
+	// Path relative to the directory of Target, which may contain subdirectories such as "css/style.css".
+	// Defaults to the key.
+	//+optional
+	Path string `json:"path,omitempty"`
+}
+
+//+kubebuilder:validation:Enum=Image;Git;Archive
+
+// ContentSourceType selects where the content of the html root comes from
+type ContentSourceType string
+
+const (
+	// ContentSourceImage copies a directory of an OCI image.
+	ContentSourceImage ContentSourceType = "Image"
+	// ContentSourceGit checks out a revision of a git repository.
+	ContentSourceGit ContentSourceType = "Git"
+	// ContentSourceArchive extracts a tar archive stored in a ConfigMap or a Secret.
+	ContentSourceArchive ContentSourceType = "Archive"
+)
+
+// ImageContentSource copies a directory of an OCI image.
+// The image does not need a shell, because the files are copied by the reloader binary.
+type ImageContentSource struct {
+	Image string `json:"image"`
+	// Path of the directory in the image. Defaults to "/".
+	//+kubebuilder:default=/
+	//+optional
+	Path string `json:"path,omitempty"`
+}
+
+// GitContentSource checks out a revision of a git repository.
+// The .git directory is not copied to the html root.
+type GitContentSource struct {
+	Repository string `json:"repository"`
+	// Revision is a branch, a tag or a commit. Defaults to HEAD.
+	//+kubebuilder:default=HEAD
+	//+optional
+	Revision string `json:"revision,omitempty"`
+	// Path of the directory in the repository. Defaults to the root of the repository.
+	//+optional
+	Path string `json:"path,omitempty"`
+	// Image running git. Defaults to alpine/git.
+	//+optional
+	Image string `json:"image,omitempty"`
+}
+
+// ArchiveContentSource extracts a tar archive, optionally compressed with gzip,
+// stored in the key of an existing ConfigMap or Secret.
+type ArchiveContentSource struct {
+	//+optional
+	ConfigMapName string `json:"configMapName,omitempty"`
+	//+optional
+	SecretName string `json:"secretName,omitempty"`
+	Key        string `json:"key"`
+}
+
+// ContentSource populates the html root from outside of configMapData with an init container
+type ContentSource struct {
+	Type ContentSourceType `json:"type"`
+	// Image is required when type is Image.
+	//+optional
+	Image *ImageContentSource `json:"image,omitempty"`
+	// Git is required when type is Git.
+	//+optional
+	Git *GitContentSource `json:"git,omitempty"`
+	// Archive is required when type is Archive.
+	//+optional
+	Archive *ArchiveContentSource `json:"archive,omitempty"`
+}
+
+//+kubebuilder:validation:Enum=Prefix;Exact;Regex;RegexCaseInsensitive;PreferentialPrefix
+
+// LocationMatch selects the modifier of a location
+type LocationMatch string
+
+const (
+	// LocationMatchPrefix matches the beginning of the URI.
+	LocationMatchPrefix LocationMatch = "Prefix"
+	// LocationMatchExact ("=") matches the whole URI.
+	LocationMatchExact LocationMatch = "Exact"
+	// LocationMatchRegex ("~") matches a case-sensitive regular expression.
+	LocationMatchRegex LocationMatch = "Regex"
+	// LocationMatchRegexCaseInsensitive ("~*") matches a case-insensitive regular expression.
+	LocationMatchRegexCaseInsensitive LocationMatch = "RegexCaseInsensitive"
+	// LocationMatchPreferentialPrefix ("^~") matches the beginning of the URI and skips the regular expressions.
+	LocationMatchPreferentialPrefix LocationMatch = "PreferentialPrefix"
+)
+
+// NginxReturn stops the processing and returns a status code
+type NginxReturn struct {
+	//+kubebuilder:validation:Minimum=100
+	//+kubebuilder:validation:Maximum=599
+	Code int32 `json:"code"`
+	// Text is the URL of a redirect (301, 302, 303, 307 and 308) or the response body of the other codes.
+	//+optional
+	Text string `json:"text,omitempty"`
+}
+
+// NginxLocation renders a location block.
+// Only one of root, proxyPass and return can be set.
+type NginxLocation struct {
+	Path string `json:"path"`
+	// Match defaults to Prefix.
+	//+kubebuilder:default=Prefix
+	//+optional
+	Match LocationMatch `json:"match,omitempty"`
+	//+optional
+	Root string `json:"root,omitempty"`
+	// ProxyPass is the URL requests are proxied to, such as "http://backend:8080".
+	//+optional
+	ProxyPass string `json:"proxyPass,omitempty"`
+	//+optional
+	Return *NginxReturn `json:"return,omitempty"`
+	// Snippet is raw directives appended to the location block.
+	//+optional
+	Snippet string `json:"snippet,omitempty"`
+}
+
+// NginxGzip enables the gzip compression of responses
+type NginxGzip struct {
+	// Types are the MIME types compressed in addition to text/html.
+	//+optional
+	Types []string `json:"types,omitempty"`
+	// MinLength is the minimum length of a compressed response in bytes.
+	//+kubebuilder:validation:Minimum=0
+	//+optional
+	MinLength *int32 `json:"minLength,omitempty"`
+}
+
+// NginxHeader is added to the responses with add_header
+type NginxHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// NginxErrorPage shows the URI for the status codes
+type NginxErrorPage struct {
+	//+kubebuilder:validation:MinItems=1
+	Codes []int32 `json:"codes"`
+	URI   string  `json:"uri"`
+}
+
+// NginxSpec is rendered into default.conf as a server block
+type NginxSpec struct {
+	// ListenPorts defaults to 80, or 8080 with the Restricted security profile.
+	//+optional
+	ListenPorts []int32 `json:"listenPorts,omitempty"`
+	//+optional
+	ServerNames []string `json:"serverNames,omitempty"`
+	// Root defaults to /usr/share/nginx/html.
+	//+optional
+	Root string `json:"root,omitempty"`
+	// Index defaults to index.html and index.htm.
+	//+optional
+	Index []string `json:"index,omitempty"`
+	//+optional
+	Locations []NginxLocation `json:"locations,omitempty"`
+	//+optional
+	Gzip *NginxGzip `json:"gzip,omitempty"`
+	// ClientMaxBodySize is the maximum size of a request body, such as "10m".
+	//+kubebuilder:validation:Pattern=`^[0-9]+[kKmMgG]?$`
+	//+optional
+	ClientMaxBodySize string `json:"clientMaxBodySize,omitempty"`
+	//+optional
+	Headers []NginxHeader `json:"headers,omitempty"`
+	//+optional
+	ErrorPages []NginxErrorPage `json:"errorPages,omitempty"`
+	// HTTPSnippet is raw directives placed before the server block, such as map and upstream.
+	//+optional
+	HTTPSnippet string `json:"httpSnippet,omitempty"`
+	// ServerSnippet is raw directives appended to the server block.
+	// The directives generated from the other fields cannot be used.
+	//+optional
+	ServerSnippet string `json:"serverSnippet,omitempty"`
+}
+
+//+kubebuilder:validation:Enum=RoundRobin;LeastConn;IPHash;Random
+
+// LoadBalancingMethod selects how an upstream distributes requests to its servers
+type LoadBalancingMethod string
+
+const (
+	// LoadBalancingRoundRobin distributes requests in turn, in proportion to the weights.
+	LoadBalancingRoundRobin LoadBalancingMethod = "RoundRobin"
+	// LoadBalancingLeastConn sends a request to the server with the least active connections.
+	LoadBalancingLeastConn LoadBalancingMethod = "LeastConn"
+	// LoadBalancingIPHash sends the requests of a client IP address to the same server.
+	LoadBalancingIPHash LoadBalancingMethod = "IPHash"
+	// LoadBalancingRandom sends a request to a random server.
+	LoadBalancingRandom LoadBalancingMethod = "Random"
+)
+
+// UpstreamServer refers to a Service in the namespace of the SSANginx
+type UpstreamServer struct {
+	ServiceName string `json:"serviceName"`
+	// Port is the number or the name of a port of the Service.
+	Port intstr.IntOrString `json:"port"`
+	//+kubebuilder:validation:Minimum=1
+	//+optional
+	Weight *int32 `json:"weight,omitempty"`
+	// MaxFails is the number of failed attempts within failTimeout after which the server is
+	// considered unavailable for failTimeout. Defaults to 1 in nginx, and 0 disables it.
+	//+kubebuilder:validation:Minimum=0
+	//+optional
+	MaxFails *int32 `json:"maxFails,omitempty"`
+	// FailTimeout defaults to 10s in nginx.
+	//+optional
+	FailTimeout *metav1.Duration `json:"failTimeout,omitempty"`
+	// Backup receives requests only when the other servers are unavailable.
+	//+optional
+	Backup bool `json:"backup,omitempty"`
+}
+
+// UpstreamTimeouts are set in the locations proxying to the upstream
+type UpstreamTimeouts struct {
+	//+optional
+	Connect *metav1.Duration `json:"connect,omitempty"`
+	//+optional
+	Read *metav1.Duration `json:"read,omitempty"`
+	//+optional
+	Send *metav1.Duration `json:"send,omitempty"`
+}
+
+// Upstream renders an upstream block in upstreams.conf.
+// A location of spec.nginx refers to it with proxyPass "http://<name>".
+type Upstream struct {
+	Name string `json:"name"`
+	//+kubebuilder:validation:MinItems=1
+	Servers []UpstreamServer `json:"servers"`
+	// Method defaults to RoundRobin.
+	//+kubebuilder:default=RoundRobin
+	//+optional
+	Method LoadBalancingMethod `json:"method,omitempty"`
+	// Keepalive is the number of idle connections to the servers kept by each worker process.
+	//+kubebuilder:validation:Minimum=1
+	//+optional
+	Keepalive *int32 `json:"keepalive,omitempty"`
+	//+optional
+	Timeouts *UpstreamTimeouts `json:"timeouts,omitempty"`
+}
+
+// NginxContainerIndex returns the index of the nginx container in the pod template, or -1 if none is found.
+func (s *SSANginxSpec) NginxContainerIndex() int {
+	if s.DeploymentSpec == nil || s.DeploymentSpec.Template == nil || s.DeploymentSpec.Template.Spec == nil {
+		return -1
+	}
+
+	containers := s.DeploymentSpec.Template.Spec.Containers
+	for i := range containers {
+		if s.NginxContainerName != "" {
+			if containers[i].Name != nil && *containers[i].Name == s.NginxContainerName {
+				return i
+			}
+			continue
+		}
+		if containers[i].Image != nil && imageref.IsNginx(*containers[i].Image) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// MountedFiles returns a ConfigMapFile for every key of configMapData and configMapBinaryData,
+// and the files rendered from spec.nginx and spec.upstreams, sorted by key,
+// with Target and Path defaulted, so that the volumes are the same on every reconcile.
+func (s *SSANginxSpec) MountedFiles() []ConfigMapFile {
+	overrides := make(map[string]ConfigMapFile, len(s.ConfigMapFiles))
+	for _, f := range s.ConfigMapFiles {
+		overrides[f.Key] = f
+	}
+
+	keys := make([]string, 0, len(s.ConfigMapData)+len(s.ConfigMapBinaryData)+1)
+	for key := range s.ConfigMapData {
+		keys = append(keys, key)
+	}
+	if _, ok := s.ConfigMapData[constants.DefaultConfKey]; !ok && s.Nginx != nil {
+		keys = append(keys, constants.DefaultConfKey)
+	}
+	if _, ok := s.ConfigMapData[constants.UpstreamsConfKey]; !ok && len(s.Upstreams) > 0 {
+		keys = append(keys, constants.UpstreamsConfKey)
+	}
+	for key := range s.ConfigMapBinaryData {
+		if _, ok := s.ConfigMapData[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	files := make([]ConfigMapFile, 0, len(keys))
+	for _, key := range keys {
+		f := overrides[key]
+		f.Key = key
+		if f.Target == "" {
+			f.Target = FileTargetHTML
+			if strings.HasSuffix(key, ".conf") {
+				f.Target = FileTargetConf
+			}
+		}
+		if f.Path == "" {
+			f.Path = key
+		}
+		files = append(files, f)
+	}
+
+	return files
+}
+
 // SSANginxSpec defines the desired state of SSANginx
 type SSANginxSpec struct {
 	DeploymentName       string                            `json:"deploymentName"`
@@ -79,14 +686,184 @@ type SSANginxSpec struct {
 	IngressName          string                            `json:"ingressName"`
 	IngressSpec          *IngressSpecApplyConfiguration    `json:"ingressSpec"`
 	IngressSecureEnabled bool                              `json:"ingressSecureEnabled"`
+
+	// ConfigMapBinaryData holds files that are not UTF-8 text, such as images and fonts.
+	//+optional
+	ConfigMapBinaryData map[string][]byte `json:"configMapBinaryData,omitempty"`
+	// ConfigMapFiles overrides the directory and path of keys of configMapData and configMapBinaryData.
+	// Every key is mounted, keys ending in ".conf" in conf.d and the others in the html root by default.
+	//+optional
+	ConfigMapFiles []ConfigMapFile `json:"configMapFiles,omitempty"`
+	// ContentSource populates the html root with an init container, for sites exceeding the size limit of a ConfigMap.
+	// No key of the ConfigMap can be mounted in the html root when it is set.
+	//+optional
+	ContentSource *ContentSource `json:"contentSource,omitempty"`
+	// Nginx renders default.conf, which then cannot be set in configMapData.
+	//+optional
+	Nginx *NginxSpec `json:"nginx,omitempty"`
+	// Upstreams render upstream blocks of Services into upstreams.conf in conf.d.
+	// The controller watches the Services and refreshes the configuration when they change.
+	//+optional
+	Upstreams []Upstream `json:"upstreams,omitempty"`
+
+	// IngressClassName of the Ingress. The ingress controller is identified by spec.controller
+	// of the IngressClass, or by the name itself if the IngressClass does not exist.
+	// "nginx" selects ingress-nginx and "haproxy" selects HAProxy Ingress.
+	//+kubebuilder:default=nginx
+	//+optional
+	IngressClassName string `json:"ingressClassName,omitempty"`
+
+	// CommonLabels are added to every resource owned by SSANginx and to the pod template.
+	// The app.kubernetes.io/name, instance and managed-by labels are reserved for the controller.
+	CommonLabels map[string]string `json:"commonLabels,omitempty"`
+	// CommonAnnotations are added to every resource owned by SSANginx.
+	CommonAnnotations map[string]string `json:"commonAnnotations,omitempty"`
+
+	// NginxContainerName is the name of the nginx container in the pod template.
+	// If it is empty, the container with an nginx image, such as nginx, bitnami/nginx or
+	// nginx-unprivileged in any registry, is selected. It is required when several containers have one.
+	//+optional
+	NginxContainerName string `json:"nginxContainerName,omitempty"`
+
+	// SecurityProfile generates the security context of the pods. With Restricted, spec.nginx
+	// listens on 8080 by default and the targetPorts of the Service are moved to 8080.
+	//+kubebuilder:default=Default
+	//+optional
+	SecurityProfile SecurityProfile `json:"securityProfile,omitempty"`
+
+	// ReloadStrategy decides how the pods pick up a change of the ConfigMap.
+	//+kubebuilder:default=InPlace
+	//+optional
+	ReloadStrategy ReloadStrategy `json:"reloadStrategy,omitempty"`
+
+	// DeletionPolicy decides which owned resources are kept when the SSANginx is deleted.
+	//+kubebuilder:default=Delete
+	//+optional
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+
+	// PKI configures the certificates generated when ingressSecureEnabled is true.
+	//+optional
+	PKI *PKISpec `json:"pki,omitempty"`
+
+	// TLS selects how the certificates are issued when ingressSecureEnabled is true.
+	//+optional
+	TLS *TLSSpec `json:"tls,omitempty"`
+
+	// Routing selects whether an Ingress or a Gateway API HTTPRoute is created from spec.ingressSpec.
+	//+optional
+	Routing *RoutingSpec `json:"routing,omitempty"`
+
+	// MTLS tunes the client certificate authentication when ingressSecureEnabled is true.
+	// VerifyDepth is only supported by ingress-nginx.
+	//+optional
+	MTLS *MTLSSpec `json:"mtls,omitempty"`
+
+	// RewriteTargetEnabled adds the rewrite-target annotation with the value "/" to the Ingress.
+	// Defaults to true.
+	//+kubebuilder:default=true
+	//+optional
+	RewriteTargetEnabled *bool `json:"rewriteTargetEnabled,omitempty"`
+
+	// ClientCertificates issues one client certificate per entry.
+	// Removing an entry revokes its certificate and deletes its Secret.
+	// If empty, a single certificate with CommonName "client" is stored in the Secret "<name>-client".
+	//+listType=map
+	//+listMapKey=name
+	//+optional
+	ClientCertificates []ClientCertificate `json:"clientCertificates,omitempty"`
+}
+
+// Condition types set on SSANginx by the controller
+const (
+	ConditionTypeReady       = "Ready"
+	ConditionTypeProgressing = "Progressing"
+	ConditionTypeDegraded    = "Degraded"
+	// Set only when spec.tls refers to existing Secrets
+	ConditionTypeTLSSecretsValid = "TLSSecretsValid"
+	// True when the nginx configuration in spec.configMapData has a syntax error
+	ConditionTypeConfigInvalid = "ConfigInvalid"
+)
+
+// Reasons used for the conditions above
+const (
+	ReasonReconcileSucceeded    = "ReconcileSucceeded"
+	ReasonConfigMapApplyFailed  = "ConfigMapApplyFailed"
+	ReasonDeploymentApplyFailed = "DeploymentApplyFailed"
+	ReasonServiceApplyFailed    = "ServiceApplyFailed"
+	ReasonIngressApplyFailed    = "IngressApplyFailed"
+	ReasonGatewayAPIApplyFailed = "GatewayAPIApplyFailed"
+	ReasonCleanupFailed         = "CleanupFailed"
+	ReasonDeploymentNotFound    = "DeploymentNotFound"
+	ReasonDeploymentProgressing = "DeploymentProgressing"
+	ReasonDeploymentAvailable   = "DeploymentAvailable"
+	ReasonSecretValid           = "SecretValid"
+	ReasonSecretInvalid         = "SecretInvalid"
+	ReasonCertificatePending    = "CertificatePending"
+	ReasonUpstreamPending       = "UpstreamPending"
+	ReasonConfigValid           = "ConfigValid"
+	ReasonConfigSyntaxError     = "ConfigSyntaxError"
+)
+
+// ClientCertificateStatus reports an issued client certificate
+type ClientCertificateStatus struct {
+	Name       string `json:"name"`
+	SecretName string `json:"secretName"`
+	// SerialNumber in hexadecimal.
+	SerialNumber string       `json:"serialNumber,omitempty"`
+	NotAfter     *metav1.Time `json:"notAfter,omitempty"`
+}
+
+// CertificatesStatus reports the expiry of the certificates generated by the controller
+type CertificatesStatus struct {
+	CANotAfter     *metav1.Time `json:"caNotAfter,omitempty"`
+	ServerNotAfter *metav1.Time `json:"serverNotAfter,omitempty"`
+	// ClientNotAfter is the earliest expiry of the client certificates.
+	ClientNotAfter *metav1.Time `json:"clientNotAfter,omitempty"`
+	// CRLNextUpdate is the nextUpdate of the CRL published with the CA certificate.
+	CRLNextUpdate *metav1.Time `json:"crlNextUpdate,omitempty"`
+
+	//+listType=map
+	//+listMapKey=name
+	Clients []ClientCertificateStatus `json:"clients,omitempty"`
 }
 
 // SSANginxStatus defines the observed state of SSANginx
 type SSANginxStatus struct {
+	// ObservedGeneration is the most recent generation reconciled by the controller.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions represent the latest available observations of the SSANginx state.
+	//+listType=map
+	//+listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// Names of the resources owned by this SSANginx.
+	ConfigMapName     string `json:"configMapName,omitempty"`
+	DeploymentName    string `json:"deploymentName,omitempty"`
+	ServiceName       string `json:"serviceName,omitempty"`
+	IngressName       string `json:"ingressName,omitempty"`
+	HTTPRouteName     string `json:"httpRouteName,omitempty"`
+	GatewayName       string `json:"gatewayName,omitempty"`
+	CASecretName      string `json:"caSecretName,omitempty"`
+	IngressSecretName string `json:"ingressSecretName,omitempty"`
+	ClientSecretName  string `json:"clientSecretName,omitempty"`
+
+	// Replica counts mirrored from the owned Deployment.
+	Replicas          int32 `json:"replicas,omitempty"`
+	ReadyReplicas     int32 `json:"readyReplicas,omitempty"`
+	AvailableReplicas int32 `json:"availableReplicas,omitempty"`
+
+	// Certificates reports the expiry of the generated certificates.
+	Certificates *CertificatesStatus `json:"certificates,omitempty"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status"
+//+kubebuilder:printcolumn:name="Available",type="integer",JSONPath=".status.availableReplicas"
+//+kubebuilder:printcolumn:name="Deployment",type="string",JSONPath=".status.deploymentName"
+//+kubebuilder:printcolumn:name="Ingress",type="string",JSONPath=".status.ingressName"
+//+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
 
 // SSANginx is the Schema for the ssanginxes API
 type SSANginx struct {
@@ -17,15 +17,31 @@ limitations under the License.
 package v1
 
 import (
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apivalidation "k8s.io/apimachinery/pkg/api/validation"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	metav1validation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/apimachinery/pkg/util/validation/field"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
+
+	"github.com/jnytnai0613/ssa-nginx-controller/pkg/constants"
+	"github.com/jnytnai0613/ssa-nginx-controller/pkg/imageref"
+	"github.com/jnytnai0613/ssa-nginx-controller/pkg/nginxconf"
 )
 
 var (
@@ -84,7 +100,682 @@ func (r *SSANginx) validateIngressServicePort() *field.Error {
 	return nil
 }
 
-func (r *SSANginx) validateIngress() error {
+func (r *SSANginx) validateCommonMetadata() field.ErrorList {
+	var allErrs field.ErrorList
+	labelsPath := field.NewPath("spec").Child("commonLabels")
+
+	allErrs = append(allErrs, metav1validation.ValidateLabels(r.Spec.CommonLabels, labelsPath)...)
+	for _, key := range []string{constants.LabelName, constants.LabelInstance, constants.LabelManagedBy} {
+		if _, ok := r.Spec.CommonLabels[key]; ok {
+			allErrs = append(allErrs, field.Forbidden(labelsPath.Key(key), "Reserved by the controller."))
+		}
+	}
+
+	allErrs = append(allErrs, apivalidation.ValidateAnnotations(r.Spec.CommonAnnotations, field.NewPath("spec").Child("commonAnnotations"))...)
+
+	return allErrs
+}
+
+// The effective spec.pki.renewBefore
+func (r *SSANginx) renewBefore() time.Duration {
+	if r.Spec.PKI == nil || r.Spec.PKI.RenewBefore == nil {
+		return constants.DefaultRenewBefore
+	}
+
+	return r.Spec.PKI.RenewBefore.Duration
+}
+
+func (r *SSANginx) validatePKI() field.ErrorList {
+	var allErrs field.ErrorList
+	pkiPath := field.NewPath("spec").Child("pki")
+
+	if r.Spec.PKI == nil {
+		return nil
+	}
+
+	durations := []struct {
+		name     string
+		duration *metav1.Duration
+	}{
+		{"caDuration", r.Spec.PKI.CADuration},
+		{"duration", r.Spec.PKI.Duration},
+		{"renewBefore", r.Spec.PKI.RenewBefore},
+	}
+	for _, d := range durations {
+		if d.duration != nil && d.duration.Duration <= 0 {
+			allErrs = append(allErrs, field.Invalid(pkiPath.Child(d.name), d.duration.Duration.String(), "Must be positive."))
+		}
+	}
+
+	// A certificate valid for no longer than renewBefore would be reissued on every reconcile.
+	// The defaults are taken into account, since either side may be omitted.
+	renewBefore := r.renewBefore()
+	if renewBefore > 0 {
+		for _, d := range []struct {
+			name     string
+			duration *metav1.Duration
+			def      time.Duration
+		}{
+			{"caDuration", r.Spec.PKI.CADuration, constants.DefaultCADuration},
+			{"duration", r.Spec.PKI.Duration, constants.DefaultCertificateDuration},
+		} {
+			switch {
+			case d.duration == nil && renewBefore >= d.def:
+				allErrs = append(allErrs, field.Invalid(pkiPath.Child("renewBefore"), renewBefore.String(),
+					fmt.Sprintf("Must be shorter than %s, which defaults to %s.", d.name, d.def)))
+			case d.duration == nil || d.duration.Duration <= 0 || renewBefore < d.duration.Duration:
+			case r.Spec.PKI.RenewBefore != nil:
+				allErrs = append(allErrs, field.Invalid(pkiPath.Child("renewBefore"), renewBefore.String(),
+					fmt.Sprintf("Must be shorter than %s.", d.name)))
+			default:
+				allErrs = append(allErrs, field.Invalid(pkiPath.Child(d.name), d.duration.Duration.String(),
+					fmt.Sprintf("Must be longer than renewBefore, which defaults to %s.", renewBefore)))
+			}
+		}
+	}
+
+	// PKCS1 has no representation of Ed25519 keys
+	if r.Spec.PKI.KeyEncoding == KeyEncodingPKCS1 {
+		usesEd25519 := r.Spec.PKI.KeyAlgorithm == KeyAlgorithmEd25519
+		for _, cc := range r.Spec.ClientCertificates {
+			usesEd25519 = usesEd25519 || cc.KeyAlgorithm == KeyAlgorithmEd25519
+		}
+		if usesEd25519 {
+			allErrs = append(allErrs, field.Invalid(pkiPath.Child("keyEncoding"),
+				r.Spec.PKI.KeyEncoding, "Ed25519 keys can only be encoded as PKCS8."))
+		}
+	}
+
+	return allErrs
+}
+
+func (r *SSANginx) validateTLS() field.ErrorList {
+	var allErrs field.ErrorList
+	tlsPath := field.NewPath("spec").Child("tls")
+
+	if r.Spec.TLS == nil {
+		return nil
+	}
+
+	for n, name := range r.Spec.TLS.DNSNames {
+		for _, msg := range validation.IsWildcardDNS1123Subdomain(name) {
+			allErrs = append(allErrs, field.Invalid(tlsPath.Child("dnsNames").Index(n), name, msg))
+		}
+	}
+	for n, address := range r.Spec.TLS.IPAddresses {
+		if net.ParseIP(address) == nil {
+			allErrs = append(allErrs, field.Invalid(tlsPath.Child("ipAddresses").Index(n), address, "Must be a valid IP address."))
+		}
+	}
+
+	if r.Spec.TLS.Mode != TLSModeCertManager {
+		return allErrs
+	}
+
+	// Secrets provided by the user are only used with self-signed mode.
+	if r.Spec.TLS.ServerSecretName != "" {
+		allErrs = append(allErrs, field.Forbidden(tlsPath.Child("serverSecretName"), "Cannot be used when mode is CertManager."))
+	}
+	if r.Spec.TLS.ClientCASecretName != "" {
+		allErrs = append(allErrs, field.Forbidden(tlsPath.Child("clientCASecretName"), "Cannot be used when mode is CertManager."))
+	}
+
+	if r.Spec.TLS.CertManager == nil {
+		return append(allErrs, field.Required(tlsPath.Child("certManager"), "Required when mode is CertManager."))
+	}
+	if r.Spec.TLS.CertManager.IssuerRef.Name == "" {
+		allErrs = append(allErrs, field.Required(tlsPath.Child("certManager", "issuerRef", "name"), "Issuer name is required."))
+	}
+	if ref := r.Spec.TLS.CertManager.ClientCAIssuerRef; ref != nil && ref.Name == "" {
+		allErrs = append(allErrs, field.Required(tlsPath.Child("certManager", "clientCAIssuerRef", "name"), "Issuer name is required."))
+	}
+
+	return allErrs
+}
+
+func (r *SSANginx) validateClientCertificates() field.ErrorList {
+	var allErrs field.ErrorList
+	clientsPath := field.NewPath("spec").Child("clientCertificates")
+
+	if len(r.Spec.ClientCertificates) == 0 {
+		return nil
+	}
+
+	// The client certificates are only issued by the CA of the controller.
+	if r.Spec.TLS != nil && r.Spec.TLS.Mode == TLSModeCertManager {
+		allErrs = append(allErrs, field.Forbidden(clientsPath, "Cannot be used when mode is CertManager."))
+	}
+	if r.Spec.TLS != nil && r.Spec.TLS.ClientCASecretName != "" {
+		allErrs = append(allErrs, field.Forbidden(clientsPath, "Cannot be used with clientCASecretName."))
+	}
+
+	for n, cc := range r.Spec.ClientCertificates {
+		if cc.Duration == nil {
+			continue
+		}
+		if cc.Duration.Duration <= 0 {
+			allErrs = append(allErrs, field.Invalid(clientsPath.Index(n).Child("duration"), cc.Duration.Duration.String(), "Must be positive."))
+		} else if renewBefore := r.renewBefore(); renewBefore > 0 && cc.Duration.Duration <= renewBefore {
+			allErrs = append(allErrs, field.Invalid(clientsPath.Index(n).Child("duration"), cc.Duration.Duration.String(),
+				fmt.Sprintf("Must be longer than renewBefore (%s).", renewBefore)))
+		}
+	}
+
+	return allErrs
+}
+
+func (r *SSANginx) validateIngressClassName() field.ErrorList {
+	var allErrs field.ErrorList
+
+	if r.Spec.IngressClassName == "" {
+		return nil
+	}
+
+	for _, msg := range validation.IsDNS1123Subdomain(r.Spec.IngressClassName) {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("spec").Child("ingressClassName"), r.Spec.IngressClassName, msg))
+	}
+
+	return allErrs
+}
+
+func (r *SSANginx) validateRouting() field.ErrorList {
+	var allErrs field.ErrorList
+	routingPath := field.NewPath("spec").Child("routing")
+
+	if r.Spec.Routing == nil {
+		return nil
+	}
+
+	if r.Spec.Routing.Mode != RoutingModeGatewayAPI {
+		if len(r.Spec.Routing.ParentRefs) > 0 {
+			allErrs = append(allErrs, field.Forbidden(routingPath.Child("parentRefs"), "Can only be used when mode is GatewayAPI."))
+		}
+		if r.Spec.Routing.Gateway != nil {
+			allErrs = append(allErrs, field.Forbidden(routingPath.Child("gateway"), "Can only be used when mode is GatewayAPI."))
+		}
+		return allErrs
+	}
+
+	if len(r.Spec.Routing.ParentRefs) == 0 && r.Spec.Routing.Gateway == nil {
+		allErrs = append(allErrs, field.Required(routingPath, "Either parentRefs or gateway is required when mode is GatewayAPI."))
+	}
+	for n, ref := range r.Spec.Routing.ParentRefs {
+		if ref.Name == "" {
+			allErrs = append(allErrs, field.Required(routingPath.Child("parentRefs").Index(n).Child("name"), "Gateway name is required."))
+		}
+	}
+	if r.Spec.Routing.Gateway != nil && r.Spec.Routing.Gateway.GatewayClassName == "" {
+		allErrs = append(allErrs, field.Required(routingPath.Child("gateway", "gatewayClassName"), "GatewayClass name is required."))
+	}
+
+	// The Gateway API has no standard setting for client certificate authentication,
+	// so the client certificates issued with ingressSecureEnabled would not be verified.
+	if r.Spec.IngressSecureEnabled {
+		allErrs = append(allErrs, field.Forbidden(field.NewPath("spec").Child("ingressSecureEnabled"), "Cannot be used when mode is GatewayAPI."))
+	}
+	if r.Spec.MTLS != nil {
+		allErrs = append(allErrs, field.Forbidden(field.NewPath("spec").Child("mtls"), "Cannot be used when mode is GatewayAPI."))
+	}
+
+	return allErrs
+}
+
+func (r *SSANginx) validateMTLS() field.ErrorList {
+	var allErrs field.ErrorList
+	mtlsPath := field.NewPath("spec").Child("mtls")
+
+	if r.Spec.MTLS == nil {
+		return nil
+	}
+
+	// The annotations are only set on an Ingress with TLS.
+	if !r.Spec.IngressSecureEnabled {
+		allErrs = append(allErrs, field.Forbidden(mtlsPath, "Requires ingressSecureEnabled."))
+	}
+
+	switch r.Spec.MTLS.VerifyClient {
+	case "", VerifyClientOn, VerifyClientOptional, VerifyClientOptionalNoCA:
+	case VerifyClientOff:
+		if r.Spec.MTLS.PassCertificateToUpstream {
+			allErrs = append(allErrs, field.Forbidden(mtlsPath.Child("passCertificateToUpstream"), "Cannot be used when verifyClient is off."))
+		}
+	default:
+		allErrs = append(allErrs, field.NotSupported(mtlsPath.Child("verifyClient"), r.Spec.MTLS.VerifyClient,
+			[]string{string(VerifyClientOn), string(VerifyClientOptional), string(VerifyClientOptionalNoCA), string(VerifyClientOff)}))
+	}
+
+	if r.Spec.MTLS.VerifyDepth != nil && *r.Spec.MTLS.VerifyDepth < 1 {
+		allErrs = append(allErrs, field.Invalid(mtlsPath.Child("verifyDepth"), *r.Spec.MTLS.VerifyDepth, "Must be positive."))
+	}
+
+	if r.Spec.MTLS.ErrorPage != "" {
+		u, err := url.Parse(r.Spec.MTLS.ErrorPage)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			allErrs = append(allErrs, field.Invalid(mtlsPath.Child("errorPage"), r.Spec.MTLS.ErrorPage, "Must be an absolute http or https URL."))
+		}
+	}
+
+	return allErrs
+}
+
+func (r *SSANginx) validateConfigMapFiles() field.ErrorList {
+	var allErrs field.ErrorList
+	filesPath := field.NewPath("spec").Child("configMapFiles")
+
+	for key := range r.Spec.ConfigMapBinaryData {
+		if _, ok := r.Spec.ConfigMapData[key]; ok {
+			allErrs = append(allErrs, field.Duplicate(field.NewPath("spec").Child("configMapBinaryData").Key(key), key))
+		}
+	}
+
+	keys := make(map[string]bool, len(r.Spec.ConfigMapFiles))
+	for n, f := range r.Spec.ConfigMapFiles {
+		_, text := r.Spec.ConfigMapData[f.Key]
+		_, binary := r.Spec.ConfigMapBinaryData[f.Key]
+		if !text && !binary {
+			allErrs = append(allErrs, field.NotFound(filesPath.Index(n).Child("key"), f.Key))
+		}
+		if keys[f.Key] {
+			allErrs = append(allErrs, field.Duplicate(filesPath.Index(n).Child("key"), f.Key))
+		}
+		keys[f.Key] = true
+
+		// The same rules as the items of a ConfigMap volume
+		if f.Path != "" && (path.IsAbs(f.Path) || strings.HasPrefix(f.Path, "..") ||
+			path.Clean(f.Path) != f.Path || strings.Contains(f.Path, "/..")) {
+			allErrs = append(allErrs, field.Invalid(filesPath.Index(n).Child("path"), f.Path, "Must be a clean relative path without \"..\"."))
+		}
+	}
+
+	paths := make(map[string]bool)
+	for _, f := range r.Spec.MountedFiles() {
+		target := path.Join(string(f.Target), f.Path)
+		if paths[target] {
+			allErrs = append(allErrs, field.Duplicate(field.NewPath("spec").Child("configMapData").Key(f.Key), target))
+		}
+		paths[target] = true
+	}
+
+	return allErrs
+}
+
+func (r *SSANginx) validateContentSource() field.ErrorList {
+	var allErrs field.ErrorList
+	sourcePath := field.NewPath("spec").Child("contentSource")
+
+	source := r.Spec.ContentSource
+	if source == nil {
+		return nil
+	}
+
+	sources := []struct {
+		typ ContentSourceType
+		set bool
+	}{
+		{ContentSourceImage, source.Image != nil},
+		{ContentSourceGit, source.Git != nil},
+		{ContentSourceArchive, source.Archive != nil},
+	}
+	for _, s := range sources {
+		child := sourcePath.Child(strings.ToLower(string(s.typ)))
+		if s.typ == source.Type && !s.set {
+			allErrs = append(allErrs, field.Required(child, fmt.Sprintf("Required when type is %s.", s.typ)))
+		}
+		if s.typ != source.Type && s.set {
+			allErrs = append(allErrs, field.Forbidden(child, fmt.Sprintf("Can only be used when type is %s.", s.typ)))
+		}
+	}
+
+	switch {
+	case source.Type == ContentSourceImage && source.Image != nil:
+		if source.Image.Path != "" && !path.IsAbs(source.Image.Path) {
+			allErrs = append(allErrs, field.Invalid(sourcePath.Child("image", "path"), source.Image.Path, "Must be an absolute path."))
+		}
+	case source.Type == ContentSourceGit && source.Git != nil:
+		if p := source.Git.Path; p != "" && (path.IsAbs(p) || strings.HasPrefix(p, "..") || path.Clean(p) != p) {
+			allErrs = append(allErrs, field.Invalid(sourcePath.Child("git", "path"), p, "Must be a clean relative path without \"..\"."))
+		}
+	case source.Type == ContentSourceArchive && source.Archive != nil:
+		if (source.Archive.ConfigMapName == "") == (source.Archive.SecretName == "") {
+			allErrs = append(allErrs, field.Required(sourcePath.Child("archive"), "Exactly one of configMapName and secretName is required."))
+		}
+	}
+
+	// The html root is the volume populated by the init container
+	for _, f := range r.Spec.MountedFiles() {
+		if f.Target == FileTargetHTML {
+			allErrs = append(allErrs, field.Forbidden(field.NewPath("spec").Child("configMapData").Key(f.Key),
+				"Cannot be mounted in the html root when contentSource is set."))
+		}
+	}
+
+	return allErrs
+}
+
+// Check that a snippet of spec.nginx is valid in context, and does not repeat a directive rendered from the typed fields
+func validateSnippet(fldPath *field.Path, snippet string, context nginxconf.Context, rendered map[string]bool) field.ErrorList {
+	var allErrs field.ErrorList
+
+	if snippet == "" {
+		return nil
+	}
+
+	directives, err := nginxconf.Directives(fldPath.String(), snippet, context)
+	if err != nil {
+		return append(allErrs, field.Invalid(fldPath, snippet, err.Error()))
+	}
+	for _, d := range directives {
+		if rendered[d] {
+			allErrs = append(allErrs, field.Forbidden(fldPath, fmt.Sprintf("Conflicts with the %q directive rendered from spec.nginx.", d)))
+		}
+	}
+
+	return allErrs
+}
+
+func (r *SSANginx) validateNginx() field.ErrorList {
+	var allErrs field.ErrorList
+	nginxPath := field.NewPath("spec").Child("nginx")
+
+	spec := r.Spec.Nginx
+	if spec == nil {
+		return nil
+	}
+
+	if _, ok := r.Spec.ConfigMapData[constants.DefaultConfKey]; ok {
+		allErrs = append(allErrs, field.Forbidden(field.NewPath("spec").Child("configMapData").Key(constants.DefaultConfKey),
+			"Cannot be used with spec.nginx, which renders default.conf."))
+	}
+	if _, ok := r.Spec.ConfigMapBinaryData[constants.DefaultConfKey]; ok {
+		allErrs = append(allErrs, field.Forbidden(field.NewPath("spec").Child("configMapBinaryData").Key(constants.DefaultConfKey),
+			"Cannot be used with spec.nginx, which renders default.conf."))
+	}
+
+	for n, port := range spec.ListenPorts {
+		for _, msg := range validation.IsValidPortNum(int(port)) {
+			allErrs = append(allErrs, field.Invalid(nginxPath.Child("listenPorts").Index(n), port, msg))
+		}
+	}
+
+	for n, h := range spec.Headers {
+		for _, msg := range validation.IsHTTPHeaderName(h.Name) {
+			allErrs = append(allErrs, field.Invalid(nginxPath.Child("headers").Index(n).Child("name"), h.Name, msg))
+		}
+	}
+
+	for n, page := range spec.ErrorPages {
+		for m, code := range page.Codes {
+			if code < 300 || code > 599 {
+				allErrs = append(allErrs, field.Invalid(nginxPath.Child("errorPages").Index(n).Child("codes").Index(m), code, "Must be between 300 and 599."))
+			}
+		}
+	}
+
+	locations := make(map[string]bool, len(spec.Locations))
+	for n, loc := range spec.Locations {
+		locPath := nginxPath.Child("locations").Index(n)
+
+		match := loc.Match
+		if match == "" {
+			match = LocationMatchPrefix
+		}
+		if locations[string(match)+" "+loc.Path] {
+			allErrs = append(allErrs, field.Duplicate(locPath.Child("path"), loc.Path))
+		}
+		locations[string(match)+" "+loc.Path] = true
+
+		rendered := map[string]bool{
+			"root":       loc.Root != "",
+			"proxy_pass": loc.ProxyPass != "",
+			"return":     loc.Return != nil,
+		}
+		count := 0
+		for _, set := range rendered {
+			if set {
+				count++
+			}
+		}
+		if count > 1 {
+			allErrs = append(allErrs, field.Invalid(locPath, loc.Path, "Only one of root, proxyPass and return can be set."))
+		}
+
+		if loc.ProxyPass != "" {
+			if u, err := url.Parse(loc.ProxyPass); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				allErrs = append(allErrs, field.Invalid(locPath.Child("proxyPass"), loc.ProxyPass, "Must be an http or https URL."))
+			}
+		}
+
+		allErrs = append(allErrs, validateSnippet(locPath.Child("snippet"), loc.Snippet, nginxconf.ContextLocation, rendered)...)
+	}
+
+	// The directives always rendered, and the ones rendered from the optional fields
+	rendered := map[string]bool{
+		"listen":               true,
+		"root":                 true,
+		"index":                true,
+		"server_name":          len(spec.ServerNames) > 0,
+		"client_max_body_size": spec.ClientMaxBodySize != "",
+		"gzip":                 spec.Gzip != nil,
+		"gzip_types":           spec.Gzip != nil,
+		"gzip_min_length":      spec.Gzip != nil,
+		"error_page":           len(spec.ErrorPages) > 0,
+	}
+	allErrs = append(allErrs, validateSnippet(nginxPath.Child("serverSnippet"), spec.ServerSnippet, nginxconf.ContextServer, rendered)...)
+	allErrs = append(allErrs, validateSnippet(nginxPath.Child("httpSnippet"), spec.HTTPSnippet, nginxconf.ContextHTTP, nil)...)
+
+	return allErrs
+}
+
+func (r *SSANginx) validateUpstreams() field.ErrorList {
+	var allErrs field.ErrorList
+	upstreamsPath := field.NewPath("spec").Child("upstreams")
+
+	if len(r.Spec.Upstreams) == 0 {
+		return nil
+	}
+
+	if _, ok := r.Spec.ConfigMapData[constants.UpstreamsConfKey]; ok {
+		allErrs = append(allErrs, field.Forbidden(field.NewPath("spec").Child("configMapData").Key(constants.UpstreamsConfKey),
+			"Cannot be used with spec.upstreams, which renders upstreams.conf."))
+	}
+
+	names := make(map[string]bool, len(r.Spec.Upstreams))
+	for n, upstream := range r.Spec.Upstreams {
+		upstreamPath := upstreamsPath.Index(n)
+
+		for _, msg := range validation.IsDNS1123Label(upstream.Name) {
+			allErrs = append(allErrs, field.Invalid(upstreamPath.Child("name"), upstream.Name, msg))
+		}
+		if names[upstream.Name] {
+			allErrs = append(allErrs, field.Duplicate(upstreamPath.Child("name"), upstream.Name))
+		}
+		names[upstream.Name] = true
+
+		if t := upstream.Timeouts; t != nil {
+			timeouts := []struct {
+				name     string
+				duration *metav1.Duration
+			}{
+				{"connect", t.Connect},
+				{"read", t.Read},
+				{"send", t.Send},
+			}
+			for _, d := range timeouts {
+				if d.duration != nil && d.duration.Duration <= 0 {
+					allErrs = append(allErrs, field.Invalid(upstreamPath.Child("timeouts", d.name), d.duration.Duration.String(), "Must be positive."))
+				}
+			}
+		}
+
+		for m, server := range upstream.Servers {
+			serverPath := upstreamPath.Child("servers").Index(m)
+
+			for _, msg := range validation.IsDNS1035Label(server.ServiceName) {
+				allErrs = append(allErrs, field.Invalid(serverPath.Child("serviceName"), server.ServiceName, msg))
+			}
+
+			var msgs []string
+			if server.Port.Type == intstr.String {
+				msgs = validation.IsValidPortName(server.Port.StrVal)
+			} else {
+				msgs = validation.IsValidPortNum(server.Port.IntValue())
+			}
+			for _, msg := range msgs {
+				allErrs = append(allErrs, field.Invalid(serverPath.Child("port"), server.Port.String(), msg))
+			}
+
+			if server.FailTimeout != nil && server.FailTimeout.Duration <= 0 {
+				allErrs = append(allErrs, field.Invalid(serverPath.Child("failTimeout"), server.FailTimeout.Duration.String(), "Must be positive."))
+			}
+			if server.Backup && (upstream.Method == LoadBalancingIPHash || upstream.Method == LoadBalancingRandom) {
+				allErrs = append(allErrs, field.Forbidden(serverPath.Child("backup"), "Cannot be used when method is IPHash or Random."))
+			}
+		}
+	}
+
+	return allErrs
+}
+
+func (r *SSANginx) validateNginxContainer() field.ErrorList {
+	var allErrs field.ErrorList
+
+	// The first nginx container would be selected, which may not be the intended one
+	if r.Spec.NginxContainerName == "" && r.Spec.DeploymentSpec != nil &&
+		r.Spec.DeploymentSpec.Template != nil && r.Spec.DeploymentSpec.Template.Spec != nil {
+		var matched int
+		for _, c := range r.Spec.DeploymentSpec.Template.Spec.Containers {
+			if c.Image != nil && imageref.IsNginx(*c.Image) {
+				matched++
+			}
+		}
+		if matched > 1 {
+			allErrs = append(allErrs, field.Required(field.NewPath("spec").Child("nginxContainerName"),
+				"Several containers have an nginx image in the pod template. Name the nginx container."))
+			return allErrs
+		}
+	}
+
+	if r.Spec.NginxContainerIndex() >= 0 {
+		return nil
+	}
+
+	if r.Spec.NginxContainerName != "" {
+		allErrs = append(allErrs, field.NotFound(field.NewPath("spec").Child("nginxContainerName"), r.Spec.NginxContainerName))
+	} else {
+		allErrs = append(allErrs, field.Required(field.NewPath("spec").Child("nginxContainerName"),
+			"No container with an nginx image is found in the pod template. Name the nginx container."))
+	}
+
+	return allErrs
+}
+
+// nginx cannot bind a privileged port without NET_BIND_SERVICE, which is dropped with the Restricted security profile.
+// Syntax errors are left to the validation of the controller, which reports them in the status.
+func validateUnprivilegedListens(fldPath *field.Path, data string) field.ErrorList {
+	var allErrs field.ErrorList
+
+	listens, err := nginxconf.Listens(fldPath.String(), data)
+	if err != nil {
+		return nil
+	}
+	for _, l := range listens {
+		if port, ok := l.Port(); ok && port < 1024 {
+			allErrs = append(allErrs, field.Invalid(fldPath, l.Address,
+				fmt.Sprintf("listen on line %d must use a port of 1024 or above when securityProfile is Restricted.", l.Line)))
+		}
+	}
+
+	return allErrs
+}
+
+// The security context of the pod template must not contradict the Restricted security profile,
+// which the controller generates on top of it.
+func (r *SSANginx) validateSecurityProfile() field.ErrorList {
+	var allErrs field.ErrorList
+
+	if r.Spec.SecurityProfile != SecurityProfileRestricted {
+		return nil
+	}
+
+	if r.Spec.Nginx != nil {
+		for n, port := range r.Spec.Nginx.ListenPorts {
+			if port < 1024 {
+				allErrs = append(allErrs, field.Invalid(field.NewPath("spec").Child("nginx").Child("listenPorts").Index(n), port,
+					"Must be 1024 or above when securityProfile is Restricted."))
+			}
+		}
+		allErrs = append(allErrs, validateUnprivilegedListens(field.NewPath("spec").Child("nginx").Child("httpSnippet"), r.Spec.Nginx.HTTPSnippet)...)
+	}
+	// The files included by nginx.conf of the nginx image
+	var confMounted bool
+	for _, f := range r.Spec.MountedFiles() {
+		if f.Target == FileTargetConf {
+			confMounted = true
+		}
+		data, ok := r.Spec.ConfigMapData[f.Key]
+		if !ok || f.Target != FileTargetConf || strings.Contains(f.Path, "/") || !strings.HasSuffix(f.Path, ".conf") {
+			continue
+		}
+		allErrs = append(allErrs, validateUnprivilegedListens(field.NewPath("spec").Child("configMapData").Key(f.Key), data)...)
+	}
+	// Without a mounted conf.d, the default.conf of the nginx image listens on port 80.
+	// Only nginx-unprivileged listens on an unprivileged port.
+	if !confMounted {
+		if i := r.Spec.NginxContainerIndex(); i >= 0 {
+			if image := r.Spec.DeploymentSpec.Template.Spec.Containers[i].Image; image != nil && !imageref.IsUnprivileged(*image) {
+				allErrs = append(allErrs, field.Required(field.NewPath("spec").Child("configMapData"),
+					"A conf file listening on a port of 1024 or above, or spec.nginx, is required when securityProfile is Restricted, "+
+						"since the default.conf of the nginx image listens on port 80."))
+			}
+		}
+	}
+
+	if r.Spec.DeploymentSpec == nil || r.Spec.DeploymentSpec.Template == nil || r.Spec.DeploymentSpec.Template.Spec == nil {
+		return allErrs
+	}
+	podPath := field.NewPath("spec").Child("deploymentSpec").Child("template").Child("spec")
+	podSpec := r.Spec.DeploymentSpec.Template.Spec
+
+	if sc := podSpec.SecurityContext; sc != nil {
+		scPath := podPath.Child("securityContext")
+		if sc.RunAsNonRoot != nil && !*sc.RunAsNonRoot {
+			allErrs = append(allErrs, field.Forbidden(scPath.Child("runAsNonRoot"), "Must be true when securityProfile is Restricted."))
+		}
+		if sc.RunAsUser != nil && *sc.RunAsUser == 0 {
+			allErrs = append(allErrs, field.Forbidden(scPath.Child("runAsUser"), "Cannot run as root when securityProfile is Restricted."))
+		}
+	}
+
+	for n, c := range podSpec.Containers {
+		sc := c.SecurityContext
+		if sc == nil {
+			continue
+		}
+		scPath := podPath.Child("containers").Index(n).Child("securityContext")
+		if sc.Privileged != nil && *sc.Privileged {
+			allErrs = append(allErrs, field.Forbidden(scPath.Child("privileged"), "Cannot be true when securityProfile is Restricted."))
+		}
+		if sc.RunAsNonRoot != nil && !*sc.RunAsNonRoot {
+			allErrs = append(allErrs, field.Forbidden(scPath.Child("runAsNonRoot"), "Must be true when securityProfile is Restricted."))
+		}
+		if sc.RunAsUser != nil && *sc.RunAsUser == 0 {
+			allErrs = append(allErrs, field.Forbidden(scPath.Child("runAsUser"), "Cannot run as root when securityProfile is Restricted."))
+		}
+		if sc.Capabilities == nil {
+			continue
+		}
+		for i, capability := range sc.Capabilities.Add {
+			if capability != "NET_BIND_SERVICE" {
+				allErrs = append(allErrs, field.Forbidden(scPath.Child("capabilities").Child("add").Index(i),
+					"Only NET_BIND_SERVICE can be added when securityProfile is Restricted."))
+			}
+		}
+	}
+
+	return allErrs
+}
+
+func (r *SSANginx) validateSSANginx() error {
 	var allErrs field.ErrorList
 	gvk, err := apiutil.GVKForObject(r, newScheme)
 	if err != nil {
@@ -100,6 +791,20 @@ func (r *SSANginx) validateIngress() error {
 		allErrs = append(allErrs, err)
 	}
 
+	allErrs = append(allErrs, r.validateNginxContainer()...)
+	allErrs = append(allErrs, r.validateSecurityProfile()...)
+	allErrs = append(allErrs, r.validateCommonMetadata()...)
+	allErrs = append(allErrs, r.validateIngressClassName()...)
+	allErrs = append(allErrs, r.validatePKI()...)
+	allErrs = append(allErrs, r.validateTLS()...)
+	allErrs = append(allErrs, r.validateClientCertificates()...)
+	allErrs = append(allErrs, r.validateMTLS()...)
+	allErrs = append(allErrs, r.validateRouting()...)
+	allErrs = append(allErrs, r.validateConfigMapFiles()...)
+	allErrs = append(allErrs, r.validateContentSource()...)
+	allErrs = append(allErrs, r.validateNginx()...)
+	allErrs = append(allErrs, r.validateUpstreams()...)
+
 	if len(allErrs) == 0 {
 		return nil
 	}
@@ -112,14 +817,14 @@ func (r *SSANginx) validateIngress() error {
 func (r *SSANginx) ValidateCreate() error {
 	ssanginxlog.Info("validate create", "name", r.Name)
 
-	return r.validateIngress()
+	return r.validateSSANginx()
 }
 
 // ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
 func (r *SSANginx) ValidateUpdate(old runtime.Object) error {
 	ssanginxlog.Info("validate update", "name", r.Name)
 
-	return r.validateIngress()
+	return r.validateSSANginx()
 }
 
 // ValidateDelete implements webhook.Validator so a webhook will be registered for the type
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -29,6 +30,14 @@ const (
 	root /usr/share/nginx/html;
 	index index.html index.htm mod-index.html;
 	server_name localhost;
+}`
+	// defaultconf listening on an unprivileged port for the Restricted security profile
+	unprivilegedconf = `server {
+	listen 8080 default_server;
+	listen [::]:8080 default_server ipv6only=on;
+	root /usr/share/nginx/html;
+	index index.html index.htm mod-index.html;
+	server_name localhost;
 }`
 	indexhtml = `<!DOCTYPE html>
 <html>
@@ -141,4 +150,278 @@ var _ = Describe("Webhook Table Test", func() {
 		Entry("service port for ingress does not match.", resouceName, int32(81), metav1.StatusReasonInvalid, "Must match service port number."),
 	)
 
+	DescribeTable("Common Labels Validator Test", func(labels map[string]string, message string) {
+		ssanginx := testSSANginx(resouceName, int32(port))
+		ssanginx.Spec.CommonLabels = labels
+		ctx := context.Background()
+		err := k8sClient.Create(ctx, ssanginx)
+
+		Expect(err).Should(HaveStatusErrorReason(Equal(metav1.StatusReasonInvalid)))
+		Expect(err.Error()).Should(ContainSubstring(message))
+	},
+		Entry("instance label is reserved.", map[string]string{constants.LabelInstance: "other"}, "Reserved by the controller."),
+		Entry("label value is invalid.", map[string]string{"team": "invalid value"}, "spec.commonLabels"),
+	)
+
+	It("should reject an invalid ingressClassName", func() {
+		ssanginx := testSSANginx(resouceName, int32(port))
+		ssanginx.Spec.IngressClassName = "Invalid_Class"
+		ctx := context.Background()
+		err := k8sClient.Create(ctx, ssanginx)
+
+		Expect(err).Should(HaveStatusErrorReason(Equal(metav1.StatusReasonInvalid)))
+		Expect(err.Error()).Should(ContainSubstring("spec.ingressClassName"))
+	})
+
+	DescribeTable("PKI Validator Test", func(pki *PKISpec, message string) {
+		ssanginx := testSSANginx(resouceName, int32(port))
+		ssanginx.Spec.PKI = pki
+		ctx := context.Background()
+		err := k8sClient.Create(ctx, ssanginx)
+
+		Expect(err).Should(HaveStatusErrorReason(Equal(metav1.StatusReasonInvalid)))
+		Expect(err.Error()).Should(ContainSubstring(message))
+	},
+		Entry("duration is not positive.", &PKISpec{Duration: &metav1.Duration{Duration: -time.Hour}}, "Must be positive."),
+		Entry("renewBefore is longer than duration.", &PKISpec{
+			Duration:    &metav1.Duration{Duration: time.Hour},
+			RenewBefore: &metav1.Duration{Duration: 2 * time.Hour},
+		}, "Must be shorter than duration."),
+		Entry("renewBefore is longer than the default duration.", &PKISpec{
+			RenewBefore: &metav1.Duration{Duration: 8760 * time.Hour},
+		}, "Must be shorter than duration, which defaults to 8760h0m0s."),
+		Entry("renewBefore is longer than caDuration.", &PKISpec{
+			CADuration:  &metav1.Duration{Duration: 12 * time.Hour},
+			Duration:    &metav1.Duration{Duration: 48 * time.Hour},
+			RenewBefore: &metav1.Duration{Duration: 24 * time.Hour},
+		}, "Must be shorter than caDuration."),
+		Entry("duration is shorter than the default renewBefore.", &PKISpec{
+			Duration: &metav1.Duration{Duration: 24 * time.Hour},
+		}, "Must be longer than renewBefore, which defaults to 720h0m0s."),
+		Entry("Ed25519 key is encoded as PKCS1.", &PKISpec{
+			KeyAlgorithm: KeyAlgorithmEd25519,
+			KeyEncoding:  KeyEncodingPKCS1,
+		}, "Ed25519 keys can only be encoded as PKCS8."),
+	)
+
+	DescribeTable("TLS Validator Test", func(tls *TLSSpec, message string) {
+		ssanginx := testSSANginx(resouceName, int32(port))
+		ssanginx.Spec.IngressSecureEnabled = true
+		ssanginx.Spec.TLS = tls
+		ctx := context.Background()
+		err := k8sClient.Create(ctx, ssanginx)
+
+		Expect(err).Should(HaveStatusErrorReason(Equal(metav1.StatusReasonInvalid)))
+		Expect(err.Error()).Should(ContainSubstring(message))
+	},
+		Entry("certManager is not specified.", &TLSSpec{Mode: TLSModeCertManager}, "Required when mode is CertManager."),
+		Entry("issuer name is empty.", &TLSSpec{Mode: TLSModeCertManager, CertManager: &CertManagerSpec{}}, "Issuer name is required."),
+		Entry("dnsName is invalid.", &TLSSpec{DNSNames: []string{"Invalid_Name"}}, "spec.tls.dnsNames[0]"),
+		Entry("ipAddress is invalid.", &TLSSpec{IPAddresses: []string{"10.0.0.256"}}, "Must be a valid IP address."),
+		Entry("serverSecretName is used with CertManager.", &TLSSpec{Mode: TLSModeCertManager, CertManager: &CertManagerSpec{IssuerRef: IssuerReference{Name: "issuer"}}, ServerSecretName: "server"}, "Cannot be used when mode is CertManager."),
+	)
+
+	DescribeTable("Client Certificates Validator Test", func(tls *TLSSpec, clients []ClientCertificate, message string) {
+		ssanginx := testSSANginx(resouceName, int32(port))
+		ssanginx.Spec.IngressSecureEnabled = true
+		ssanginx.Spec.TLS = tls
+		ssanginx.Spec.ClientCertificates = clients
+		ctx := context.Background()
+		err := k8sClient.Create(ctx, ssanginx)
+
+		Expect(err).Should(HaveStatusErrorReason(Equal(metav1.StatusReasonInvalid)))
+		Expect(err.Error()).Should(ContainSubstring(message))
+	},
+		Entry("used with clientCASecretName.", &TLSSpec{ClientCASecretName: "ca"}, []ClientCertificate{{Name: "team-a"}}, "Cannot be used with clientCASecretName."),
+		Entry("duration is not positive.", nil, []ClientCertificate{{Name: "team-a", Duration: &metav1.Duration{Duration: -time.Hour}}}, "Must be positive."),
+		Entry("duration is shorter than renewBefore.", nil, []ClientCertificate{{Name: "team-a", Duration: &metav1.Duration{Duration: 24 * time.Hour}}}, "Must be longer than renewBefore (720h0m0s)."),
+	)
+
+	DescribeTable("mTLS Validator Test", func(secure bool, mtls *MTLSSpec, message string) {
+		ssanginx := testSSANginx(resouceName, int32(port))
+		ssanginx.Spec.IngressSecureEnabled = secure
+		ssanginx.Spec.MTLS = mtls
+		ctx := context.Background()
+		err := k8sClient.Create(ctx, ssanginx)
+
+		Expect(err).Should(HaveStatusErrorReason(Equal(metav1.StatusReasonInvalid)))
+		Expect(err.Error()).Should(ContainSubstring(message))
+	},
+		Entry("ingressSecureEnabled is false.", false, &MTLSSpec{VerifyClient: VerifyClientOptional}, "Requires ingressSecureEnabled."),
+		Entry("certificate is passed without verification.", true, &MTLSSpec{VerifyClient: VerifyClientOff, PassCertificateToUpstream: true}, "Cannot be used when verifyClient is off."),
+		Entry("errorPage is not a URL.", true, &MTLSSpec{ErrorPage: "/error.html"}, "Must be an absolute http or https URL."),
+	)
+
+	DescribeTable("Routing Validator Test", func(secure bool, routing *RoutingSpec, message string) {
+		ssanginx := testSSANginx(resouceName, int32(port))
+		ssanginx.Spec.IngressSecureEnabled = secure
+		ssanginx.Spec.Routing = routing
+		ctx := context.Background()
+		err := k8sClient.Create(ctx, ssanginx)
+
+		Expect(err).Should(HaveStatusErrorReason(Equal(metav1.StatusReasonInvalid)))
+		Expect(err.Error()).Should(ContainSubstring(message))
+	},
+		Entry("no Gateway is specified.", false, &RoutingSpec{Mode: RoutingModeGatewayAPI}, "Either parentRefs or gateway is required when mode is GatewayAPI."),
+		Entry("ingressSecureEnabled is true.", true, &RoutingSpec{Mode: RoutingModeGatewayAPI, Gateway: &GatewaySpec{GatewayClassName: "istio"}}, "spec.ingressSecureEnabled: Forbidden: Cannot be used when mode is GatewayAPI."),
+		Entry("gateway is used in Ingress mode.", false, &RoutingSpec{Mode: RoutingModeIngress, Gateway: &GatewaySpec{GatewayClassName: "istio"}}, "Can only be used when mode is GatewayAPI."),
+	)
+
+	DescribeTable("ConfigMap Files Validator Test", func(files []ConfigMapFile, message string) {
+		ssanginx := testSSANginx(resouceName, int32(port))
+		ssanginx.Spec.ConfigMapFiles = files
+		ctx := context.Background()
+		err := k8sClient.Create(ctx, ssanginx)
+
+		Expect(err).Should(HaveStatusErrorReason(Equal(metav1.StatusReasonInvalid)))
+		Expect(err.Error()).Should(ContainSubstring(message))
+	},
+		Entry("key is not in configMapData.", []ConfigMapFile{{Key: "style.css"}}, "spec.configMapFiles[0].key: Not found"),
+		Entry("path escapes the directory.", []ConfigMapFile{{Key: "index.html", Path: "../index.html"}}, "Must be a clean relative path"),
+		Entry("two keys are mounted at the same path.", []ConfigMapFile{{Key: "index.html", Target: FileTargetConf, Path: "default.conf"}}, "Duplicate value"),
+	)
+
+	DescribeTable("Content Source Validator Test", func(source *ContentSource, message string) {
+		ssanginx := testSSANginx(resouceName, int32(port))
+		delete(ssanginx.Spec.ConfigMapData, "index.html")
+		ssanginx.Spec.ContentSource = source
+		ctx := context.Background()
+		err := k8sClient.Create(ctx, ssanginx)
+
+		Expect(err).Should(HaveStatusErrorReason(Equal(metav1.StatusReasonInvalid)))
+		Expect(err.Error()).Should(ContainSubstring(message))
+	},
+		Entry("git is not specified.", &ContentSource{Type: ContentSourceGit}, "Required when type is Git."),
+		Entry("image is used with type Git.", &ContentSource{Type: ContentSourceGit, Git: &GitContentSource{Repository: "https://example.com/site.git"}, Image: &ImageContentSource{Image: "site"}}, "Can only be used when type is Image."),
+		Entry("archive has no object.", &ContentSource{Type: ContentSourceArchive, Archive: &ArchiveContentSource{Key: "site.tar.gz"}}, "Exactly one of configMapName and secretName is required."),
+	)
+
+	DescribeTable("Nginx Validator Test", func(keepDefaultConf bool, nginx *NginxSpec, message string) {
+		ssanginx := testSSANginx(resouceName, int32(port))
+		if !keepDefaultConf {
+			delete(ssanginx.Spec.ConfigMapData, "default.conf")
+		}
+		ssanginx.Spec.Nginx = nginx
+		ctx := context.Background()
+		err := k8sClient.Create(ctx, ssanginx)
+
+		Expect(err).Should(HaveStatusErrorReason(Equal(metav1.StatusReasonInvalid)))
+		Expect(err.Error()).Should(ContainSubstring(message))
+	},
+		Entry("default.conf is also in configMapData.", true, &NginxSpec{}, "Cannot be used with spec.nginx, which renders default.conf."),
+		Entry("location has both root and proxyPass.", false, &NginxSpec{Locations: []NginxLocation{{Path: "/", Root: "/srv", ProxyPass: "http://backend"}}}, "Only one of root, proxyPass and return can be set."),
+		Entry("serverSnippet repeats a rendered directive.", false, &NginxSpec{ServerSnippet: "listen 8080;"}, `Conflicts with the "listen" directive rendered from spec.nginx.`),
+		Entry("location snippet repeats proxy_pass.", false, &NginxSpec{Locations: []NginxLocation{{Path: "/", ProxyPass: "http://backend", Snippet: "proxy_pass http://other;"}}}, `Conflicts with the "proxy_pass" directive rendered from spec.nginx.`),
+		Entry("serverSnippet has a syntax error.", false, &NginxSpec{ServerSnippet: "location / {"}, "unexpected end of file"),
+	)
+
+	DescribeTable("Upstreams Validator Test", func(upstreams []Upstream, message string) {
+		ssanginx := testSSANginx(resouceName, int32(port))
+		ssanginx.Spec.Upstreams = upstreams
+		ctx := context.Background()
+		err := k8sClient.Create(ctx, ssanginx)
+
+		Expect(err).Should(HaveStatusErrorReason(Equal(metav1.StatusReasonInvalid)))
+		Expect(err.Error()).Should(ContainSubstring(message))
+	},
+		Entry("upstream names are duplicated.", []Upstream{
+			{Name: "backend", Servers: []UpstreamServer{{ServiceName: "api", Port: intstr.FromInt(80)}}},
+			{Name: "backend", Servers: []UpstreamServer{{ServiceName: "web", Port: intstr.FromInt(80)}}},
+		}, "spec.upstreams[1].name: Duplicate value"),
+		Entry("port name is invalid.", []Upstream{
+			{Name: "backend", Servers: []UpstreamServer{{ServiceName: "api", Port: intstr.FromString("HTTP_PORT")}}},
+		}, "spec.upstreams[0].servers[0].port"),
+		Entry("backup is used with IPHash.", []Upstream{
+			{Name: "backend", Method: LoadBalancingIPHash, Servers: []UpstreamServer{{ServiceName: "api", Port: intstr.FromInt(80), Backup: true}}},
+		}, "Cannot be used when method is IPHash or Random."),
+	)
+
+	DescribeTable("Nginx Container Validator Test", func(containerImage, containerName, message string) {
+		ssanginx := testSSANginx(resouceName, int32(port))
+		ssanginx.Spec.DeploymentSpec.Template.Spec.Containers[0].WithImage(containerImage)
+		ssanginx.Spec.NginxContainerName = containerName
+		ctx := context.Background()
+		err := k8sClient.Create(ctx, ssanginx)
+
+		Expect(err).Should(HaveStatusErrorReason(Equal(metav1.StatusReasonInvalid)))
+		Expect(err.Error()).Should(ContainSubstring(message))
+	},
+		Entry("no container has an nginx image.", "myorg/web:1.0", "", "No container with an nginx image is found in the pod template."),
+		Entry("the image only contains nginx in its tag.", "httpd:nginx", "", "No container with an nginx image is found in the pod template."),
+		Entry("the only container is the nginx exporter.", "nginx/nginx-prometheus-exporter:0.11.0", "", "No container with an nginx image is found in the pod template."),
+		Entry("nginxContainerName is not in the pod template.", image, "web", "spec.nginxContainerName: Not found"),
+	)
+
+	It("should require nginxContainerName when several containers have an nginx image", func() {
+		ssanginx := testSSANginx(resouceName, int32(port))
+		ssanginx.Spec.DeploymentSpec.Template.Spec.WithContainers(corev1apply.Container().
+			WithName("canary").
+			WithImage("nginxinc/nginx-unprivileged:1.25"))
+		err := k8sClient.Create(context.Background(), ssanginx)
+
+		Expect(err).Should(HaveStatusErrorReason(Equal(metav1.StatusReasonInvalid)))
+		Expect(err.Error()).Should(ContainSubstring("Several containers have an nginx image in the pod template."))
+	})
+
+	DescribeTable("Security Profile Validator Test", func(nginx *NginxSpec, podSC *corev1apply.PodSecurityContextApplyConfiguration, containerSC *corev1apply.SecurityContextApplyConfiguration, message string) {
+		ssanginx := testSSANginx(resouceName, int32(port))
+		ssanginx.Spec.SecurityProfile = SecurityProfileRestricted
+		ssanginx.Spec.ConfigMapData["default.conf"] = unprivilegedconf
+		if nginx != nil {
+			delete(ssanginx.Spec.ConfigMapData, "default.conf")
+			ssanginx.Spec.Nginx = nginx
+		}
+		if podSC != nil {
+			ssanginx.Spec.DeploymentSpec.Template.Spec.WithSecurityContext(podSC)
+		}
+		if containerSC != nil {
+			ssanginx.Spec.DeploymentSpec.Template.Spec.Containers[0].WithSecurityContext(containerSC)
+		}
+		ctx := context.Background()
+		err := k8sClient.Create(ctx, ssanginx)
+
+		Expect(err).Should(HaveStatusErrorReason(Equal(metav1.StatusReasonInvalid)))
+		Expect(err.Error()).Should(ContainSubstring(message))
+	},
+		Entry("nginx listens on a privileged port.", &NginxSpec{ListenPorts: []int32{80}}, nil, nil, "Must be 1024 or above when securityProfile is Restricted."),
+		Entry("the pod runs as root.", nil, corev1apply.PodSecurityContext().WithRunAsUser(0), nil, "Cannot run as root when securityProfile is Restricted."),
+		Entry("the container is privileged.", nil, nil, corev1apply.SecurityContext().WithPrivileged(true), "Cannot be true when securityProfile is Restricted."),
+		Entry("the container adds a capability.", nil, nil, corev1apply.SecurityContext().WithCapabilities(corev1apply.Capabilities().WithAdd("NET_ADMIN")), "Only NET_BIND_SERVICE can be added"),
+	)
+
+	It("should reject a configmap listening on a privileged port with the Restricted security profile", func() {
+		ssanginx := testSSANginx(resouceName, int32(port))
+		ssanginx.Spec.SecurityProfile = SecurityProfileRestricted
+		err := k8sClient.Create(context.Background(), ssanginx)
+
+		Expect(err).Should(HaveStatusErrorReason(Equal(metav1.StatusReasonInvalid)))
+		Expect(err.Error()).Should(ContainSubstring(`spec.configMapData[default.conf]: Invalid value: "80"`))
+		Expect(err.Error()).Should(ContainSubstring("listen on line 2 must use a port of 1024 or above"))
+	})
+
+	It("should require a conf file unless the image is nginx-unprivileged with the Restricted security profile", func() {
+		ssanginx := testSSANginx(resouceName, int32(port))
+		ssanginx.Spec.SecurityProfile = SecurityProfileRestricted
+		delete(ssanginx.Spec.ConfigMapData, "default.conf")
+		err := k8sClient.Create(context.Background(), ssanginx)
+
+		Expect(err).Should(HaveStatusErrorReason(Equal(metav1.StatusReasonInvalid)))
+		Expect(err.Error()).Should(ContainSubstring("the default.conf of the nginx image listens on port 80"))
+
+		ssanginx.Spec.DeploymentSpec.Template.Spec.Containers[0].WithImage("nginxinc/nginx-unprivileged")
+		err = k8sClient.Create(context.Background(), ssanginx)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		err = k8sClient.Delete(context.Background(), ssanginx)
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+
+	It("should reject html files of the configmap with contentSource", func() {
+		ssanginx := testSSANginx(resouceName, int32(port))
+		ssanginx.Spec.ContentSource = &ContentSource{Type: ContentSourceImage, Image: &ImageContentSource{Image: "site"}}
+		err := k8sClient.Create(context.Background(), ssanginx)
+
+		Expect(err).Should(HaveStatusErrorReason(Equal(metav1.StatusReasonInvalid)))
+		Expect(err.Error()).Should(ContainSubstring("Cannot be mounted in the html root when contentSource is set."))
+	})
 })
@@ -0,0 +1,141 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// reloader reloads nginx when its configuration changes.
+//
+//	reloader install <path>           copies the binary to path, run by the init container
+//	reloader copy <src> <dst>         copies the content of an image, run by the content init container
+//	reloader extract <archive> <dst>  extracts a tar archive, run by the content init container
+//	reloader [flags]                  watches the configuration, run by the sidecar
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap/zapcore"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	"github.com/jnytnai0613/ssa-nginx-controller/pkg/constants"
+	"github.com/jnytnai0613/ssa-nginx-controller/pkg/content"
+	"github.com/jnytnai0613/ssa-nginx-controller/pkg/reloader"
+)
+
+func main() {
+	if ok, err := runCommand(os.Args[1:]); ok {
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	var (
+		watchDirs   string
+		nginxBinary string
+		procDir     string
+		metricsAddr string
+		debounce    time.Duration
+	)
+	flag.StringVar(&watchDirs, "watch-dirs", constants.ConfVolumeMountPath, "Comma separated list of directories to watch.")
+	flag.StringVar(&nginxBinary, "nginx-binary", "nginx", "The nginx binary validating the configuration.")
+	flag.StringVar(&procDir, "proc-dir", "/proc", "The mount point of procfs used to find the nginx master process.")
+	flag.StringVar(&metricsAddr, "metrics-bind-address", fmt.Sprintf(":%d", constants.ReloaderMetricsPort), "The address the metric endpoint binds to.")
+	flag.DurationVar(&debounce, "debounce", time.Second, "Delay of the reload after the last file change.")
+	opts := zap.Options{
+		TimeEncoder: zapcore.ISO8601TimeEncoder,
+	}
+	opts.BindFlags(flag.CommandLine)
+	flag.Parse()
+
+	log := zap.New(zap.UseFlagOptions(&opts)).WithName("reloader")
+
+	r := &reloader.Reloader{
+		NginxBinary: nginxBinary,
+		ProcDir:     procDir,
+		Debounce:    debounce,
+		Log:         log,
+	}
+	for _, dir := range strings.Split(watchDirs, ",") {
+		if dir = strings.TrimSpace(dir); dir != "" {
+			r.Dirs = append(r.Dirs, dir)
+		}
+	}
+
+	go func() {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.Handler())
+		if err := http.ListenAndServe(metricsAddr, mux); err != nil {
+			log.Error(err, "unable to serve metrics")
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if err := r.Run(ctx); err != nil {
+		log.Error(err, "problem running reloader")
+		os.Exit(1)
+	}
+}
+
+// Run the subcommands of the init containers. ok is false if args is not a subcommand.
+func runCommand(args []string) (ok bool, err error) {
+	switch {
+	case len(args) == 2 && args[0] == "install":
+		return true, install(args[1])
+	case len(args) == 3 && args[0] == "copy":
+		return true, content.Copy(args[1], args[2])
+	case len(args) == 3 && args[0] == "extract":
+		return true, content.Extract(args[1], args[2])
+	}
+
+	return false, nil
+}
+
+// Copy the running binary to path, so that it can be run in the nginx image
+func install(path string) error {
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	src, err := os.Open(self)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o755)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+
+	return dst.Close()
+}
@@ -0,0 +1,860 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	ssanginxv1 "github.com/jnytnai0613/ssa-nginx-controller/api/v1"
+	"github.com/jnytnai0613/ssa-nginx-controller/pkg/constants"
+	"github.com/jnytnai0613/ssa-nginx-controller/pkg/pki"
+)
+
+// Validity periods of the certificates, taking the defaults into account
+func certificateDurations(ssanginx ssanginxv1.SSANginx) (caDuration, duration, renewBefore time.Duration) {
+	caDuration = constants.DefaultCADuration
+	duration = constants.DefaultCertificateDuration
+	renewBefore = constants.DefaultRenewBefore
+
+	if p := ssanginx.Spec.PKI; p != nil {
+		if p.CADuration != nil {
+			caDuration = p.CADuration.Duration
+		}
+		if p.Duration != nil {
+			duration = p.Duration.Duration
+		}
+		if p.RenewBefore != nil {
+			renewBefore = p.RenewBefore.Duration
+		}
+	}
+
+	return caDuration, duration, renewBefore
+}
+
+// Check if the certificate should be issued again.
+// It is reissued when it was not signed by the current CA or expires within renewBefore.
+// A certificate expiring together with the CA cannot be extended, so it is kept.
+func needsReissue(issuer *pki.Issuer, crt []byte, renewBefore time.Duration) bool {
+	if !issuer.Issued(crt) {
+		return true
+	}
+
+	notAfter, err := pki.NotAfter(crt)
+	if err != nil {
+		return true
+	}
+	if !notAfter.Before(issuer.CANotAfter()) {
+		return false
+	}
+
+	return time.Until(notAfter) < renewBefore
+}
+
+// Issue the certificates used for Ingress TLS and client authentication.
+// Returns the name of the Secret whose ca.crt verifies the client certificates.
+// The server certificate is reissued in place when the hosts of the Ingress rules change.
+func (r *SSANginxReconciler) applyCertificates(ctx context.Context, fieldMgr string, log logr.Logger, ssanginx ssanginxv1.SSANginx) (string, error) {
+	if certManagerEnabled(ssanginx) {
+		if err := r.applyCertManagerResources(ctx, fieldMgr, log, ssanginx); err != nil {
+			log.Error(err, "Unable create cert-manager resources")
+			return "", err
+		}
+
+		// cert-manager issues the certificate asynchronously, so the Ingress
+		// waits until the Secret covers every host.
+		if err := r.verifyServerSecretHosts(ctx, ssanginx); err != nil {
+			return "", err
+		}
+		if err := r.adoptCertManagerSecrets(ctx, log, ssanginx); err != nil {
+			return "", err
+		}
+
+		return caSecretName(ssanginx), nil
+	}
+
+	if err := r.deleteCertManagerResources(ctx, log, ssanginx); err != nil {
+		return "", err
+	}
+
+	// Secrets provided by the user are only validated, never overwritten.
+	if err := r.validateExternalSecrets(ctx, ssanginx); err != nil {
+		log.Error(err, "Invalid TLS Secret")
+		return "", err
+	}
+	if externalServerSecret(ssanginx) && externalClientCASecret(ssanginx) {
+		return ssanginx.Spec.TLS.ClientCASecretName, nil
+	}
+
+	issuer, err := r.applyCASecret(ctx, fieldMgr, log, ssanginx)
+	if err != nil {
+		log.Error(err, "Unable create CA Secret")
+		return "", err
+	}
+
+	// The client certificates are handled first, so that the CRL
+	// reflecting their revocation is copied to the server Secret.
+	var crl []byte
+	if !externalClientCASecret(ssanginx) {
+		crl, err = r.applyClientSecrets(ctx, fieldMgr, log, ssanginx, issuer)
+		if err != nil {
+			log.Error(err, "Unable create Client Secret")
+			return "", err
+		}
+	}
+
+	if !externalServerSecret(ssanginx) {
+		if err := r.applyIngressSecret(ctx, fieldMgr, log, ssanginx, issuer, crl); err != nil {
+			log.Error(err, "Unable create Ingress Secret")
+			return "", err
+		}
+	}
+
+	switch {
+	case externalClientCASecret(ssanginx):
+		return ssanginx.Spec.TLS.ClientCASecretName, nil
+	case externalServerSecret(ssanginx):
+		// Without the generated server Secret, ca.crt and ca.crl are read from the CA keypair Secret.
+		return caSecretName(ssanginx), nil
+	default:
+		return ingressSecretName(ssanginx), nil
+	}
+}
+
+// pendingCertificateError is returned while the server certificate does not cover
+// every host yet. The Ingress keeps its current state and the reconcile is requeued.
+type pendingCertificateError struct {
+	name string
+	err  error
+}
+
+func (e *pendingCertificateError) Error() string {
+	return fmt.Sprintf("waiting for the certificate in Secret %q: %v", e.name, e.err)
+}
+
+func (e *pendingCertificateError) Unwrap() error {
+	return e.err
+}
+
+// Check that the server certificate issued by cert-manager covers every host and SAN.
+func (r *SSANginxReconciler) verifyServerSecretHosts(ctx context.Context, ssanginx ssanginxv1.SSANginx) error {
+	var (
+		secret     corev1.Secret
+		secretName = ingressSecretName(ssanginx)
+	)
+
+	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: ssanginx.GetNamespace(), Name: secretName}, &secret); err != nil {
+		if errors.IsNotFound(err) {
+			return &pendingCertificateError{name: secretName, err: err}
+		}
+		return err
+	}
+
+	hosts := pki.DNSNames(ssanginx)
+	hosts = append(hosts, ssanginx.Spec.TLS.IPAddresses...)
+	if err := pki.VerifyHosts(secret.Data[corev1.TLSCertKey], hosts); err != nil {
+		return &pendingCertificateError{name: secretName, err: err}
+	}
+
+	return nil
+}
+
+// Check if the server keypair is provided by the user
+func externalServerSecret(ssanginx ssanginxv1.SSANginx) bool {
+	return ssanginx.Spec.TLS != nil && ssanginx.Spec.TLS.ServerSecretName != ""
+}
+
+// Check if the CA bundle verifying client certificates is provided by the user
+func externalClientCASecret(ssanginx ssanginxv1.SSANginx) bool {
+	return ssanginx.Spec.TLS != nil && ssanginx.Spec.TLS.ClientCASecretName != ""
+}
+
+// Name of the Secret referenced by the TLS section of the Ingress
+func serverSecretName(ssanginx ssanginxv1.SSANginx) string {
+	if externalServerSecret(ssanginx) {
+		return ssanginx.Spec.TLS.ServerSecretName
+	}
+
+	return ingressSecretName(ssanginx)
+}
+
+// Name of the Secret holding the server certificate issued for the SSANginx
+func ingressSecretName(ssanginx ssanginxv1.SSANginx) string {
+	return fmt.Sprintf("%s-%s", ssanginx.GetName(), constants.ServerSecretSuffix)
+}
+
+// invalidSecretError is returned when a Secret referenced by spec.tls cannot be used.
+// It is reported through the TLSSecretsValid condition, and the Secret is validated
+// again when it changes.
+type invalidSecretError struct {
+	name string
+	err  error
+}
+
+func (e *invalidSecretError) Error() string {
+	return fmt.Sprintf("Secret %q is invalid: %v", e.name, e.err)
+}
+
+func (e *invalidSecretError) Unwrap() error {
+	return e.err
+}
+
+// Validate the Secrets referenced by spec.tls.
+// The server keypair must match, cover every Ingress host and SAN of spec.tls, and be unexpired.
+// The client CA bundle must contain unexpired CA certificates.
+func (r *SSANginxReconciler) validateExternalSecrets(ctx context.Context, ssanginx ssanginxv1.SSANginx) error {
+	if !externalServerSecret(ssanginx) && !externalClientCASecret(ssanginx) {
+		return nil
+	}
+
+	hosts := pki.DNSNames(ssanginx)
+	hosts = append(hosts, ssanginx.Spec.TLS.IPAddresses...)
+
+	getSecret := func(name string) (*corev1.Secret, error) {
+		var secret corev1.Secret
+		if err := r.Client.Get(ctx, client.ObjectKey{Namespace: ssanginx.GetNamespace(), Name: name}, &secret); err != nil {
+			if errors.IsNotFound(err) {
+				return nil, &invalidSecretError{name: name, err: err}
+			}
+			return nil, err
+		}
+
+		return &secret, nil
+	}
+
+	if externalServerSecret(ssanginx) {
+		name := ssanginx.Spec.TLS.ServerSecretName
+		secret, err := getSecret(name)
+		if err != nil {
+			return err
+		}
+		if err := pki.ValidateKeyPair(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey], hosts, time.Now()); err != nil {
+			return &invalidSecretError{name: name, err: err}
+		}
+	}
+
+	if externalClientCASecret(ssanginx) {
+		name := ssanginx.Spec.TLS.ClientCASecretName
+		secret, err := getSecret(name)
+		if err != nil {
+			return err
+		}
+		if err := pki.ValidateCABundle(secret.Data["ca.crt"], time.Now()); err != nil {
+			return &invalidSecretError{name: name, err: err}
+		}
+	}
+
+	return nil
+}
+
+// Names of the Secrets provided by the user in spec.tls
+func externalSecretNames(ssanginx ssanginxv1.SSANginx) []string {
+	var names []string
+	if externalServerSecret(ssanginx) {
+		names = append(names, ssanginx.Spec.TLS.ServerSecretName)
+	}
+	if externalClientCASecret(ssanginx) {
+		names = append(names, ssanginx.Spec.TLS.ClientCASecretName)
+	}
+
+	return names
+}
+
+// Map a Secret to the SSANginxes referring to it in spec.tls,
+// so that they are validated again when the user replaces the Secret.
+func (r *SSANginxReconciler) ssanginxesForSecret(obj client.Object) []reconcile.Request {
+	var (
+		ssanginxList ssanginxv1.SSANginxList
+		requests     []reconcile.Request
+	)
+
+	if err := r.Client.List(context.Background(), &ssanginxList,
+		client.InNamespace(obj.GetNamespace()),
+		client.MatchingFields{constants.IndexTLSSecretKey: obj.GetName()}); err != nil {
+		r.Log.Error(err, "unable to list SSANginx referring to Secret", "secret", obj.GetName())
+		return nil
+	}
+
+	for _, ssanginx := range ssanginxList.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: client.ObjectKeyFromObject(&ssanginx),
+		})
+	}
+
+	return requests
+}
+
+// Name of the Secret holding the CA keypair of the SSANginx
+func caSecretName(ssanginx ssanginxv1.SSANginx) string {
+	return fmt.Sprintf("%s-%s", ssanginx.GetName(), constants.CASecretSuffix)
+}
+
+// Load the CA keypair from its Secret, or create it if it does not exist yet.
+// The CA private key is persisted so that server and client certificates can be
+// issued again after the controller restarts.
+func (r *SSANginxReconciler) applyCASecret(ctx context.Context, fieldMgr string, log logr.Logger, ssanginx ssanginxv1.SSANginx) (*pki.Issuer, error) {
+	var (
+		secret       corev1.Secret
+		secretClient = r.Clientset.CoreV1().Secrets(ssanginx.GetNamespace())
+	)
+
+	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: ssanginx.GetNamespace(), Name: caSecretName(ssanginx)}, &secret); err != nil {
+		// If the resource does not exist, create it.
+		// Therefore, Not Found errors are ignored.
+		if !errors.IsNotFound(err) {
+			return nil, err
+		}
+	}
+
+	var (
+		caCrt []byte
+		caKey []byte
+	)
+
+	caDuration, _, renewBefore := certificateDurations(ssanginx)
+
+	if len(secret.GetName()) > 0 {
+		issuer, err := pki.NewIssuer(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+		if err != nil {
+			return nil, err
+		}
+		caCrt, caKey = secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey]
+
+		rotateCA := ssanginx.Spec.PKI != nil && ssanginx.Spec.PKI.RotateCA
+		if time.Until(issuer.CANotAfter()) < renewBefore {
+			if rotateCA {
+				log.Info(fmt.Sprintf("rotate CA certificate: %s", secret.GetName()))
+				r.Recorder.Eventf(&ssanginx, corev1.EventTypeNormal, "Renewed", "Renewing CA certificate in Secret %q", secret.GetName())
+				caCrt, caKey = nil, nil
+			} else {
+				r.Recorder.Eventf(&ssanginx, corev1.EventTypeWarning, "CAExpiring",
+					"CA certificate in Secret %q expires at %s and rotateCA is disabled", secret.GetName(), issuer.CANotAfter().Format(time.RFC3339))
+			}
+		}
+
+		// CA keypair Secrets created by older versions have no ca.crt,
+		// which is read by ingress-nginx when the server keypair is provided by the user.
+		if caCrt != nil && bytes.Equal(secret.Data["ca.crt"], caCrt) {
+			return issuer, nil
+		}
+	}
+
+	if caCrt == nil {
+		var err error
+		caCrt, caKey, err = pki.CreateCaCrt(ssanginx, caDuration)
+		if err != nil {
+			log.Error(err, "Unable create CA Certificates")
+			return nil, err
+		}
+	}
+
+	issuer, err := pki.NewIssuer(caCrt, caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	secData := map[string][]byte{
+		corev1.TLSCertKey:       caCrt,
+		corev1.TLSPrivateKeyKey: caKey,
+		"ca.crt":                caCrt,
+	}
+
+	nextCASecretApplyConfig := corev1apply.Secret(caSecretName(ssanginx), ssanginx.GetNamespace()).
+		WithLabels(commonLabels(ssanginx)).
+		WithAnnotations(commonAnnotations(ssanginx)).
+		WithType(corev1.SecretTypeTLS).
+		WithData(secData)
+
+	owner, err := createOwnerReferences(log, ssanginx, r.Scheme)
+	if err != nil {
+		log.Error(err, "Unable create OwnerReference")
+		return nil, err
+	}
+	nextCASecretApplyConfig.WithOwnerReferences(owner)
+
+	applied, err := secretClient.Apply(ctx, nextCASecretApplyConfig, metav1.ApplyOptions{
+		FieldManager: fieldMgr,
+		Force:        true,
+	})
+	if err != nil {
+		log.Error(err, "unable to apply")
+		return nil, err
+	}
+
+	log.Info(fmt.Sprintf("Nginx CA Keypair Secret Applied: %s", applied.GetName()))
+
+	return issuer, nil
+}
+
+// Issue the server certificate into "<name>-server-tls".
+// ca.crt and ca.crl are stored with it, since the Secret is also referenced by auth-tls-secret.
+func (r *SSANginxReconciler) applyIngressSecret(ctx context.Context, fieldMgr string, log logr.Logger, ssanginx ssanginxv1.SSANginx, issuer *pki.Issuer, crl []byte) error {
+	var (
+		secret       corev1.Secret
+		secretName   = ingressSecretName(ssanginx)
+		secretClient = r.Clientset.CoreV1().Secrets(ssanginx.GetNamespace())
+	)
+
+	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: ssanginx.GetNamespace(), Name: secretName}, &secret); err != nil {
+		// If the resource does not exist, create it.
+		// Therefore, Not Found errors are ignored.
+		if !errors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	_, duration, renewBefore := certificateDurations(ssanginx)
+
+	// Reissue the certificate in place when it is about to expire,
+	// the CA has been rotated or spec.pki has changed. Otherwise only the CRL is updated.
+	svrCrt, svrKey := secret.Data["tls.crt"], secret.Data["tls.key"]
+	if len(secret.GetName()) == 0 ||
+		!bytes.Equal(secret.Data["ca.crt"], issuer.CACrt()) ||
+		needsReissue(issuer, svrCrt, renewBefore) ||
+		!pki.ServerCrtMatches(svrCrt, ssanginx) {
+		if len(secret.GetName()) > 0 {
+			log.Info(fmt.Sprintf("renew server certificate: %s", secret.GetName()))
+			r.Recorder.Eventf(&ssanginx, corev1.EventTypeNormal, "Renewed", "Renewing server certificate in Secret %q", secret.GetName())
+		}
+
+		var err error
+		svrCrt, svrKey, err = issuer.CreateSvrCrt(ssanginx, duration)
+		if err != nil {
+			log.Error(err, "Unable create Server Certificates")
+			return err
+		}
+	} else if bytes.Equal(secret.Data["ca.crl"], crl) {
+		return nil
+	}
+
+	secData := map[string][]byte{
+		"tls.crt": svrCrt,
+		"tls.key": svrKey,
+		"ca.crt":  issuer.CACrt(),
+	}
+	if len(crl) > 0 {
+		secData["ca.crl"] = crl
+	}
+
+	nextIngressSecretApplyConfig := corev1apply.Secret(secretName, ssanginx.GetNamespace()).
+		WithLabels(commonLabels(ssanginx)).
+		WithAnnotations(commonAnnotations(ssanginx)).
+		WithData(secData)
+
+	owner, err := createOwnerReferences(log, ssanginx, r.Scheme)
+	if err != nil {
+		log.Error(err, "Unable create OwnerReference")
+		return err
+	}
+	nextIngressSecretApplyConfig.WithOwnerReferences(owner)
+
+	applied, err := secretClient.Apply(ctx, nextIngressSecretApplyConfig, metav1.ApplyOptions{
+		FieldManager: fieldMgr,
+		Force:        true,
+	})
+	if err != nil {
+		log.Error(err, "unable to apply")
+		return err
+	}
+
+	log.Info(fmt.Sprintf("Nginx Server Certificates Secret Applied: %s", applied.GetName()))
+
+	return nil
+}
+
+// Client certificates issued for the SSANginx.
+// Without spec.clientCertificates, a single certificate with CommonName "client" is issued.
+func clientCertificates(ssanginx ssanginxv1.SSANginx) []ssanginxv1.ClientCertificate {
+	if len(ssanginx.Spec.ClientCertificates) > 0 {
+		return ssanginx.Spec.ClientCertificates
+	}
+
+	return []ssanginxv1.ClientCertificate{{Name: constants.DefaultClientName}}
+}
+
+// Name of the Secret holding the client certificate.
+// The default client uses "<name>-client".
+func clientSecretName(ssanginx ssanginxv1.SSANginx, name string) string {
+	if len(ssanginx.Spec.ClientCertificates) == 0 {
+		return fmt.Sprintf("%s-%s", ssanginx.GetName(), constants.ClientSecretSuffix)
+	}
+
+	return fmt.Sprintf("%s-%s-%s", ssanginx.GetName(), constants.ClientSecretSuffix, name)
+}
+
+// Issue a client certificate for each entry and revoke the ones no longer listed.
+// Returns the CRL of the CA.
+func (r *SSANginxReconciler) applyClientSecrets(ctx context.Context, fieldMgr string, log logr.Logger, ssanginx ssanginxv1.SSANginx, issuer *pki.Issuer) ([]byte, error) {
+	for _, cc := range clientCertificates(ssanginx) {
+		if err := r.applyClientSecret(ctx, fieldMgr, log, ssanginx, issuer, cc); err != nil {
+			return nil, err
+		}
+	}
+
+	return r.revokeClientSecrets(ctx, fieldMgr, log, ssanginx, issuer)
+}
+
+func (r *SSANginxReconciler) applyClientSecret(ctx context.Context, fieldMgr string, log logr.Logger, ssanginx ssanginxv1.SSANginx, issuer *pki.Issuer, cc ssanginxv1.ClientCertificate) error {
+	var (
+		secret       corev1.Secret
+		secretClient = r.Clientset.CoreV1().Secrets(ssanginx.GetNamespace())
+		secretName   = clientSecretName(ssanginx, cc.Name)
+	)
+
+	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: ssanginx.GetNamespace(), Name: secretName}, &secret); err != nil {
+		// If the resource does not exist, create it.
+		// Therefore, Not Found errors are ignored.
+		if !errors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	_, duration, renewBefore := certificateDurations(ssanginx)
+	if cc.Duration != nil {
+		duration = cc.Duration.Duration
+	}
+
+	// Reissue the certificate in place when it is about to expire,
+	// the CA has been rotated or the subject or key algorithm has changed.
+	if len(secret.GetName()) > 0 {
+		if !needsReissue(issuer, secret.Data["client.crt"], renewBefore) &&
+			pki.ClientCrtMatches(secret.Data["client.crt"], ssanginx, cc) {
+			return nil
+		}
+
+		log.Info(fmt.Sprintf("renew client certificate: %s", secret.GetName()))
+		r.Recorder.Eventf(&ssanginx, corev1.EventTypeNormal, "Renewed", "Renewing client certificate in Secret %q", secret.GetName())
+	}
+
+	cliCrt, cliKey, err := issuer.CreateClientCrt(ssanginx, cc, duration)
+	if err != nil {
+		log.Error(err, "Unable create Client Certificates")
+		return err
+	}
+
+	secData := map[string][]byte{
+		"client.crt": cliCrt,
+		"client.key": cliKey,
+	}
+
+	nextClientSecretApplyConfig := corev1apply.Secret(secretName, ssanginx.GetNamespace()).
+		WithLabels(commonLabels(ssanginx)).
+		WithLabels(map[string]string{constants.LabelClientCertificate: cc.Name}).
+		WithAnnotations(commonAnnotations(ssanginx)).
+		WithData(secData)
+
+	owner, err := createOwnerReferences(log, ssanginx, r.Scheme)
+	if err != nil {
+		log.Error(err, "Unable create OwnerReference")
+		return err
+	}
+	nextClientSecretApplyConfig.WithOwnerReferences(owner)
+
+	applied, err := secretClient.Apply(ctx, nextClientSecretApplyConfig, metav1.ApplyOptions{
+		FieldManager: fieldMgr,
+		Force:        true,
+	})
+	if err != nil {
+		log.Error(err, "unable to apply")
+		return err
+	}
+
+	log.Info(fmt.Sprintf("Nginx Client Certificates Secret Applied: %s", applied.GetName()))
+
+	return nil
+}
+
+// Delete the Secrets of client certificates removed from spec.clientCertificates.
+// Their serial numbers are added to the CRL before the Secrets are deleted,
+// so that a failed update is retried on the next reconcile.
+// cli-secret created by older versions is also recognized by its name.
+func (r *SSANginxReconciler) revokeClientSecrets(ctx context.Context, fieldMgr string, log logr.Logger, ssanginx ssanginxv1.SSANginx, issuer *pki.Issuer) ([]byte, error) {
+	var (
+		secrets       corev1.SecretList
+		revoked       []corev1.Secret
+		serialNumbers []string
+		desired       = make(map[string]bool)
+	)
+
+	for _, cc := range clientCertificates(ssanginx) {
+		desired[clientSecretName(ssanginx, cc.Name)] = true
+	}
+
+	if err := r.Client.List(ctx, &secrets, client.InNamespace(ssanginx.GetNamespace()),
+		client.MatchingFields(map[string]string{constants.IndexOwnerKey: ssanginx.GetName()})); err != nil {
+		return nil, err
+	}
+
+	for _, secret := range secrets.Items {
+		_, isClient := secret.GetLabels()[constants.LabelClientCertificate]
+		if !isClient && secret.GetName() != constants.LegacyClientSecretName {
+			continue
+		}
+		if desired[secret.GetName()] {
+			continue
+		}
+
+		revoked = append(revoked, secret)
+		// Certificates of a previous CA are no longer trusted and need no entry.
+		if serialNumber, err := pki.SerialNumber(secret.Data["client.crt"]); err == nil && issuer.Issued(secret.Data["client.crt"]) {
+			serialNumbers = append(serialNumbers, serialNumber)
+		}
+	}
+
+	crl, err := r.applyCRL(ctx, fieldMgr, log, ssanginx, issuer, serialNumbers)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, secret := range revoked {
+		if err := r.Client.Delete(ctx, &secret); client.IgnoreNotFound(err) != nil {
+			return nil, err
+		}
+		log.Info(fmt.Sprintf("revoke client certificate: %s", secret.GetName()))
+		r.Recorder.Eventf(&ssanginx, corev1.EventTypeNormal, "Revoked", "Revoked client certificate in Secret %q", secret.GetName())
+	}
+
+	return crl, nil
+}
+
+// Keep the CRL in the CA keypair Secret up to date.
+// It is reissued when serial numbers are revoked, the CA has been rotated,
+// or its nextUpdate is within CRLRenewBefore.
+// The Secret is read from the API server instead of the cache, since
+// a stale CRL would drop the entries revoked since then.
+func (r *SSANginxReconciler) applyCRL(ctx context.Context, fieldMgr string, log logr.Logger, ssanginx ssanginxv1.SSANginx, issuer *pki.Issuer, revoke []string) ([]byte, error) {
+	secretClient := r.Clientset.CoreV1().Secrets(ssanginx.GetNamespace())
+
+	secret, err := secretClient.Get(ctx, caSecretName(ssanginx), metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	crl := secret.Data["ca.crl"]
+	if len(revoke) == 0 && !issuer.CRLNeedsUpdate(crl, constants.CRLRenewBefore) {
+		return crl, nil
+	}
+
+	crl, err = issuer.CreateCRL(crl, revoke, constants.CRLDuration)
+	if err != nil {
+		log.Error(err, "Unable create CRL")
+		return nil, err
+	}
+
+	secData := map[string][]byte{
+		corev1.TLSCertKey:       secret.Data[corev1.TLSCertKey],
+		corev1.TLSPrivateKeyKey: secret.Data[corev1.TLSPrivateKeyKey],
+		"ca.crt":                secret.Data["ca.crt"],
+		"ca.crl":                crl,
+	}
+
+	nextCASecretApplyConfig := corev1apply.Secret(caSecretName(ssanginx), ssanginx.GetNamespace()).
+		WithLabels(commonLabels(ssanginx)).
+		WithAnnotations(commonAnnotations(ssanginx)).
+		WithType(corev1.SecretTypeTLS).
+		WithData(secData)
+
+	owner, err := createOwnerReferences(log, ssanginx, r.Scheme)
+	if err != nil {
+		log.Error(err, "Unable create OwnerReference")
+		return nil, err
+	}
+	nextCASecretApplyConfig.WithOwnerReferences(owner)
+
+	applied, err := secretClient.Apply(ctx, nextCASecretApplyConfig, metav1.ApplyOptions{
+		FieldManager: fieldMgr,
+		Force:        true,
+	})
+	if err != nil {
+		log.Error(err, "unable to apply")
+		return nil, err
+	}
+
+	log.Info(fmt.Sprintf("Nginx CRL Applied: %s", applied.GetName()))
+
+	return crl, nil
+}
+
+// Read the expiry of the generated certificates from their Secrets.
+func (r *SSANginxReconciler) certificatesStatus(ctx context.Context, ssanginx ssanginxv1.SSANginx) (*ssanginxv1.CertificatesStatus, error) {
+	var (
+		status  ssanginxv1.CertificatesStatus
+		secrets = []struct {
+			name     string
+			key      string
+			notAfter **metav1.Time
+		}{
+			{caSecretName(ssanginx), corev1.TLSCertKey, &status.CANotAfter},
+			{ingressSecretName(ssanginx), "tls.crt", &status.ServerNotAfter},
+		}
+		clientKey = "client.crt"
+	)
+
+	// cert-manager stores every certificate as tls.crt
+	if certManagerEnabled(ssanginx) {
+		clientKey = corev1.TLSCertKey
+	}
+	if externalServerSecret(ssanginx) {
+		secrets[1].name = ssanginx.Spec.TLS.ServerSecretName
+	}
+	if externalClientCASecret(ssanginx) {
+		secrets[0].name, secrets[0].key = ssanginx.Spec.TLS.ClientCASecretName, "ca.crt"
+	}
+
+	getSecret := func(name string) (*corev1.Secret, error) {
+		var secret corev1.Secret
+		if err := r.Client.Get(ctx, client.ObjectKey{Namespace: ssanginx.GetNamespace(), Name: name}, &secret); err != nil {
+			return nil, client.IgnoreNotFound(err)
+		}
+
+		return &secret, nil
+	}
+
+	for _, s := range secrets {
+		secret, err := getSecret(s.name)
+		if err != nil {
+			return nil, err
+		}
+		if secret == nil {
+			continue
+		}
+
+		notAfter, err := pki.NotAfter(secret.Data[s.key])
+		if err != nil {
+			continue
+		}
+		t := metav1.NewTime(notAfter)
+		*s.notAfter = &t
+	}
+
+	// No client certificate is issued for a CA bundle provided by the user.
+	if externalClientCASecret(ssanginx) {
+		return &status, nil
+	}
+
+	// cert-manager does not publish a CRL
+	if !certManagerEnabled(ssanginx) {
+		secret, err := getSecret(caSecretName(ssanginx))
+		if err != nil {
+			return nil, err
+		}
+		if secret != nil {
+			if nextUpdate, err := pki.CRLNextUpdate(secret.Data["ca.crl"]); err == nil {
+				t := metav1.NewTime(nextUpdate)
+				status.CRLNextUpdate = &t
+			}
+		}
+	}
+
+	for _, cc := range clientCertificates(ssanginx) {
+		secret, err := getSecret(clientSecretName(ssanginx, cc.Name))
+		if err != nil {
+			return nil, err
+		}
+		if secret == nil {
+			continue
+		}
+
+		clientStatus := ssanginxv1.ClientCertificateStatus{
+			Name:       cc.Name,
+			SecretName: secret.GetName(),
+		}
+		if serialNumber, err := pki.SerialNumber(secret.Data[clientKey]); err == nil {
+			clientStatus.SerialNumber = serialNumber
+		}
+		if notAfter, err := pki.NotAfter(secret.Data[clientKey]); err == nil {
+			t := metav1.NewTime(notAfter)
+			clientStatus.NotAfter = &t
+			if status.ClientNotAfter == nil || t.Before(status.ClientNotAfter) {
+				status.ClientNotAfter = &t
+			}
+		}
+		status.Clients = append(status.Clients, clientStatus)
+	}
+
+	return &status, nil
+}
+
+// Time until the earliest certificate has to be renewed.
+// Zero means that there is nothing to renew.
+func certificateRequeueAfter(ssanginx ssanginxv1.SSANginx) time.Duration {
+	var (
+		certs = ssanginx.Status.Certificates
+		next  time.Duration
+	)
+	_, _, renewBefore := certificateDurations(ssanginx)
+
+	// cert-manager renews the certificates by itself
+	if !ssanginx.Spec.IngressSecureEnabled || certs == nil || certManagerEnabled(ssanginx) {
+		return 0
+	}
+
+	// Certificates provided by the user are not renewed by the controller.
+	var candidates []*metav1.Time
+	if !externalServerSecret(ssanginx) {
+		candidates = append(candidates, certs.ServerNotAfter)
+	}
+	if !externalClientCASecret(ssanginx) {
+		candidates = append(candidates, certs.ClientNotAfter)
+	}
+	if !externalClientCASecret(ssanginx) && ssanginx.Spec.PKI != nil && ssanginx.Spec.PKI.RotateCA {
+		candidates = append(candidates, certs.CANotAfter)
+	}
+
+	for _, notAfter := range candidates {
+		if notAfter == nil {
+			continue
+		}
+		// Certificates expiring together with the CA are renewed only by rotating the CA.
+		if notAfter != certs.CANotAfter && certs.CANotAfter != nil && !notAfter.Before(certs.CANotAfter) {
+			continue
+		}
+
+		d := time.Until(notAfter.Add(-renewBefore))
+		if d <= 0 {
+			d = constants.RenewRetryInterval
+		}
+		if next == 0 || d < next {
+			next = d
+		}
+	}
+
+	// The CRL is reissued long before the certificates, so it has its own window.
+	if nextUpdate := certs.CRLNextUpdate; nextUpdate != nil &&
+		(certs.CANotAfter == nil || nextUpdate.Before(certs.CANotAfter)) {
+		d := time.Until(nextUpdate.Add(-constants.CRLRenewBefore))
+		if d <= 0 {
+			d = constants.RenewRetryInterval
+		}
+		if next == 0 || d < next {
+			next = d
+		}
+	}
+
+	return next
+}
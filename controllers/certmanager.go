@@ -0,0 +1,388 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	ssanginxv1 "github.com/jnytnai0613/ssa-nginx-controller/api/v1"
+	"github.com/jnytnai0613/ssa-nginx-controller/pkg/constants"
+	"github.com/jnytnai0613/ssa-nginx-controller/pkg/pki"
+)
+
+// The cert-manager resources are handled as unstructured objects,
+// so that the controller does not depend on the cert-manager API module
+// and keeps working in clusters without cert-manager.
+var (
+	certificateGVK = schema.GroupVersionKind{Group: constants.CertManagerGroup, Version: constants.CertManagerVersion, Kind: "Certificate"}
+	issuerGVK      = schema.GroupVersionKind{Group: constants.CertManagerGroup, Version: constants.CertManagerVersion, Kind: "Issuer"}
+)
+
+// Check if the certificates of the SSANginx are issued by cert-manager
+func certManagerEnabled(ssanginx ssanginxv1.SSANginx) bool {
+	return ssanginx.Spec.TLS != nil &&
+		ssanginx.Spec.TLS.Mode == ssanginxv1.TLSModeCertManager &&
+		ssanginx.Spec.TLS.CertManager != nil
+}
+
+func certManagerResourceName(ssanginx ssanginxv1.SSANginx, suffix string) string {
+	return fmt.Sprintf("%s-%s", ssanginx.GetName(), suffix)
+}
+
+func issuerRef(ref ssanginxv1.IssuerReference) map[string]interface{} {
+	kind := ref.Kind
+	if kind == "" {
+		kind = "Issuer"
+	}
+	group := ref.Group
+	if group == "" {
+		group = constants.CertManagerGroup
+	}
+
+	return map[string]interface{}{
+		"name":  ref.Name,
+		"kind":  kind,
+		"group": group,
+	}
+}
+
+// privateKey of a cert-manager Certificate derived from spec.pki
+func certManagerPrivateKey(ssanginx ssanginxv1.SSANginx) map[string]interface{} {
+	privateKey := map[string]interface{}{
+		"algorithm": "RSA",
+		"size":      int64(2048),
+		"encoding":  string(ssanginxv1.KeyEncodingPKCS8),
+	}
+	if ssanginx.Spec.PKI == nil {
+		return privateKey
+	}
+
+	switch ssanginx.Spec.PKI.KeyAlgorithm {
+	case ssanginxv1.KeyAlgorithmRSA3072:
+		privateKey["size"] = int64(3072)
+	case ssanginxv1.KeyAlgorithmRSA4096:
+		privateKey["size"] = int64(4096)
+	case ssanginxv1.KeyAlgorithmECDSAP256:
+		privateKey["algorithm"], privateKey["size"] = "ECDSA", int64(256)
+	case ssanginxv1.KeyAlgorithmECDSAP384:
+		privateKey["algorithm"], privateKey["size"] = "ECDSA", int64(384)
+	case ssanginxv1.KeyAlgorithmEd25519:
+		privateKey["algorithm"] = "Ed25519"
+		delete(privateKey, "size")
+	}
+	if ssanginx.Spec.PKI.KeyEncoding != "" {
+		privateKey["encoding"] = string(ssanginx.Spec.PKI.KeyEncoding)
+	}
+
+	return privateKey
+}
+
+// Unstructured objects only hold []interface{} slices
+func stringSlice(values []string) []interface{} {
+	items := make([]interface{}, 0, len(values))
+	for _, v := range values {
+		items = append(items, v)
+	}
+
+	return items
+}
+
+// subject of a cert-manager Certificate derived from spec.pki.subject
+func certManagerSubject(ssanginx ssanginxv1.SSANginx) map[string]interface{} {
+	subject := make(map[string]interface{})
+	if ssanginx.Spec.PKI == nil || ssanginx.Spec.PKI.Subject == nil {
+		return subject
+	}
+
+	fields := map[string][]string{
+		"organizations":       ssanginx.Spec.PKI.Subject.Organizations,
+		"organizationalUnits": ssanginx.Spec.PKI.Subject.OrganizationalUnits,
+		"countries":           ssanginx.Spec.PKI.Subject.Countries,
+		"provinces":           ssanginx.Spec.PKI.Subject.Provinces,
+		"localities":          ssanginx.Spec.PKI.Subject.Localities,
+	}
+	for k, values := range fields {
+		if len(values) == 0 {
+			continue
+		}
+		subject[k] = stringSlice(values)
+	}
+
+	return subject
+}
+
+// Build an unstructured object owned by the SSANginx, used for the resources of optional CRDs
+func (r *SSANginxReconciler) newOwnedObject(ssanginx ssanginxv1.SSANginx, gvk schema.GroupVersionKind, name string, spec map[string]interface{}) (*unstructured.Unstructured, error) {
+	ownerGVK, err := apiutil.GVKForObject(&ssanginx, r.Scheme)
+	if err != nil {
+		return nil, err
+	}
+
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(gvk)
+	obj.SetNamespace(ssanginx.GetNamespace())
+	obj.SetName(name)
+	obj.SetLabels(commonLabels(ssanginx))
+	obj.SetAnnotations(commonAnnotations(ssanginx))
+	obj.SetOwnerReferences([]metav1.OwnerReference{*metav1.NewControllerRef(&ssanginx, ownerGVK)})
+	if err := unstructured.SetNestedMap(obj.Object, spec, "spec"); err != nil {
+		return nil, err
+	}
+
+	return obj, nil
+}
+
+// Create the cert-manager resources issuing the certificates of the SSANginx.
+//   - Certificate "<name>-server" for every Ingress host signed by spec.tls.certManager.issuerRef into the Secret "<name>-server-tls"
+//   - Certificate "<name>-ca" signed by clientCAIssuerRef (or a self-signed Issuer) into the CA keypair Secret
+//   - Issuer "<name>-ca" signing client certificates with that CA
+//   - Certificate "<name>-client" into the Secret "<name>-client"
+//
+// The CA keypair Secret contains ca.crt, so it is used for auth-tls-secret of the Ingress.
+func (r *SSANginxReconciler) applyCertManagerResources(ctx context.Context, fieldMgr string, log logr.Logger, ssanginx ssanginxv1.SSANginx) error {
+	var (
+		objs                              []*unstructured.Unstructured
+		certManager                       = ssanginx.Spec.TLS.CertManager
+		caDuration, duration, renewBefore = certificateDurations(ssanginx)
+	)
+
+	clientCAIssuerRef := certManager.ClientCAIssuerRef
+	if clientCAIssuerRef == nil {
+		selfSigned, err := r.newOwnedObject(ssanginx, issuerGVK,
+			certManagerResourceName(ssanginx, constants.SelfSignedIssuerSuffix),
+			map[string]interface{}{
+				"selfSigned": map[string]interface{}{},
+			})
+		if err != nil {
+			return err
+		}
+		objs = append(objs, selfSigned)
+		clientCAIssuerRef = &ssanginxv1.IssuerReference{Name: selfSigned.GetName(), Kind: "Issuer"}
+	}
+
+	caCertificate, err := r.newOwnedObject(ssanginx, certificateGVK,
+		certManagerResourceName(ssanginx, constants.CACertificateSuffix),
+		map[string]interface{}{
+			"isCA":        true,
+			"privateKey":  certManagerPrivateKey(ssanginx),
+			"subject":     certManagerSubject(ssanginx),
+			"commonName":  "ca",
+			"secretName":  caSecretName(ssanginx),
+			"duration":    caDuration.String(),
+			"renewBefore": renewBefore.String(),
+			"issuerRef":   issuerRef(*clientCAIssuerRef),
+		})
+	if err != nil {
+		return err
+	}
+
+	caIssuer, err := r.newOwnedObject(ssanginx, issuerGVK,
+		certManagerResourceName(ssanginx, constants.CAIssuerSuffix),
+		map[string]interface{}{
+			"ca": map[string]interface{}{
+				"secretName": caSecretName(ssanginx),
+			},
+		})
+	if err != nil {
+		return err
+	}
+
+	serverSpec := map[string]interface{}{
+		"secretName":  ingressSecretName(ssanginx),
+		"privateKey":  certManagerPrivateKey(ssanginx),
+		"subject":     certManagerSubject(ssanginx),
+		"dnsNames":    stringSlice(pki.DNSNames(ssanginx)),
+		"duration":    duration.String(),
+		"renewBefore": renewBefore.String(),
+		"usages":      []interface{}{"digital signature", "key encipherment", "server auth"},
+		"issuerRef":   issuerRef(certManager.IssuerRef),
+	}
+	if len(ssanginx.Spec.TLS.IPAddresses) > 0 {
+		serverSpec["ipAddresses"] = stringSlice(ssanginx.Spec.TLS.IPAddresses)
+	}
+	serverCertificate, err := r.newOwnedObject(ssanginx, certificateGVK,
+		certManagerResourceName(ssanginx, constants.ServerCertificateSuffix), serverSpec)
+	if err != nil {
+		return err
+	}
+
+	clientCertificate, err := r.newOwnedObject(ssanginx, certificateGVK,
+		certManagerResourceName(ssanginx, constants.ClientCertificateSuffix),
+		map[string]interface{}{
+			"secretName":  clientSecretName(ssanginx, constants.DefaultClientName),
+			"privateKey":  certManagerPrivateKey(ssanginx),
+			"subject":     certManagerSubject(ssanginx),
+			"commonName":  "client",
+			"duration":    duration.String(),
+			"renewBefore": renewBefore.String(),
+			"usages":      []interface{}{"digital signature", "key encipherment", "client auth"},
+			"issuerRef":   issuerRef(ssanginxv1.IssuerReference{Name: caIssuer.GetName(), Kind: "Issuer"}),
+		})
+	if err != nil {
+		return err
+	}
+
+	objs = append(objs, caCertificate, caIssuer, serverCertificate, clientCertificate)
+	for _, obj := range objs {
+		if err := r.Client.Patch(ctx, obj, client.Apply, client.FieldOwner(fieldMgr), client.ForceOwnership); err != nil {
+			log.Error(err, "unable to apply")
+			return err
+		}
+		log.Info(fmt.Sprintf("cert-manager %s Applied: %s", obj.GetKind(), obj.GetName()))
+	}
+
+	return nil
+}
+
+// cert-manager does not set an OwnerReference on the Secrets it writes.
+// The SSANginx adopts them, so that they are garbage-collected with it and
+// handled by spec.deletionPolicy like the Secrets issued by the controller.
+// Only a Secret annotated with the name of the Certificate of the SSANginx is adopted.
+func (r *SSANginxReconciler) adoptCertManagerSecrets(ctx context.Context, log logr.Logger, ssanginx ssanginxv1.SSANginx) error {
+	secrets := []struct {
+		name   string
+		suffix string
+	}{
+		{ingressSecretName(ssanginx), constants.ServerCertificateSuffix},
+		{caSecretName(ssanginx), constants.CACertificateSuffix},
+		{clientSecretName(ssanginx, constants.DefaultClientName), constants.ClientCertificateSuffix},
+	}
+
+	for _, s := range secrets {
+		var secret corev1.Secret
+		if err := r.Client.Get(ctx, client.ObjectKey{Namespace: ssanginx.GetNamespace(), Name: s.name}, &secret); err != nil {
+			// cert-manager has not issued the certificate yet
+			if errors.IsNotFound(err) {
+				return &pendingCertificateError{name: s.name, err: err}
+			}
+			return err
+		}
+		if secret.GetAnnotations()[constants.CertManagerCertificateNameAnnotation] != certManagerResourceName(ssanginx, s.suffix) {
+			continue
+		}
+		if metav1.GetControllerOf(&secret) != nil {
+			continue
+		}
+
+		patch := client.MergeFrom(secret.DeepCopy())
+		if err := controllerutil.SetControllerReference(&ssanginx, &secret, r.Scheme); err != nil {
+			return err
+		}
+		if err := r.Client.Patch(ctx, &secret, patch); err != nil {
+			return err
+		}
+		log.Info(fmt.Sprintf("adopt cert-manager Secret: %s", secret.GetName()))
+	}
+
+	return nil
+}
+
+// Delete the cert-manager resources when the SSANginx no longer uses cert-manager.
+// cert-manager annotates the Secrets it writes, so the Secret of the server
+// certificate tells whether there is something to delete without querying
+// cert-manager resources on every reconcile.
+// The Secret is only used as the marker if it is owned by the SSANginx.
+func (r *SSANginxReconciler) deleteCertManagerResources(ctx context.Context, log logr.Logger, ssanginx ssanginxv1.SSANginx) error {
+	var secret corev1.Secret
+
+	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: ssanginx.GetNamespace(), Name: ingressSecretName(ssanginx)}, &secret); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if owner := metav1.GetControllerOf(&secret); owner == nil || owner.UID != ssanginx.GetUID() {
+		return nil
+	}
+	if _, ok := secret.GetAnnotations()[constants.CertManagerCertificateNameAnnotation]; !ok {
+		return nil
+	}
+
+	resources, err := r.listCertManagerResources(ctx, ssanginx)
+	if err != nil {
+		return err
+	}
+	for _, obj := range resources {
+		if err := r.Client.Delete(ctx, obj); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+		log.Info(fmt.Sprintf("delete cert-manager %s resource: %s", obj.GetKind(), obj.GetName()))
+		r.Recorder.Eventf(&ssanginx, corev1.EventTypeNormal, "Deleted", "Deleted cert-manager %s %q", obj.GetKind(), obj.GetName())
+	}
+
+	// cert-manager leaves the annotation on the Secret. It is removed,
+	// so that the cleanup is not repeated on every reconcile after switching from CertManager.
+	patch := client.MergeFrom(secret.DeepCopy())
+	annotations := secret.GetAnnotations()
+	delete(annotations, constants.CertManagerCertificateNameAnnotation)
+	secret.SetAnnotations(annotations)
+	if err := r.Client.Patch(ctx, &secret, patch); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+
+	return nil
+}
+
+// Get the cert-manager Certificates and Issuers owned by the SSANginx.
+// They are not watched, so they are read by name from the API server.
+func (r *SSANginxReconciler) listCertManagerResources(ctx context.Context, ssanginx ssanginxv1.SSANginx) ([]*unstructured.Unstructured, error) {
+	var objs []*unstructured.Unstructured
+
+	resources := []struct {
+		gvk    schema.GroupVersionKind
+		suffix string
+	}{
+		{certificateGVK, constants.ServerCertificateSuffix},
+		{certificateGVK, constants.ClientCertificateSuffix},
+		{issuerGVK, constants.CAIssuerSuffix},
+		{certificateGVK, constants.CACertificateSuffix},
+		{issuerGVK, constants.SelfSignedIssuerSuffix},
+	}
+
+	for _, res := range resources {
+		obj := &unstructured.Unstructured{}
+		obj.SetGroupVersionKind(res.gvk)
+		if err := r.Client.Get(ctx, client.ObjectKey{Namespace: ssanginx.GetNamespace(), Name: certManagerResourceName(ssanginx, res.suffix)}, obj); err != nil {
+			// cert-manager is not installed, so none of its resources exist
+			if meta.IsNoMatchError(err) {
+				break
+			}
+			if client.IgnoreNotFound(err) != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		// Never touch resources created by someone else with the same name
+		if owner := metav1.GetControllerOf(obj); owner == nil || owner.UID != ssanginx.GetUID() {
+			continue
+		}
+		objs = append(objs, obj)
+	}
+
+	return objs, nil
+}
@@ -0,0 +1,284 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/go-logr/logr"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	ssanginxv1 "github.com/jnytnai0613/ssa-nginx-controller/api/v1"
+	"github.com/jnytnai0613/ssa-nginx-controller/pkg/constants"
+)
+
+// The Gateway API resources are handled as unstructured objects like the cert-manager resources,
+// so that the controller keeps working in clusters without the Gateway API CRDs.
+var (
+	httpRouteGVK = schema.GroupVersionKind{Group: constants.GatewayAPIGroup, Version: constants.GatewayAPIVersion, Kind: "HTTPRoute"}
+	gatewayGVK   = schema.GroupVersionKind{Group: constants.GatewayAPIGroup, Version: constants.GatewayAPIVersion, Kind: "Gateway"}
+)
+
+var errGatewayAPINotInstalled = errors.New("the Gateway API CRDs were not installed when the controller started")
+
+// Check if the SSANginx routes traffic with the Gateway API instead of an Ingress
+func gatewayAPIEnabled(ssanginx ssanginxv1.SSANginx) bool {
+	return ssanginx.Spec.Routing != nil && ssanginx.Spec.Routing.Mode == ssanginxv1.RoutingModeGatewayAPI
+}
+
+// Check if the controller creates a Gateway for the SSANginx
+func gatewayEnabled(ssanginx ssanginxv1.SSANginx) bool {
+	return gatewayAPIEnabled(ssanginx) && ssanginx.Spec.Routing.Gateway != nil
+}
+
+// Create an HTTPRoute for every host of spec.ingressSpec, and the Gateway named spec.ingressName
+// if spec.routing.gateway is specified.
+// ingressSecureEnabled is rejected by the webhook in this mode, so no certificate is issued.
+func (r *SSANginxReconciler) applyGatewayAPIResources(ctx context.Context, fieldMgr string, log logr.Logger, ssanginx ssanginxv1.SSANginx) error {
+	var objs []*unstructured.Unstructured
+
+	if !r.gatewayAPIInstalled {
+		return errGatewayAPINotInstalled
+	}
+
+	if err := r.deleteCertManagerResources(ctx, log, ssanginx); err != nil {
+		return err
+	}
+
+	parentRefs := make([]interface{}, 0, len(ssanginx.Spec.Routing.ParentRefs)+1)
+	if gatewayEnabled(ssanginx) {
+		gateway, err := r.newOwnedObject(ssanginx, gatewayGVK, ssanginx.Spec.IngressName, gatewaySpec(ssanginx))
+		if err != nil {
+			return err
+		}
+		objs = append(objs, gateway)
+		parentRefs = append(parentRefs, map[string]interface{}{"name": gateway.GetName()})
+	}
+	for _, ref := range ssanginx.Spec.Routing.ParentRefs {
+		parentRef := map[string]interface{}{"name": ref.Name}
+		if ref.Namespace != "" {
+			parentRef["namespace"] = ref.Namespace
+		}
+		if ref.SectionName != "" {
+			parentRef["sectionName"] = ref.SectionName
+		}
+		parentRefs = append(parentRefs, parentRef)
+	}
+
+	for n, host := range httpRouteHosts(ssanginx) {
+		rules, err := httpRouteRules(ssanginx, host)
+		if err != nil {
+			return err
+		}
+		routeSpec := map[string]interface{}{
+			"parentRefs": parentRefs,
+			"rules":      rules,
+		}
+		if host != "" {
+			routeSpec["hostnames"] = []interface{}{host}
+		}
+		route, err := r.newOwnedObject(ssanginx, httpRouteGVK, httpRouteName(ssanginx, n), routeSpec)
+		if err != nil {
+			return err
+		}
+		objs = append(objs, route)
+	}
+
+	for _, obj := range objs {
+		if err := r.Client.Patch(ctx, obj, client.Apply, client.FieldOwner(fieldMgr), client.ForceOwnership); err != nil {
+			log.Error(err, "unable to apply")
+			return err
+		}
+		log.Info(fmt.Sprintf("Gateway API %s Applied: %s", obj.GetKind(), obj.GetName()))
+	}
+
+	return nil
+}
+
+// A single HTTP listener
+func gatewaySpec(ssanginx ssanginxv1.SSANginx) map[string]interface{} {
+	listener := map[string]interface{}{
+		"name":     "http",
+		"protocol": "HTTP",
+		"port":     int64(constants.GatewayHTTPPort),
+		"allowedRoutes": map[string]interface{}{
+			"namespaces": map[string]interface{}{"from": "Same"},
+		},
+	}
+	if port := ssanginx.Spec.Routing.Gateway.Port; port != nil {
+		listener["port"] = int64(*port)
+	}
+
+	return map[string]interface{}{
+		"gatewayClassName": ssanginx.Spec.Routing.Gateway.GatewayClassName,
+		"listeners":        []interface{}{listener},
+	}
+}
+
+// Hosts of the Ingress rules with paths, in the order of their first rule.
+// An empty string stands for the rules without a host, which match every hostname.
+// The hostnames of an HTTPRoute apply to all of its rules, so an HTTPRoute is created per host.
+func httpRouteHosts(ssanginx ssanginxv1.SSANginx) []string {
+	var (
+		hosts []string
+		seen  = make(map[string]bool)
+	)
+
+	for _, rule := range ssanginx.Spec.IngressSpec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		host := ""
+		if rule.Host != nil {
+			host = *rule.Host
+		}
+		if seen[host] {
+			continue
+		}
+		seen[host] = true
+		hosts = append(hosts, host)
+	}
+
+	return hosts
+}
+
+// The HTTPRoute of the first host is named spec.ingressName, and the others "<ingressName>-<index>".
+func httpRouteName(ssanginx ssanginxv1.SSANginx, n int) string {
+	if n == 0 {
+		return ssanginx.Spec.IngressName
+	}
+
+	return fmt.Sprintf("%s-%d", ssanginx.Spec.IngressName, n)
+}
+
+// Translate the paths of the Ingress rules of the host into HTTPRoute rules.
+func httpRouteRules(ssanginx ssanginxv1.SSANginx, host string) ([]interface{}, error) {
+	var (
+		rules []interface{}
+		seen  = make(map[string]bool)
+	)
+
+	for _, rule := range ssanginx.Spec.IngressSpec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		if (rule.Host == nil && host != "") || (rule.Host != nil && *rule.Host != host) {
+			continue
+		}
+		for _, path := range rule.HTTP.Paths {
+			if path.Backend == nil || path.Backend.Service == nil || path.Backend.Service.Name == nil {
+				return nil, errors.New("only Service backends are supported by the HTTPRoute")
+			}
+			if path.Backend.Service.Port == nil || path.Backend.Service.Port.Number == nil {
+				return nil, fmt.Errorf("the port of Service %q must be specified by number for the HTTPRoute", *path.Backend.Service.Name)
+			}
+
+			value := "/"
+			if path.Path != nil && *path.Path != "" {
+				value = *path.Path
+			}
+			matchType := "PathPrefix"
+			if path.PathType != nil && *path.PathType == networkingv1.PathTypeExact {
+				matchType = "Exact"
+			}
+
+			key := fmt.Sprintf("%s %s %s:%d", matchType, value, *path.Backend.Service.Name, *path.Backend.Service.Port.Number)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			routeRule := map[string]interface{}{
+				"matches": []interface{}{
+					map[string]interface{}{
+						"path": map[string]interface{}{"type": matchType, "value": value},
+					},
+				},
+				"backendRefs": []interface{}{
+					map[string]interface{}{
+						"name": *path.Backend.Service.Name,
+						"port": int64(*path.Backend.Service.Port.Number),
+					},
+				},
+			}
+			// Same as the rewrite-target annotation of the Ingress
+			if ssanginx.Spec.RewriteTargetEnabled == nil || *ssanginx.Spec.RewriteTargetEnabled {
+				routeRule["filters"] = []interface{}{
+					map[string]interface{}{
+						"type": "URLRewrite",
+						"urlRewrite": map[string]interface{}{
+							"path": map[string]interface{}{"type": "ReplaceFullPath", "replaceFullPath": "/"},
+						},
+					},
+				}
+			}
+			rules = append(rules, routeRule)
+		}
+	}
+
+	return rules, nil
+}
+
+// List the HTTPRoutes and Gateways owned by the SSANginx.
+// Unstructured objects are not read from the cache by the client, so the cache is queried directly for the index.
+func (r *SSANginxReconciler) listGatewayAPIResources(ctx context.Context, ssanginx ssanginxv1.SSANginx) ([]*unstructured.Unstructured, error) {
+	var objs []*unstructured.Unstructured
+
+	if !r.gatewayAPIInstalled {
+		return nil, nil
+	}
+
+	for _, gvk := range []schema.GroupVersionKind{httpRouteGVK, gatewayGVK} {
+		list := &unstructured.UnstructuredList{}
+		list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+		if err := r.cache.List(ctx, list, client.InNamespace(ssanginx.GetNamespace()),
+			client.MatchingFields(map[string]string{constants.IndexOwnerKey: ssanginx.GetName()})); err != nil {
+			return nil, err
+		}
+		for i := range list.Items {
+			list.Items[i].SetGroupVersionKind(gvk)
+			objs = append(objs, &list.Items[i])
+		}
+	}
+
+	return objs, nil
+}
+
+// Check if the HTTPRoute or Gateway is still used by the SSANginx
+func gatewayAPIResourceInUse(ssanginx ssanginxv1.SSANginx, obj *unstructured.Unstructured) bool {
+	switch obj.GetKind() {
+	case httpRouteGVK.Kind:
+		if !gatewayAPIEnabled(ssanginx) {
+			return false
+		}
+		for n := range httpRouteHosts(ssanginx) {
+			if obj.GetName() == httpRouteName(ssanginx, n) {
+				return true
+			}
+		}
+	case gatewayGVK.Kind:
+		return gatewayEnabled(ssanginx) && obj.GetName() == ssanginx.Spec.IngressName
+	}
+
+	return false
+}
@@ -0,0 +1,187 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	networkv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	ssanginxv1 "github.com/jnytnai0613/ssa-nginx-controller/api/v1"
+	"github.com/jnytnai0613/ssa-nginx-controller/pkg/constants"
+)
+
+// ingressProvider translates the TLS and mTLS settings of SSANginx
+// into the annotations understood by an ingress controller.
+type ingressProvider interface {
+	// Name of the ingress controller used in error messages
+	name() string
+	// spec.controller of the IngressClass handled by the ingress controller
+	controller() string
+	// IngressClass name selecting the ingress controller when no IngressClass object exists
+	className() string
+	// Annotations rewriting the request path to "/"
+	rewriteTargetAnnotations() map[string]string
+	// Annotations enabling client authentication with the CA bundle in the Secret clientCASecret ("<namespace>/<name>")
+	mtlsAnnotations(ssanginx ssanginxv1.SSANginx, clientCASecret string) (map[string]string, error)
+}
+
+var ingressProviders = []ingressProvider{
+	ingressNginxProvider{},
+	haproxyIngressProvider{},
+}
+
+// IngressClass of the Ingress, defaulting to "nginx"
+func ingressClassName(ssanginx ssanginxv1.SSANginx) string {
+	if ssanginx.Spec.IngressClassName == "" {
+		return constants.IngressClassName
+	}
+
+	return ssanginx.Spec.IngressClassName
+}
+
+// Select the provider from spec.controller of the IngressClass.
+// If the IngressClass does not exist, the provider is selected by the class name.
+// The IngressClass is read from the cache, which is shared by every reconcile.
+func (r *SSANginxReconciler) ingressProvider(ctx context.Context, ssanginx ssanginxv1.SSANginx) (ingressProvider, error) {
+	var (
+		controller   string
+		ingressClass networkv1.IngressClass
+	)
+
+	className := ingressClassName(ssanginx)
+	err := r.Client.Get(ctx, client.ObjectKey{Name: className}, &ingressClass)
+	if err == nil {
+		controller = ingressClass.Spec.Controller
+	} else if !errors.IsNotFound(err) {
+		return nil, err
+	}
+
+	for _, provider := range ingressProviders {
+		if controller == "" && provider.className() == className ||
+			controller != "" && provider.controller() == controller {
+			return provider, nil
+		}
+	}
+
+	if controller != "" {
+		return nil, fmt.Errorf("IngressClass %q is handled by the unsupported controller %q", className, controller)
+	}
+	return nil, fmt.Errorf("IngressClass %q does not exist and does not name a supported ingress controller", className)
+}
+
+// ingressNginxProvider configures ingress-nginx
+// https://github.com/kubernetes/ingress-nginx/blob/main/docs/user-guide/nginx-configuration/annotations.md
+type ingressNginxProvider struct{}
+
+func (ingressNginxProvider) name() string {
+	return "ingress-nginx"
+}
+
+func (ingressNginxProvider) controller() string {
+	return constants.IngressNginxController
+}
+
+func (ingressNginxProvider) className() string {
+	return constants.IngressClassName
+}
+
+func (ingressNginxProvider) rewriteTargetAnnotations() map[string]string {
+	return map[string]string{constants.AnnotationRewriteTarget: "/"}
+}
+
+func (ingressNginxProvider) mtlsAnnotations(ssanginx ssanginxv1.SSANginx, clientCASecret string) (map[string]string, error) {
+	annotations := map[string]string{
+		constants.AnnotationAuthTLSSecret:       clientCASecret,
+		constants.AnnotationAuthTLSVerifyClient: string(verifyClientMode(ssanginx)),
+	}
+
+	mtls := ssanginx.Spec.MTLS
+	if mtls == nil {
+		return annotations, nil
+	}
+
+	if mtls.VerifyDepth != nil {
+		annotations[constants.AnnotationAuthTLSVerifyDepth] = strconv.Itoa(int(*mtls.VerifyDepth))
+	}
+	if mtls.PassCertificateToUpstream {
+		annotations[constants.AnnotationAuthTLSPassCertToUpstream] = "true"
+	}
+	if mtls.ErrorPage != "" {
+		annotations[constants.AnnotationAuthTLSErrorPage] = mtls.ErrorPage
+	}
+
+	return annotations, nil
+}
+
+// haproxyIngressProvider configures HAProxy Ingress
+// https://haproxy-ingress.github.io/docs/configuration/keys/#auth-tls
+type haproxyIngressProvider struct{}
+
+func (haproxyIngressProvider) name() string {
+	return "HAProxy Ingress"
+}
+
+func (haproxyIngressProvider) controller() string {
+	return constants.HAProxyIngressController
+}
+
+func (haproxyIngressProvider) className() string {
+	return constants.HAProxyIngressClassName
+}
+
+func (haproxyIngressProvider) rewriteTargetAnnotations() map[string]string {
+	return map[string]string{constants.AnnotationHAProxyRewriteTarget: "/"}
+}
+
+func (p haproxyIngressProvider) mtlsAnnotations(ssanginx ssanginxv1.SSANginx, clientCASecret string) (map[string]string, error) {
+	annotations := map[string]string{
+		constants.AnnotationHAProxyAuthTLSSecret:       clientCASecret,
+		constants.AnnotationHAProxyAuthTLSVerifyClient: string(verifyClientMode(ssanginx)),
+	}
+
+	mtls := ssanginx.Spec.MTLS
+	if mtls == nil {
+		return annotations, nil
+	}
+
+	// HAProxy Ingress has no setting for the depth of the client certificate chain
+	if mtls.VerifyDepth != nil {
+		return nil, fmt.Errorf("spec.mtls.verifyDepth is not supported by %s", p.name())
+	}
+	if mtls.PassCertificateToUpstream {
+		annotations[constants.AnnotationHAProxyAuthTLSCertHeader] = "true"
+	}
+	if mtls.ErrorPage != "" {
+		annotations[constants.AnnotationHAProxyAuthTLSErrorPage] = mtls.ErrorPage
+	}
+
+	return annotations, nil
+}
+
+// Verify mode of client certificates, defaulting to on
+func verifyClientMode(ssanginx ssanginxv1.SSANginx) ssanginxv1.VerifyClientMode {
+	if ssanginx.Spec.MTLS == nil || ssanginx.Spec.MTLS.VerifyClient == "" {
+		return ssanginxv1.VerifyClientOn
+	}
+
+	return ssanginx.Spec.MTLS.VerifyClient
+}
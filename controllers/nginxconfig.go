@@ -0,0 +1,224 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"text/template"
+
+	ssanginxv1 "github.com/jnytnai0613/ssa-nginx-controller/api/v1"
+	"github.com/jnytnai0613/ssa-nginx-controller/pkg/constants"
+)
+
+// Defaults of spec.nginx, the same as default.conf of the nginx image
+var (
+	defaultListenPorts = []int32{80}
+	defaultRoot        = constants.IndexVolumeMountPath
+	defaultIndex       = []string{"index.html", "index.htm"}
+)
+
+// Modifiers of the location block
+var locationModifiers = map[ssanginxv1.LocationMatch]string{
+	ssanginxv1.LocationMatchPrefix:               "",
+	ssanginxv1.LocationMatchExact:                "= ",
+	ssanginxv1.LocationMatchRegex:                "~ ",
+	ssanginxv1.LocationMatchRegexCaseInsensitive: "~* ",
+	ssanginxv1.LocationMatchPreferentialPrefix:   "^~ ",
+}
+
+// defaultConfData is spec.nginx with the upstreams its locations proxy to
+type defaultConfData struct {
+	ssanginxv1.NginxSpec
+	Locations []locationData
+}
+
+type locationData struct {
+	ssanginxv1.NginxLocation
+	// Keepalive and timeouts of the upstream are set in the location
+	Upstream *ssanginxv1.Upstream
+}
+
+// Every value written by the user is quoted, so that it cannot end the directive.
+var defaultConfTemplate = template.Must(template.New(constants.DefaultConfKey).Funcs(template.FuncMap{
+	"quote":    quote,
+	"indent":   indent,
+	"duration": nginxDuration,
+	"modifier": func(m ssanginxv1.LocationMatch) string { return locationModifiers[m] },
+}).Parse(`# Generated from spec.nginx by ssa-nginx-controller. Do not edit.
+{{- with .HTTPSnippet }}
+
+{{ . }}
+{{- end }}
+
+server {
+{{- range .ListenPorts }}
+    listen {{ . }};
+{{- end }}
+{{- with .ServerNames }}
+    server_name{{ range . }} {{ quote . }}{{ end }};
+{{- end }}
+
+    root {{ quote .Root }};
+    index{{ range .Index }} {{ quote . }}{{ end }};
+{{- with .ClientMaxBodySize }}
+    client_max_body_size {{ . }};
+{{- end }}
+{{- with .Gzip }}
+
+    gzip on;
+{{- with .Types }}
+    gzip_types{{ range . }} {{ quote . }}{{ end }};
+{{- end }}
+{{- with .MinLength }}
+    gzip_min_length {{ . }};
+{{- end }}
+{{- end }}
+{{- with .Headers }}
+{{ range . }}
+    add_header {{ quote .Name }} {{ quote .Value }} always;
+{{- end }}
+{{- end }}
+{{- with .ErrorPages }}
+{{ range . }}
+    error_page{{ range .Codes }} {{ . }}{{ end }} {{ quote .URI }};
+{{- end }}
+{{- end }}
+{{- range .Locations }}
+
+    location {{ modifier .Match }}{{ quote .Path }} {
+{{- with .Root }}
+        root {{ quote . }};
+{{- end }}
+{{- with .ProxyPass }}
+        proxy_pass {{ quote . }};
+{{- end }}
+{{- with .Upstream }}
+{{- if .Keepalive }}
+        proxy_http_version 1.1;
+        proxy_set_header Connection "";
+{{- end }}
+{{- with .Timeouts }}
+{{- with .Connect }}
+        proxy_connect_timeout {{ duration . }};
+{{- end }}
+{{- with .Read }}
+        proxy_read_timeout {{ duration . }};
+{{- end }}
+{{- with .Send }}
+        proxy_send_timeout {{ duration . }};
+{{- end }}
+{{- end }}
+{{- end }}
+{{- with .Return }}
+        return {{ .Code }}{{ with .Text }} {{ quote . }}{{ end }};
+{{- end }}
+{{- with .Snippet }}
+{{ indent 8 . }}
+{{- end }}
+    }
+{{- end }}
+{{- with .ServerSnippet }}
+
+{{ indent 4 . }}
+{{- end }}
+}
+`))
+
+// Quote a string for the nginx configuration
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// Indent every non-empty line of s by n spaces
+func indent(n int, s string) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = strings.Repeat(" ", n) + line
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Render default.conf from spec.nginx
+func renderDefaultConf(spec ssanginxv1.NginxSpec, upstreams []ssanginxv1.Upstream) (string, error) {
+	var buf bytes.Buffer
+
+	if len(spec.ListenPorts) == 0 {
+		spec.ListenPorts = defaultListenPorts
+	}
+	if spec.Root == "" {
+		spec.Root = defaultRoot
+	}
+	if len(spec.Index) == 0 {
+		spec.Index = defaultIndex
+	}
+	data := defaultConfData{NginxSpec: spec}
+	for _, loc := range spec.Locations {
+		if loc.Match == "" {
+			loc.Match = ssanginxv1.LocationMatchPrefix
+		}
+		data.Locations = append(data.Locations, locationData{
+			NginxLocation: loc,
+			Upstream:      proxiedUpstream(loc, upstreams),
+		})
+	}
+
+	if err := defaultConfTemplate.Execute(&buf, data); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// The data of the ConfigMap, with default.conf rendered from spec.nginx
+// and upstreams.conf rendered from spec.upstreams if they are set
+func (r *SSANginxReconciler) configMapData(ctx context.Context, ssanginx ssanginxv1.SSANginx) (map[string]string, error) {
+	if ssanginx.Spec.Nginx == nil && len(ssanginx.Spec.Upstreams) == 0 {
+		return ssanginx.Spec.ConfigMapData, nil
+	}
+
+	data := make(map[string]string, len(ssanginx.Spec.ConfigMapData)+2)
+	for k, v := range ssanginx.Spec.ConfigMapData {
+		data[k] = v
+	}
+
+	if ssanginx.Spec.Nginx != nil {
+		nginx := *ssanginx.Spec.Nginx
+		if len(nginx.ListenPorts) == 0 && securityProfile(ssanginx) == ssanginxv1.SecurityProfileRestricted {
+			nginx.ListenPorts = []int32{constants.UnprivilegedPort}
+		}
+		conf, err := renderDefaultConf(nginx, ssanginx.Spec.Upstreams)
+		if err != nil {
+			return nil, err
+		}
+		data[constants.DefaultConfKey] = conf
+	}
+
+	if len(ssanginx.Spec.Upstreams) > 0 {
+		conf, err := r.renderUpstreamsConf(ctx, ssanginx)
+		if err != nil {
+			return nil, err
+		}
+		data[constants.UpstreamsConfKey] = conf
+	}
+
+	return data, nil
+}
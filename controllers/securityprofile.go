@@ -0,0 +1,193 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+
+	ssanginxv1 "github.com/jnytnai0613/ssa-nginx-controller/api/v1"
+	"github.com/jnytnai0613/ssa-nginx-controller/pkg/constants"
+)
+
+func securityProfile(ssanginx ssanginxv1.SSANginx) ssanginxv1.SecurityProfile {
+	if ssanginx.Spec.SecurityProfile == "" {
+		return ssanginxv1.SecurityProfileDefault
+	}
+
+	return ssanginx.Spec.SecurityProfile
+}
+
+// Writable emptyDir volumes of nginx with a read-only root filesystem:
+// the temporary files of proxy and client bodies, the pid file, and /tmp,
+// where nginx-unprivileged writes its pid file and the git init container checks out.
+func restrictedVolumes() []*corev1apply.VolumeApplyConfiguration {
+	return []*corev1apply.VolumeApplyConfiguration{
+		corev1apply.Volume().WithName(constants.CacheVolumeName).WithEmptyDir(nil),
+		corev1apply.Volume().WithName(constants.RunVolumeName).WithEmptyDir(nil),
+		corev1apply.Volume().WithName(constants.TmpVolumeName).WithEmptyDir(nil),
+	}
+}
+
+func restrictedVolumeMounts() []*corev1apply.VolumeMountApplyConfiguration {
+	return []*corev1apply.VolumeMountApplyConfiguration{
+		corev1apply.VolumeMount().WithName(constants.CacheVolumeName).WithMountPath(constants.CacheVolumeMountPath),
+		corev1apply.VolumeMount().WithName(constants.RunVolumeName).WithMountPath(constants.RunVolumeMountPath),
+		corev1apply.VolumeMount().WithName(constants.TmpVolumeName).WithMountPath(constants.TmpVolumeMountPath),
+	}
+}
+
+// Make a container comply with the "restricted" Pod Security Standard.
+// Capabilities other than NET_BIND_SERVICE are rejected by the webhook, so the added ones are kept.
+func restrictContainer(c *corev1apply.ContainerApplyConfiguration) {
+	if c.SecurityContext == nil {
+		c.WithSecurityContext(corev1apply.SecurityContext())
+	}
+
+	capabilities := corev1apply.Capabilities().WithDrop("ALL")
+	if c.SecurityContext.Capabilities != nil {
+		capabilities.WithAdd(c.SecurityContext.Capabilities.Add...)
+	}
+
+	c.SecurityContext.
+		WithAllowPrivilegeEscalation(false).
+		WithReadOnlyRootFilesystem(true).
+		WithRunAsNonRoot(true).
+		WithCapabilities(capabilities)
+}
+
+// Apply the Restricted security profile to the pod template.
+// The user and group are defaulted to the nginx user, and every container, including those
+// of the pod template, is restricted. The writable directories are mounted in the nginx container,
+// the reloader sidecar, which creates the temporary directories with "nginx -t", and the init containers.
+func restrictPodSpec(spec *corev1apply.PodSpecApplyConfiguration, nginxIndex int) {
+	if spec.SecurityContext == nil {
+		spec.WithSecurityContext(corev1apply.PodSecurityContext())
+	}
+	sc := spec.SecurityContext
+	sc.WithRunAsNonRoot(true)
+	if sc.RunAsUser == nil {
+		sc.WithRunAsUser(constants.RestrictedUserID)
+	}
+	if sc.RunAsGroup == nil {
+		sc.WithRunAsGroup(constants.RestrictedUserID)
+	}
+	// The emptyDir volumes are writable by the group
+	if sc.FSGroup == nil {
+		sc.WithFSGroup(constants.RestrictedUserID)
+	}
+	if sc.SeccompProfile == nil {
+		sc.WithSeccompProfile(corev1apply.SeccompProfile().
+			WithType(corev1.SeccompProfileTypeRuntimeDefault))
+	}
+
+	for i := range spec.Containers {
+		c := &spec.Containers[i]
+		restrictContainer(c)
+		if i == nginxIndex {
+			restrictContainerPorts(c)
+		}
+		if i == nginxIndex || (c.Name != nil && *c.Name == constants.ReloaderContainerName) {
+			c.WithVolumeMounts(restrictedVolumeMounts()...)
+		}
+	}
+	for i := range spec.InitContainers {
+		c := &spec.InitContainers[i]
+		restrictContainer(c)
+		c.WithVolumeMounts(corev1apply.VolumeMount().
+			WithName(constants.TmpVolumeName).
+			WithMountPath(constants.TmpVolumeMountPath))
+	}
+
+	spec.WithVolumes(restrictedVolumes()...)
+}
+
+// Move the privileged ports of the nginx container and its probes to UnprivilegedPort,
+// like the targetPorts of the Service. Ports that end up the same are merged, and probes
+// referring to a merged port by name use the number instead.
+func restrictContainerPorts(c *corev1apply.ContainerApplyConfiguration) {
+	var (
+		ports []corev1apply.ContainerPortApplyConfiguration
+		moved = make(map[string]bool)
+		seen  = make(map[string]bool)
+	)
+
+	for _, p := range c.Ports {
+		if p.ContainerPort == nil {
+			ports = append(ports, p)
+			continue
+		}
+		if *p.ContainerPort < 1024 {
+			p.WithContainerPort(constants.UnprivilegedPort)
+			if p.Name != nil {
+				moved[*p.Name] = true
+			}
+		}
+
+		protocol := corev1.ProtocolTCP
+		if p.Protocol != nil {
+			protocol = *p.Protocol
+		}
+		key := fmt.Sprintf("%d/%s", *p.ContainerPort, protocol)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		ports = append(ports, p)
+	}
+	c.Ports = ports
+
+	restrictPort := func(port *intstr.IntOrString) {
+		if port == nil {
+			return
+		}
+		if (port.Type == intstr.Int && port.IntVal < 1024) || (port.Type == intstr.String && moved[port.StrVal]) {
+			*port = intstr.FromInt(constants.UnprivilegedPort)
+		}
+	}
+	for _, probe := range []*corev1apply.ProbeApplyConfiguration{c.LivenessProbe, c.ReadinessProbe, c.StartupProbe} {
+		if probe == nil {
+			continue
+		}
+		if probe.HTTPGet != nil {
+			restrictPort(probe.HTTPGet.Port)
+		}
+		if probe.TCPSocket != nil {
+			restrictPort(probe.TCPSocket.Port)
+		}
+	}
+}
+
+// nginx cannot listen on a privileged port with the Restricted security profile,
+// so the ports of the Service targeting one, or without a targetPort, are moved to UnprivilegedPort.
+// Named targetPorts are resolved by the pod and are kept.
+func restrictServicePorts(spec *corev1apply.ServiceSpecApplyConfiguration) {
+	for i := range spec.Ports {
+		p := &spec.Ports[i]
+		if p.TargetPort == nil {
+			if p.Port != nil && *p.Port >= 1024 {
+				continue
+			}
+		} else if p.TargetPort.Type == intstr.String || p.TargetPort.IntVal >= 1024 {
+			continue
+		}
+		p.WithTargetPort(intstr.FromInt(constants.UnprivilegedPort))
+	}
+}
@@ -18,7 +18,11 @@ package controllers
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	stderrors "errors"
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/go-logr/logr"
@@ -28,8 +32,11 @@ import (
 	networkv1 "k8s.io/api/networking/v1"
 	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	appsv1apply "k8s.io/client-go/applyconfigurations/apps/v1"
 	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
 	metav1apply "k8s.io/client-go/applyconfigurations/meta/v1"
@@ -39,10 +46,13 @@ import (
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	ssanginxv1 "github.com/jnytnai0613/ssa-nginx-controller/api/v1"
 	"github.com/jnytnai0613/ssa-nginx-controller/pkg/constants"
-	"github.com/jnytnai0613/ssa-nginx-controller/pkg/pki"
+	"github.com/jnytnai0613/ssa-nginx-controller/pkg/nginxconf"
 )
 
 // SSANginxReconciler reconciles a SSANginx object
@@ -52,6 +62,13 @@ type SSANginxReconciler struct {
 	Log      logr.Logger
 	Recorder record.EventRecorder
 	Scheme   *runtime.Scheme
+	// ReloaderImage contains the reloader binary copied into the pods by the init container.
+	ReloaderImage string
+
+	// Set by SetupWithManager if the Gateway API CRDs are served
+	gatewayAPIInstalled bool
+	// Cache holding the index of the unstructured Gateway API objects
+	cache client.Reader
 }
 
 func (r *SSANginxReconciler) deleteOwnedResources(ctx context.Context, log logr.Logger, ssanginx ssanginxv1.SSANginx) error {
@@ -124,7 +141,8 @@ func (r *SSANginxReconciler) deleteOwnedResources(ctx context.Context, log logr.
 	}
 
 	for _, ingress := range ingresses.Items {
-		if ingress.GetName() == ssanginx.Spec.IngressName {
+		// The Ingress is replaced by the HTTPRoute in GatewayAPI mode
+		if ingress.GetName() == ssanginx.Spec.IngressName && !gatewayAPIEnabled(ssanginx) {
 			continue
 		}
 
@@ -136,19 +154,155 @@ func (r *SSANginxReconciler) deleteOwnedResources(ctx context.Context, log logr.
 		r.Recorder.Eventf(&ingress, corev1.EventTypeNormal, "Deleted", "Deleted Ingress %q", ingress.GetName())
 	}
 
+	gatewayAPIResources, err := r.listGatewayAPIResources(ctx, ssanginx)
+	if err != nil {
+		return err
+	}
+	for _, obj := range gatewayAPIResources {
+		if gatewayAPIResourceInUse(ssanginx, obj) {
+			continue
+		}
+
+		if err := r.Client.Delete(ctx, obj); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+
+		log.Info(fmt.Sprintf("delete %s resource: %s", obj.GetKind(), obj.GetName()))
+		r.Recorder.Eventf(obj, corev1.EventTypeNormal, "Deleted", "Deleted %s %q", obj.GetKind(), obj.GetName())
+	}
+
 	return nil
 
 }
 
-func createInitContainers() []*corev1apply.ContainerApplyConfiguration {
+// Check if all key/value pairs of sub are contained in labels
+func labelsContain(labels, sub map[string]string) bool {
+	for k, v := range sub {
+		if labels[k] != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Handle the resources owned by SSANginx according to spec.deletionPolicy,
+// and then remove the finalizer so that the SSANginx can be deleted.
+func (r *SSANginxReconciler) finalize(ctx context.Context, log logr.Logger, ssanginx ssanginxv1.SSANginx) error {
+	var (
+		configMaps  corev1.ConfigMapList
+		deployments appsv1.DeploymentList
+		ingresses   networkv1.IngressList
+		secrets     corev1.SecretList
+		services    corev1.ServiceList
+		owned       []client.Object
+		policy      = ssanginx.Spec.DeletionPolicy
+	)
+
+	if !controllerutil.ContainsFinalizer(&ssanginx, constants.Finalizer) {
+		return nil
+	}
+
+	if policy == "" {
+		policy = ssanginxv1.DeletionPolicyDelete
+	}
+
+	for _, list := range []client.ObjectList{&configMaps, &deployments, &services, &ingresses, &secrets} {
+		if err := r.Client.List(ctx, list, client.InNamespace(ssanginx.GetNamespace()),
+			client.MatchingFields(map[string]string{constants.IndexOwnerKey: ssanginx.GetName()})); err != nil {
+			return err
+		}
+	}
+	for i := range configMaps.Items {
+		owned = append(owned, &configMaps.Items[i])
+	}
+	for i := range deployments.Items {
+		owned = append(owned, &deployments.Items[i])
+	}
+	for i := range services.Items {
+		owned = append(owned, &services.Items[i])
+	}
+	for i := range ingresses.Items {
+		owned = append(owned, &ingresses.Items[i])
+	}
+	for i := range secrets.Items {
+		owned = append(owned, &secrets.Items[i])
+	}
+	gatewayAPIResources, err := r.listGatewayAPIResources(ctx, ssanginx)
+	if err != nil {
+		return err
+	}
+	for _, obj := range gatewayAPIResources {
+		owned = append(owned, obj)
+	}
+	certManagerResources, err := r.listCertManagerResources(ctx, ssanginx)
+	if err != nil {
+		return err
+	}
+	for _, obj := range certManagerResources {
+		owned = append(owned, obj)
+	}
+
+	for _, obj := range owned {
+		gvk, err := apiutil.GVKForObject(obj, r.Scheme)
+		if err != nil {
+			return err
+		}
+
+		_, isSecret := obj.(*corev1.Secret)
+		if policy == ssanginxv1.DeletionPolicyOrphan ||
+			(policy == ssanginxv1.DeletionPolicyRetainSecrets && isSecret) {
+			// Remove the OwnerReference so that the garbage collector does not delete it.
+			patch := client.MergeFrom(obj.DeepCopyObject().(client.Object))
+			var refs []metav1.OwnerReference
+			for _, ref := range obj.GetOwnerReferences() {
+				if ref.UID != ssanginx.GetUID() {
+					refs = append(refs, ref)
+				}
+			}
+			obj.SetOwnerReferences(refs)
+			if err := r.Client.Patch(ctx, obj, patch); err != nil {
+				if errors.IsNotFound(err) {
+					continue
+				}
+				return err
+			}
+
+			log.Info(fmt.Sprintf("orphan %s resource: %s", gvk.Kind, obj.GetName()))
+			r.Recorder.Eventf(&ssanginx, corev1.EventTypeNormal, "Orphaned", "Orphaned %s %q", gvk.Kind, obj.GetName())
+			continue
+		}
+
+		if err := r.Client.Delete(ctx, obj); err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+
+		log.Info(fmt.Sprintf("delete %s resource: %s", gvk.Kind, obj.GetName()))
+		r.Recorder.Eventf(&ssanginx, corev1.EventTypeNormal, "Deleted", "Deleted %s %q", gvk.Kind, obj.GetName())
+	}
+
+	controllerutil.RemoveFinalizer(&ssanginx, constants.Finalizer)
+	if err := r.Client.Update(ctx, &ssanginx); err != nil {
+		return err
+	}
+	log.Info(fmt.Sprintf("finalized with deletionPolicy %s", policy))
+
+	return nil
+}
+
+// The init container copies the reloader binary into the emptyDir volume
+func createInitContainers(reloaderImage string) []*corev1apply.ContainerApplyConfiguration {
 	var initContainers []*corev1apply.ContainerApplyConfiguration
 	i := corev1apply.Container().
 		WithName(constants.InitConatainerName).
-		WithImage(constants.InitConatainerImage).
+		WithImage(reloaderImage).
 		WithCommand(
-			"sh",
-			"-c",
-			constants.InitCommand).
+			constants.ReloaderBinaryPath,
+			"install",
+			constants.EmptyDirVolumeMountPath+constants.ReloaderContainerName).
 		WithVolumeMounts(
 			corev1apply.VolumeMount().
 				WithName(constants.EmptyDirVolumeName).
@@ -158,6 +312,126 @@ func createInitContainers() []*corev1apply.ContainerApplyConfiguration {
 	return initContainers
 }
 
+// The script of the git init container. The arguments are passed with environment variables.
+// The .git directory is removed, so that it is not served by nginx.
+const gitCheckoutScript = `set -e
+git init -q /tmp/src
+cd /tmp/src
+git fetch -q --depth 1 "$REPOSITORY" "$REVISION"
+git checkout -q FETCH_HEAD
+rm -rf .git
+cp -a "/tmp/src/$SOURCE_PATH/." ` + constants.ContentMountPath
+
+// The content init container populates the html root from spec.contentSource.
+// The image content source runs the reloader binary copied by the first init container,
+// so that the image does not need a shell.
+func createContentInitContainer(source ssanginxv1.ContentSource, reloaderImage string) *corev1apply.ContainerApplyConfiguration {
+	c := corev1apply.Container().
+		WithName(constants.ContentContainerName).
+		WithVolumeMounts(corev1apply.VolumeMount().
+			WithName(constants.ContentVolumeName).
+			WithMountPath(constants.ContentMountPath))
+
+	switch source.Type {
+	case ssanginxv1.ContentSourceImage:
+		path := source.Image.Path
+		if path == "" {
+			path = "/"
+		}
+		c.WithImage(source.Image.Image).
+			WithCommand(
+				constants.EmptyDirVolumeMountPath+constants.ReloaderContainerName,
+				"copy",
+				path,
+				constants.ContentMountPath).
+			WithVolumeMounts(corev1apply.VolumeMount().
+				WithName(constants.EmptyDirVolumeName).
+				WithMountPath(constants.EmptyDirVolumeMountPath))
+	case ssanginxv1.ContentSourceGit:
+		image := source.Git.Image
+		if image == "" {
+			image = constants.DefaultGitImage
+		}
+		revision := source.Git.Revision
+		if revision == "" {
+			revision = "HEAD"
+		}
+		c.WithImage(image).
+			WithCommand("sh", "-c", gitCheckoutScript).
+			WithEnv(
+				corev1apply.EnvVar().WithName("REPOSITORY").WithValue(source.Git.Repository),
+				corev1apply.EnvVar().WithName("REVISION").WithValue(revision),
+				corev1apply.EnvVar().WithName("SOURCE_PATH").WithValue(source.Git.Path),
+				// The home directory of the image may not be readable by the user of the pod
+				corev1apply.EnvVar().WithName("HOME").WithValue(constants.TmpVolumeMountPath))
+	case ssanginxv1.ContentSourceArchive:
+		c.WithImage(reloaderImage).
+			WithCommand(
+				constants.ReloaderBinaryPath,
+				"extract",
+				constants.ContentArchiveMountPath+source.Archive.Key,
+				constants.ContentMountPath).
+			WithVolumeMounts(corev1apply.VolumeMount().
+				WithName(constants.ArchiveVolumeName).
+				WithMountPath(constants.ContentArchiveMountPath).
+				WithReadOnly(true))
+	}
+
+	return c
+}
+
+// Volumes of spec.contentSource: the emptyDir populated by the content init container,
+// and the ConfigMap or Secret holding the archive.
+func contentVolumes(source ssanginxv1.ContentSource) []*corev1apply.VolumeApplyConfiguration {
+	volumes := []*corev1apply.VolumeApplyConfiguration{
+		corev1apply.Volume().
+			WithName(constants.ContentVolumeName).
+			WithEmptyDir(nil),
+	}
+
+	if source.Type != ssanginxv1.ContentSourceArchive {
+		return volumes
+	}
+
+	item := corev1apply.KeyToPath().
+		WithKey(source.Archive.Key).
+		WithPath(source.Archive.Key)
+	archive := corev1apply.Volume().WithName(constants.ArchiveVolumeName)
+	if source.Archive.SecretName != "" {
+		archive.WithSecret(corev1apply.SecretVolumeSource().
+			WithSecretName(source.Archive.SecretName).
+			WithItems(item))
+	} else {
+		archive.WithConfigMap(corev1apply.ConfigMapVolumeSource().
+			WithName(source.Archive.ConfigMapName).
+			WithItems(item))
+	}
+
+	return append(volumes, archive)
+}
+
+// The reloader sidecar runs with the image of the nginx container, so that it validates the
+// configuration with the same nginx before sending SIGHUP to the nginx master process.
+func createReloaderContainer(nginxImage string) *corev1apply.ContainerApplyConfiguration {
+	return corev1apply.Container().
+		WithName(constants.ReloaderContainerName).
+		WithImage(nginxImage).
+		WithCommand(
+			constants.EmptyDirVolumeMountPath+constants.ReloaderContainerName,
+			"--watch-dirs="+constants.ConfVolumeMountPath).
+		WithPorts(corev1apply.ContainerPort().
+			WithName(constants.ReloaderMetricsName).
+			WithContainerPort(constants.ReloaderMetricsPort).
+			WithProtocol(corev1.ProtocolTCP)).
+		WithVolumeMounts(
+			corev1apply.VolumeMount().
+				WithName(constants.ConfVolumeName).
+				WithMountPath(constants.ConfVolumeMountPath),
+			corev1apply.VolumeMount().
+				WithName(constants.EmptyDirVolumeName).
+				WithMountPath(constants.EmptyDirVolumeMountPath))
+}
+
 // Create OwnerReference with CR as Owner
 func createOwnerReferences(log logr.Logger, ssanginx ssanginxv1.SSANginx, scheme *runtime.Scheme) (*metav1apply.OwnerReferenceApplyConfiguration, error) {
 	gvk, err := apiutil.GVKForObject(&ssanginx, scheme)
@@ -177,14 +451,146 @@ func createOwnerReferences(log logr.Logger, ssanginx ssanginxv1.SSANginx, scheme
 	return owner, nil
 }
 
+// Labels used as the selector of the Deployment and the Service.
+// They are derived from the CR identity, so multiple SSANginx can coexist in one namespace.
+func selectorLabels(ssanginx ssanginxv1.SSANginx) map[string]string {
+	return map[string]string{
+		constants.LabelName:     constants.LabelNameValue,
+		constants.LabelInstance: ssanginx.GetName(),
+	}
+}
+
+// Labels given to every resource owned by SSANginx.
+// The labels reserved by the controller take precedence over the user-supplied ones.
+func commonLabels(ssanginx ssanginxv1.SSANginx) map[string]string {
+	labels := make(map[string]string)
+	for k, v := range ssanginx.Spec.CommonLabels {
+		labels[k] = v
+	}
+	for k, v := range selectorLabels(ssanginx) {
+		labels[k] = v
+	}
+	labels[constants.LabelManagedBy] = constants.LabelManagedByName
+
+	return labels
+}
+
+// Annotations given to every resource owned by SSANginx.
+func commonAnnotations(ssanginx ssanginxv1.SSANginx) map[string]string {
+	annotations := make(map[string]string)
+	for k, v := range ssanginx.Spec.CommonAnnotations {
+		annotations[k] = v
+	}
+
+	return annotations
+}
+
+// invalidConfigError is returned when the nginx configuration in spec.configMapData has a syntax error.
+// It is reported through the ConfigInvalid condition.
+type invalidConfigError struct {
+	key string
+	err error
+}
+
+func (e *invalidConfigError) Error() string {
+	return fmt.Sprintf("ConfigMap key %q is invalid: %v", e.key, e.err)
+}
+
+func (e *invalidConfigError) Unwrap() error {
+	return e.err
+}
+
+// Validate the nginx configuration files in the data of the ConfigMap.
+// Only the files included by nginx.conf, "*.conf" directly under conf.d, are parsed in the http context.
+func validateConfigMapData(ssanginx ssanginxv1.SSANginx, data map[string]string) error {
+	for _, f := range ssanginx.Spec.MountedFiles() {
+		if f.Target != ssanginxv1.FileTargetConf || strings.Contains(f.Path, "/") || !strings.HasSuffix(f.Path, ".conf") {
+			continue
+		}
+		if err := nginxconf.Validate(f.Path, data[f.Key]); err != nil {
+			return &invalidConfigError{key: f.Key, err: err}
+		}
+	}
+
+	return nil
+}
+
+func reloadStrategy(ssanginx ssanginxv1.SSANginx) ssanginxv1.ReloadStrategy {
+	if ssanginx.Spec.ReloadStrategy == "" {
+		return ssanginxv1.ReloadStrategyInPlace
+	}
+
+	return ssanginx.Spec.ReloadStrategy
+}
+
+// SHA-256 checksum of the data of the ConfigMap. The keys are sorted, so that it does not depend on the map order.
+func configChecksum(data map[string]string, binaryData map[string][]byte) string {
+	keys := make([]string, 0, len(data)+len(binaryData))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	for key := range binaryData {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	for _, key := range keys {
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+		if v, ok := data[key]; ok {
+			h.Write([]byte(v))
+		} else {
+			h.Write(binaryData[key])
+		}
+		h.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Split the keys of spec.configMapData into the items of the conf.d volume and of the html root volume.
+// The items are sorted by key, so the pod template does not change between reconciles.
+func configMapItems(ssanginx ssanginxv1.SSANginx) (conf, html []*corev1apply.KeyToPathApplyConfiguration) {
+	for _, f := range ssanginx.Spec.MountedFiles() {
+		item := corev1apply.KeyToPath().
+			WithKey(f.Key).
+			WithPath(f.Path)
+		if f.Target == ssanginxv1.FileTargetConf {
+			conf = append(conf, item)
+		} else {
+			html = append(html, item)
+		}
+	}
+
+	return conf, html
+}
+
 func (r *SSANginxReconciler) applyConfigMap(ctx context.Context, fieldMgr string, log logr.Logger, ssanginx ssanginxv1.SSANginx) error {
 	var (
 		configMap       corev1.ConfigMap
-		configMapClient = r.Clientset.CoreV1().ConfigMaps(constants.Namespace)
+		configMapClient = r.Clientset.CoreV1().ConfigMaps(ssanginx.GetNamespace())
 	)
 
-	nextConfigMapApplyConfig := corev1apply.ConfigMap(ssanginx.Spec.ConfigMapName, constants.Namespace).
-		WithData(ssanginx.Spec.ConfigMapData)
+	// A broken configuration would make nginx fail on its next start,
+	// so the ConfigMap is left as it is until the configuration is fixed.
+	data, err := r.configMapData(ctx, ssanginx)
+	if err == nil {
+		err = validateConfigMapData(ssanginx, data)
+	}
+	if err != nil {
+		var invalidConfig *invalidConfigError
+		if stderrors.As(err, &invalidConfig) {
+			r.Recorder.Eventf(&ssanginx, corev1.EventTypeWarning, "ConfigInvalid", "%v", err)
+		}
+		return err
+	}
+
+	nextConfigMapApplyConfig := corev1apply.ConfigMap(ssanginx.Spec.ConfigMapName, ssanginx.GetNamespace()).
+		WithLabels(commonLabels(ssanginx)).
+		WithAnnotations(commonAnnotations(ssanginx)).
+		WithData(data).
+		WithBinaryData(ssanginx.Spec.ConfigMapBinaryData)
 
 	owner, err := createOwnerReferences(log, ssanginx, r.Scheme)
 	if err != nil {
@@ -194,7 +600,7 @@ func (r *SSANginxReconciler) applyConfigMap(ctx context.Context, fieldMgr string
 	nextConfigMapApplyConfig.WithOwnerReferences(owner)
 
 	// Difference Check at Client-Side
-	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: constants.Namespace, Name: ssanginx.Spec.ConfigMapName}, &configMap); err != nil {
+	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: ssanginx.GetNamespace(), Name: ssanginx.Spec.ConfigMapName}, &configMap); err != nil {
 		// If the resource does not exist, create it.
 		// Therefore, Not Found errors are ignored.
 		if !errors.IsNotFound(err) {
@@ -225,14 +631,44 @@ func (r *SSANginxReconciler) applyConfigMap(ctx context.Context, fieldMgr string
 
 func (r *SSANginxReconciler) applyDeployment(ctx context.Context, fieldMgr string, log logr.Logger, ssanginx ssanginxv1.SSANginx) error {
 	var (
-		configmap        corev1.ConfigMap
 		deployment       appsv1.Deployment
-		deploymentClient = r.Clientset.AppsV1().Deployments(constants.Namespace)
-		labels           = map[string]string{"apps": "nginx"}
-		indexKey         string
+		deploymentClient = r.Clientset.AppsV1().Deployments(ssanginx.GetNamespace())
+		labels           = commonLabels(ssanginx)
+		selector         = selectorLabels(ssanginx)
+		volumes          []*corev1apply.VolumeApplyConfiguration
+		volumeMounts     []*corev1apply.VolumeMountApplyConfiguration
 	)
 
-	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: constants.Namespace, Name: ssanginx.Spec.ConfigMapName}, &configmap); err != nil {
+	// A ConfigMap volume without items mounts every key,
+	// so a volume is added only if some keys go to its directory.
+	confItems, htmlItems := configMapItems(ssanginx)
+	if len(confItems) > 0 {
+		volumes = append(volumes, corev1apply.Volume().
+			WithName(constants.ConfVolumeName).
+			WithConfigMap(corev1apply.ConfigMapVolumeSource().
+				WithName(ssanginx.Spec.ConfigMapName).
+				WithItems(confItems...)))
+		volumeMounts = append(volumeMounts, corev1apply.VolumeMount().
+			WithName(constants.ConfVolumeName).
+			WithMountPath(constants.ConfVolumeMountPath))
+	}
+	if ssanginx.Spec.ContentSource != nil {
+		volumes = append(volumes, contentVolumes(*ssanginx.Spec.ContentSource)...)
+		volumeMounts = append(volumeMounts, corev1apply.VolumeMount().
+			WithName(constants.ContentVolumeName).
+			WithMountPath(constants.IndexVolumeMountPath))
+	} else if len(htmlItems) > 0 {
+		volumes = append(volumes, corev1apply.Volume().
+			WithName(constants.IndexVolumeName).
+			WithConfigMap(corev1apply.ConfigMapVolumeSource().
+				WithName(ssanginx.Spec.ConfigMapName).
+				WithItems(htmlItems...)))
+		volumeMounts = append(volumeMounts, corev1apply.VolumeMount().
+			WithName(constants.IndexVolumeName).
+			WithMountPath(constants.IndexVolumeMountPath))
+	}
+
+	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: ssanginx.GetNamespace(), Name: ssanginx.Spec.DeploymentName}, &deployment); err != nil {
 		// If the resource does not exist, create it.
 		// Therefore, Not Found errors are ignored.
 		if !errors.IsNotFound(err) {
@@ -240,23 +676,26 @@ func (r *SSANginxReconciler) applyDeployment(ctx context.Context, fieldMgr strin
 		}
 	}
 
-	for key := range configmap.Data {
-		if strings.Contains(key, "htm") {
-			indexKey = key
+	// The selector of Deployment is immutable.
+	// A Deployment created before the selector was derived from the CR identity
+	// keeps its selector, and the pods are given both the old and new labels.
+	// The Service selects the pods with the new labels, so it no longer
+	// load-balances across other SSANginx.
+	// Renaming the Deployment completes the migration to the new selector.
+	if deployment.Spec.Selector != nil && !labelsContain(deployment.Spec.Selector.MatchLabels, selector) {
+		log.Info(fmt.Sprintf("keep the immutable selector of Deployment: %s", deployment.GetName()))
+		selector = deployment.Spec.Selector.MatchLabels
+		for k, v := range selector {
+			labels[k] = v
 		}
 	}
 
-	// It is possible that there is no Data in ConfigMap at this point.
-	// If Data does not exist, the function exits to retry.
-	if indexKey == "" {
-		log.Info("index key is empty")
-		return nil
-	}
-
-	nextDeploymentApplyConfig := appsv1apply.Deployment(ssanginx.Spec.DeploymentName, constants.Namespace).
+	nextDeploymentApplyConfig := appsv1apply.Deployment(ssanginx.Spec.DeploymentName, ssanginx.GetNamespace()).
+		WithLabels(commonLabels(ssanginx)).
+		WithAnnotations(commonAnnotations(ssanginx)).
 		WithSpec(appsv1apply.DeploymentSpec().
 			WithSelector(metav1apply.LabelSelector().
-				WithMatchLabels(labels)))
+				WithMatchLabels(selector)))
 
 	if ssanginx.Spec.DeploymentSpec.Replicas != nil {
 		replicas := *ssanginx.Spec.DeploymentSpec.Replicas
@@ -273,49 +712,56 @@ func (r *SSANginxReconciler) applyDeployment(ctx context.Context, fieldMgr strin
 
 	podTemplate := ssanginx.Spec.DeploymentSpec.Template
 	podTemplate.WithLabels(labels)
-	initContainers := createInitContainers()
-	podTemplate.Spec.WithInitContainers(initContainers...)
-
-	for i, _ := range podTemplate.Spec.Containers {
-		s := strings.Split(*podTemplate.Spec.Containers[i].Image, ":")
-		if s[0] == constants.CompareImageName {
-			podTemplate.Spec.Containers[i].
-				WithCommand(
-					"bash",
-					"-c",
-					constants.ContainerCommand).
-				WithVolumeMounts(
-					corev1apply.VolumeMount().
-						WithName(constants.ConfVolumeName).
-						WithMountPath(constants.ConfVolumeMountPath),
-					corev1apply.VolumeMount().
-						WithName(constants.IndexVolumeName).
-						WithMountPath(constants.IndexVolumeMountPath),
-					corev1apply.VolumeMount().
-						WithName(constants.EmptyDirVolumeName).
-						WithMountPath(constants.EmptyDirVolumeMountPath))
-			break
-		}
-	}
-
-	podTemplate.Spec.WithVolumes(
-		corev1apply.Volume().
-			WithName(constants.ConfVolumeName).
-			WithConfigMap(corev1apply.ConfigMapVolumeSource().
-				WithName(ssanginx.Spec.ConfigMapName).
-				WithItems(corev1apply.KeyToPath().
-					WithKey(constants.ConfVolumeKeyPath).
-					WithPath(constants.ConfVolumeKeyPath))),
-		corev1apply.Volume().
-			WithName(constants.IndexVolumeName).
-			WithConfigMap(corev1apply.ConfigMapVolumeSource().
-				WithName(ssanginx.Spec.ConfigMapName).
-				WithItems(corev1apply.KeyToPath().
-					WithKey(indexKey).
-					WithPath(indexKey))),
-		corev1apply.Volume().
-			WithName(constants.EmptyDirVolumeName).
-			WithEmptyDir(nil))
+
+	// A change of the checksum rolls out the Deployment.
+	// It is computed from the applied ConfigMap, which is kept while the configuration is invalid.
+	strategy := reloadStrategy(ssanginx)
+	if strategy == ssanginxv1.ReloadStrategyRollingRestart {
+		configMap, err := r.Clientset.CoreV1().ConfigMaps(ssanginx.GetNamespace()).Get(ctx, ssanginx.Spec.ConfigMapName, metav1.GetOptions{})
+		if client.IgnoreNotFound(err) != nil {
+			return err
+		}
+		podTemplate.WithAnnotations(map[string]string{
+			constants.AnnotationConfigChecksum: configChecksum(configMap.Data, configMap.BinaryData),
+		})
+	}
+
+	// The webhook rejects a pod template without an nginx container,
+	// but the CR may have been created before the webhook was deployed.
+	i := ssanginx.Spec.NginxContainerIndex()
+	if i < 0 {
+		return fmt.Errorf("no nginx container is found in the pod template, set spec.nginxContainerName")
+	}
+	podTemplate.Spec.Containers[i].WithVolumeMounts(volumeMounts...)
+
+	// The sidecar reloads the files of the conf volume.
+	// Without it, nothing in conf.d comes from the ConfigMap, and the html root is served as it is updated.
+	sidecar := strategy == ssanginxv1.ReloadStrategyInPlace && len(confItems) > 0
+
+	// The reloader binary is run by the sidecar and by the init container of the image content source
+	if sidecar ||
+		(ssanginx.Spec.ContentSource != nil && ssanginx.Spec.ContentSource.Type == ssanginxv1.ContentSourceImage) {
+		podTemplate.Spec.WithInitContainers(createInitContainers(r.ReloaderImage)...)
+	}
+	// The reloader signals the nginx master process in the other container
+	if sidecar {
+		podTemplate.Spec.
+			WithShareProcessNamespace(true).
+			WithContainers(createReloaderContainer(*podTemplate.Spec.Containers[i].Image))
+	}
+	// Run after the first init container, which installs the binary used by the image content source
+	if ssanginx.Spec.ContentSource != nil {
+		podTemplate.Spec.WithInitContainers(createContentInitContainer(*ssanginx.Spec.ContentSource, r.ReloaderImage))
+	}
+
+	podTemplate.Spec.WithVolumes(volumes...)
+	podTemplate.Spec.WithVolumes(corev1apply.Volume().
+		WithName(constants.EmptyDirVolumeName).
+		WithEmptyDir(nil))
+
+	if securityProfile(ssanginx) == ssanginxv1.SecurityProfileRestricted {
+		restrictPodSpec(podTemplate.Spec, i)
+	}
 
 	nextDeploymentApplyConfig.Spec.WithTemplate(podTemplate)
 
@@ -327,13 +773,6 @@ func (r *SSANginxReconciler) applyDeployment(ctx context.Context, fieldMgr strin
 	nextDeploymentApplyConfig.WithOwnerReferences(owner)
 
 	// Difference Check at Client-Side
-	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: constants.Namespace, Name: ssanginx.Spec.DeploymentName}, &deployment); err != nil {
-		// If the resource does not exist, create it.
-		// Therefore, Not Found errors are ignored.
-		if !errors.IsNotFound(err) {
-			return err
-		}
-	}
 	currDeploymentApplyConfig, err := appsv1apply.ExtractDeployment(&deployment, fieldMgr)
 	if err != nil {
 		return err
@@ -359,13 +798,17 @@ func (r *SSANginxReconciler) applyDeployment(ctx context.Context, fieldMgr strin
 func (r *SSANginxReconciler) applyService(ctx context.Context, fieldMgr string, log logr.Logger, ssanginx ssanginxv1.SSANginx) error {
 	var (
 		service       corev1.Service
-		serviceClient = r.Clientset.CoreV1().Services(constants.Namespace)
-		labels        = map[string]string{"apps": "nginx"}
+		serviceClient = r.Clientset.CoreV1().Services(ssanginx.GetNamespace())
 	)
 
-	nextServiceApplyConfig := corev1apply.Service(ssanginx.Spec.ServiceName, constants.Namespace).
+	nextServiceApplyConfig := corev1apply.Service(ssanginx.Spec.ServiceName, ssanginx.GetNamespace()).
+		WithLabels(commonLabels(ssanginx)).
+		WithAnnotations(commonAnnotations(ssanginx)).
 		WithSpec((*corev1apply.ServiceSpecApplyConfiguration)(ssanginx.Spec.ServiceSpec).
-			WithSelector(labels))
+			WithSelector(selectorLabels(ssanginx)))
+	if securityProfile(ssanginx) == ssanginxv1.SecurityProfileRestricted {
+		restrictServicePorts(nextServiceApplyConfig.Spec)
+	}
 
 	owner, err := createOwnerReferences(log, ssanginx, r.Scheme)
 	if err != nil {
@@ -375,7 +818,7 @@ func (r *SSANginxReconciler) applyService(ctx context.Context, fieldMgr string,
 	nextServiceApplyConfig.WithOwnerReferences(owner)
 
 	// Difference Check at Client-Side
-	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: constants.Namespace, Name: ssanginx.Spec.ServiceName}, &service); err != nil {
+	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: ssanginx.GetNamespace(), Name: ssanginx.Spec.ServiceName}, &service); err != nil {
 		// If the resource does not exist, create it.
 		// Therefore, Not Found errors are ignored.
 		if !errors.IsNotFound(err) {
@@ -406,20 +849,26 @@ func (r *SSANginxReconciler) applyService(ctx context.Context, fieldMgr string,
 
 func (r *SSANginxReconciler) applyIngress(ctx context.Context, fieldMgr string, log logr.Logger, ssanginx ssanginxv1.SSANginx) error {
 	var (
-		annotateRewriteTarget = map[string]string{"nginx.ingress.kubernetes.io/rewrite-target": "/"}
-		annotateVerifyClient  = map[string]string{"nginx.ingress.kubernetes.io/auth-tls-verify-client": "on"}
-		annotateTlsSecret     = map[string]string{"nginx.ingress.kubernetes.io/auth-tls-secret": fmt.Sprintf("%s/%s", constants.Namespace, constants.IngressSecretName)}
-		ingress               networkv1.Ingress
-		ingressClient         = r.Clientset.NetworkingV1().Ingresses(constants.Namespace)
-		secrets               corev1.SecretList
+		ingress       networkv1.Ingress
+		ingressClient = r.Clientset.NetworkingV1().Ingresses(ssanginx.GetNamespace())
 	)
 
-	nextIngressApplyConfig := networkv1apply.Ingress(ssanginx.Spec.IngressName, constants.Namespace).
-		WithAnnotations(annotateRewriteTarget).
+	provider, err := r.ingressProvider(ctx, ssanginx)
+	if err != nil {
+		return err
+	}
+
+	nextIngressApplyConfig := networkv1apply.Ingress(ssanginx.Spec.IngressName, ssanginx.GetNamespace()).
+		WithLabels(commonLabels(ssanginx)).
+		WithAnnotations(commonAnnotations(ssanginx)).
 		WithSpec((*networkv1apply.IngressSpecApplyConfiguration)(ssanginx.Spec.IngressSpec).
-			WithIngressClassName(constants.IngressClassName))
+			WithIngressClassName(ingressClassName(ssanginx)))
+
+	if ssanginx.Spec.RewriteTargetEnabled == nil || *ssanginx.Spec.RewriteTargetEnabled {
+		nextIngressApplyConfig.WithAnnotations(provider.rewriteTargetAnnotations())
+	}
 
-	if err := r.Get(ctx, client.ObjectKey{Namespace: constants.Namespace, Name: ssanginx.Spec.IngressName}, &ingress); err != nil {
+	if err := r.Get(ctx, client.ObjectKey{Namespace: ssanginx.GetNamespace(), Name: ssanginx.Spec.IngressName}, &ingress); err != nil {
 		// If the resource does not exist, create it.
 		// Therefore, Not Found errors are ignored.
 		if !errors.IsNotFound(err) {
@@ -428,43 +877,23 @@ func (r *SSANginxReconciler) applyIngress(ctx context.Context, fieldMgr string,
 	}
 
 	if ssanginx.Spec.IngressSecureEnabled {
-		// Re-create Secret if 'spec.tls[].hosts[]' has changed
-		if len(ingress.Spec.TLS) > 0 {
-			if err := r.Client.List(ctx, &secrets, client.InNamespace(ssanginx.GetNamespace()),
-				client.MatchingFields(map[string]string{constants.IndexOwnerKey: ssanginx.GetName()})); err != nil {
-				return err
-			}
-
-			ih := ingress.Spec.TLS[0].Hosts[0]
-			sh := *ssanginx.Spec.IngressSpec.Rules[0].Host
-			if ih != sh {
-				for _, secret := range secrets.Items {
-					if err := r.Client.Delete(ctx, &secret); err != nil {
-						return err
-					}
-					log.Info(fmt.Sprintf("delete Secret resource: %s", secret.GetName()))
-					r.Recorder.Eventf(&secret, corev1.EventTypeNormal, "Deleted", "Deleted Secret %q", secret.GetName())
-				}
-			}
-		}
-
-		if err := r.applyIngressSecret(ctx, constants.FieldManager, log, ssanginx); err != nil {
-			log.Error(err, "Unable create Ingress Secret")
+		clientCASecretName, err := r.applyCertificates(ctx, fieldMgr, log, ssanginx)
+		if err != nil {
 			return err
 		}
-
-		if err := r.applyClientSecret(ctx, constants.FieldManager, log, ssanginx); err != nil {
-			log.Error(err, "Unable create Client Secret")
+		annotateMTLS, err := provider.mtlsAnnotations(ssanginx, fmt.Sprintf("%s/%s", ssanginx.GetNamespace(), clientCASecretName))
+		if err != nil {
 			return err
 		}
 
 		nextIngressApplyConfig.
-			WithAnnotations(annotateVerifyClient).
-			WithAnnotations(annotateTlsSecret).
+			WithAnnotations(annotateMTLS).
 			Spec.
 			WithTLS(networkv1apply.IngressTLS().
-				WithHosts(*ssanginx.Spec.IngressSpec.Rules[0].Host).
-				WithSecretName(constants.IngressSecretName))
+				WithHosts(ingressHosts(ssanginx)...).
+				WithSecretName(serverSecretName(ssanginx)))
+	} else if err := r.deleteCertManagerResources(ctx, log, ssanginx); err != nil {
+		return err
 	}
 
 	owner, err := createOwnerReferences(log, ssanginx, r.Scheme)
@@ -497,117 +926,241 @@ func (r *SSANginxReconciler) applyIngress(ctx context.Context, fieldMgr string,
 	return nil
 }
 
-func (r *SSANginxReconciler) applyIngressSecret(ctx context.Context, fieldMgr string, log logr.Logger, ssanginx ssanginxv1.SSANginx) error {
+// Hosts of all Ingress rules without duplicates, listed in the TLS section of the Ingress
+func ingressHosts(ssanginx ssanginxv1.SSANginx) []string {
 	var (
-		secret       corev1.Secret
-		secretClient = r.Clientset.CoreV1().Secrets(constants.Namespace)
+		hosts []string
+		seen  = make(map[string]bool)
 	)
 
-	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: constants.Namespace, Name: constants.IngressSecretName}, &secret); err != nil {
-		// If the resource does not exist, create it.
-		// Therefore, Not Found errors are ignored.
-		if !errors.IsNotFound(err) {
-			return err
+	for _, rule := range ssanginx.Spec.IngressSpec.Rules {
+		if rule.Host == nil || *rule.Host == "" || seen[*rule.Host] {
+			continue
 		}
+		seen[*rule.Host] = true
+		hosts = append(hosts, *rule.Host)
 	}
 
-	if len(secret.GetName()) > 0 {
-		return nil
-	}
+	return hosts
+}
 
-	caCrt, _, err := pki.CreateCaCrt()
-	if err != nil {
-		log.Error(err, "Unable create CA Certificates")
-		return err
+// Derive the Ready and Progressing conditions from the rollout state of the Deployment
+func deploymentConditions(ssanginx ssanginxv1.SSANginx, deployment appsv1.Deployment) (metav1.Condition, metav1.Condition) {
+	var desired int32 = 1
+	if deployment.Spec.Replicas != nil {
+		desired = *deployment.Spec.Replicas
 	}
 
-	svrCrt, svrKey, err := pki.CreateSvrCrt(ssanginx)
-	if err != nil {
-		log.Error(err, "Unable create Server Certificates")
-		return err
+	ready := metav1.Condition{
+		Type:               ssanginxv1.ConditionTypeReady,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: ssanginx.GetGeneration(),
 	}
-
-	secData := map[string][]byte{
-		"tls.crt": svrCrt,
-		"tls.key": svrKey,
-		"ca.crt":  caCrt,
+	progressing := metav1.Condition{
+		Type:               ssanginxv1.ConditionTypeProgressing,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: ssanginx.GetGeneration(),
 	}
 
-	nextIngressSecretApplyConfig := corev1apply.Secret(constants.IngressSecretName, constants.Namespace).
-		WithData(secData)
-
-	owner, err := createOwnerReferences(log, ssanginx, r.Scheme)
-	if err != nil {
-		log.Error(err, "Unable create OwnerReference")
-		return err
+	switch {
+	case len(deployment.GetName()) == 0:
+		ready.Reason = ssanginxv1.ReasonDeploymentNotFound
+		ready.Message = fmt.Sprintf("Deployment %q has not been created yet", ssanginx.Spec.DeploymentName)
+		progressing.Reason = ssanginxv1.ReasonDeploymentNotFound
+		progressing.Message = ready.Message
+	case deployment.Status.ObservedGeneration < deployment.GetGeneration() ||
+		deployment.Status.UpdatedReplicas < desired ||
+		deployment.Status.AvailableReplicas < desired:
+		ready.Reason = ssanginxv1.ReasonDeploymentProgressing
+		ready.Message = fmt.Sprintf("%d of %d replicas are available", deployment.Status.AvailableReplicas, desired)
+		progressing.Reason = ssanginxv1.ReasonDeploymentProgressing
+		progressing.Message = ready.Message
+	default:
+		ready.Status = metav1.ConditionTrue
+		ready.Reason = ssanginxv1.ReasonDeploymentAvailable
+		ready.Message = fmt.Sprintf("%d of %d replicas are available", deployment.Status.AvailableReplicas, desired)
+		progressing.Status = metav1.ConditionFalse
+		progressing.Reason = ssanginxv1.ReasonDeploymentAvailable
+		progressing.Message = ready.Message
 	}
-	nextIngressSecretApplyConfig.WithOwnerReferences(owner)
 
-	applied, err := secretClient.Apply(ctx, nextIngressSecretApplyConfig, metav1.ApplyOptions{
-		FieldManager: fieldMgr,
-		Force:        true,
-	})
-	if err != nil {
-		log.Error(err, "unable to apply")
-		return err
-	}
-
-	log.Info(fmt.Sprintf("Nginx Server Certificates Secret Applied: %s", applied.GetName()))
-
-	return nil
+	return ready, progressing
 }
 
-func (r *SSANginxReconciler) applyClientSecret(ctx context.Context, fieldMgr string, log logr.Logger, ssanginx ssanginxv1.SSANginx) error {
+// Reflect the result of the reconcile in the status subresource.
+// If reconcileErr is not nil, Ready is set to False and Degraded to True with the given reason.
+// Otherwise the child resource names and the replica counts of the Deployment are recorded.
+func (r *SSANginxReconciler) updateStatus(ctx context.Context, log logr.Logger, ssanginx *ssanginxv1.SSANginx, reason string, reconcileErr error) error {
 	var (
-		secret       corev1.Secret
-		secretClient = r.Clientset.CoreV1().Secrets(constants.Namespace)
+		deployment appsv1.Deployment
+		status     = ssanginx.Status.DeepCopy()
 	)
 
-	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: constants.Namespace, Name: constants.ClientSecretName}, &secret); err != nil {
-		// If the resource does not exist, create it.
-		// Therefore, Not Found errors are ignored.
-		if !errors.IsNotFound(err) {
-			return err
+	status.ObservedGeneration = ssanginx.GetGeneration()
+
+	// Waiting for a certificate or an upstream Service is not a failure, so it is
+	// reported as Progressing and the reconcile is requeued without an error.
+	var (
+		pending        *pendingCertificateError
+		pendingService *pendingServiceError
+	)
+	if stderrors.As(reconcileErr, &pending) || stderrors.As(reconcileErr, &pendingService) {
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               ssanginxv1.ConditionTypeProgressing,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: ssanginx.GetGeneration(),
+			Reason:             reason,
+			Message:            reconcileErr.Error(),
+		})
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               ssanginxv1.ConditionTypeReady,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: ssanginx.GetGeneration(),
+			Reason:             reason,
+			Message:            reconcileErr.Error(),
+		})
+		reconcileErr = nil
+	} else if reconcileErr != nil {
+		// Retrying does not fix the configuration or the Secrets provided by the user,
+		// so the reconcile waits for the next change of the spec or of the Secrets.
+		var invalidConfig *invalidConfigError
+		if stderrors.As(reconcileErr, &invalidConfig) {
+			meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+				Type:               ssanginxv1.ConditionTypeConfigInvalid,
+				Status:             metav1.ConditionTrue,
+				ObservedGeneration: ssanginx.GetGeneration(),
+				Reason:             ssanginxv1.ReasonConfigSyntaxError,
+				Message:            invalidConfig.Error(),
+			})
+		}
+		var invalidSecret *invalidSecretError
+		if stderrors.As(reconcileErr, &invalidSecret) {
+			meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+				Type:               ssanginxv1.ConditionTypeTLSSecretsValid,
+				Status:             metav1.ConditionFalse,
+				ObservedGeneration: ssanginx.GetGeneration(),
+				Reason:             ssanginxv1.ReasonSecretInvalid,
+				Message:            invalidSecret.Error(),
+			})
+		}
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               ssanginxv1.ConditionTypeDegraded,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: ssanginx.GetGeneration(),
+			Reason:             reason,
+			Message:            reconcileErr.Error(),
+		})
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               ssanginxv1.ConditionTypeReady,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: ssanginx.GetGeneration(),
+			Reason:             reason,
+			Message:            reconcileErr.Error(),
+		})
+		if invalidConfig != nil || invalidSecret != nil {
+			reconcileErr = nil
+		}
+	} else {
+		status.ConfigMapName = ssanginx.Spec.ConfigMapName
+		status.DeploymentName = ssanginx.Spec.DeploymentName
+		status.ServiceName = ssanginx.Spec.ServiceName
+		status.IngressName = ""
+		status.HTTPRouteName = ""
+		status.GatewayName = ""
+		switch {
+		case gatewayEnabled(*ssanginx):
+			status.GatewayName = ssanginx.Spec.IngressName
+			fallthrough
+		case gatewayAPIEnabled(*ssanginx):
+			status.HTTPRouteName = ssanginx.Spec.IngressName
+		default:
+			status.IngressName = ssanginx.Spec.IngressName
+		}
+		status.CASecretName = ""
+		status.IngressSecretName = ""
+		status.ClientSecretName = ""
+		// Secrets provided by the user are not owned, so they are not recorded.
+		if ssanginx.Spec.IngressSecureEnabled {
+			if !externalServerSecret(*ssanginx) || !externalClientCASecret(*ssanginx) {
+				status.CASecretName = caSecretName(*ssanginx)
+			}
+			if !externalServerSecret(*ssanginx) {
+				status.IngressSecretName = ingressSecretName(*ssanginx)
+			}
+			// The Secrets of spec.clientCertificates are listed in .status.certificates.clients.
+			if !externalClientCASecret(*ssanginx) && len(ssanginx.Spec.ClientCertificates) == 0 {
+				status.ClientSecretName = clientSecretName(*ssanginx, constants.DefaultClientName)
+			}
 		}
-	}
 
-	if len(secret.GetName()) > 0 {
-		return nil
-	}
+		if ssanginx.Spec.IngressSecureEnabled && !certManagerEnabled(*ssanginx) &&
+			(externalServerSecret(*ssanginx) || externalClientCASecret(*ssanginx)) {
+			meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+				Type:               ssanginxv1.ConditionTypeTLSSecretsValid,
+				Status:             metav1.ConditionTrue,
+				ObservedGeneration: ssanginx.GetGeneration(),
+				Reason:             ssanginxv1.ReasonSecretValid,
+				Message:            "Referenced TLS Secrets are valid",
+			})
+		} else {
+			meta.RemoveStatusCondition(&status.Conditions, ssanginxv1.ConditionTypeTLSSecretsValid)
+		}
 
-	cliCrt, cliKey, err := pki.CreateClientCrt()
-	if err != nil {
-		log.Error(err, "Unable create Client Certificates")
-		return err
-	}
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               ssanginxv1.ConditionTypeConfigInvalid,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: ssanginx.GetGeneration(),
+			Reason:             ssanginxv1.ReasonConfigValid,
+			Message:            "The nginx configuration has no syntax error",
+		})
+
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               ssanginxv1.ConditionTypeDegraded,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: ssanginx.GetGeneration(),
+			Reason:             ssanginxv1.ReasonReconcileSucceeded,
+			Message:            "All resources have been applied",
+		})
+
+		if err := r.Client.Get(ctx, client.ObjectKey{Namespace: ssanginx.GetNamespace(), Name: ssanginx.Spec.DeploymentName}, &deployment); err != nil {
+			// The Deployment may not have been created yet.
+			// In that case, it is reported as Progressing.
+			if !errors.IsNotFound(err) {
+				return err
+			}
+		}
+		status.Replicas = deployment.Status.Replicas
+		status.ReadyReplicas = deployment.Status.ReadyReplicas
+		status.AvailableReplicas = deployment.Status.AvailableReplicas
+
+		status.Certificates = nil
+		if ssanginx.Spec.IngressSecureEnabled {
+			certificates, err := r.certificatesStatus(ctx, *ssanginx)
+			if err != nil {
+				return err
+			}
+			status.Certificates = certificates
+		}
 
-	secData := map[string][]byte{
-		"client.crt": cliCrt,
-		"client.key": cliKey,
+		ready, progressing := deploymentConditions(*ssanginx, deployment)
+		meta.SetStatusCondition(&status.Conditions, ready)
+		meta.SetStatusCondition(&status.Conditions, progressing)
 	}
 
-	nextClientSecretApplyConfig := corev1apply.Secret(constants.ClientSecretName, constants.Namespace).
-		WithData(secData)
-
-	owner, err := createOwnerReferences(log, ssanginx, r.Scheme)
-	if err != nil {
-		log.Error(err, "Unable create OwnerReference")
-		return err
+	if equality.Semantic.DeepEqual(&ssanginx.Status, status) {
+		return reconcileErr
 	}
-	nextClientSecretApplyConfig.WithOwnerReferences(owner)
 
-	applied, err := secretClient.Apply(ctx, nextClientSecretApplyConfig, metav1.ApplyOptions{
-		FieldManager: fieldMgr,
-		Force:        true,
-	})
-	if err != nil {
-		log.Error(err, "unable to apply")
+	ssanginx.Status = *status
+	if err := r.Client.Status().Update(ctx, ssanginx); err != nil {
+		log.Error(err, "unable to update status")
+		if reconcileErr != nil {
+			return reconcileErr
+		}
 		return err
 	}
 
-	log.Info(fmt.Sprintf("Nginx Client Certificates Secret Applied: %s", applied.GetName()))
-
-	return nil
+	return reconcileErr
 }
 
 //+kubebuilder:rbac:groups=ssanginx.jnytnai0613.github.io,resources=ssanginxes,verbs=get;list;watch;create;update;patch;delete
@@ -617,7 +1170,10 @@ func (r *SSANginxReconciler) applyClientSecret(ctx context.Context, fieldMgr str
 //+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=ingressclasses,verbs=get;list;watch
+//+kubebuilder:rbac:groups=gateway.networking.k8s.io,resources=httproutes;gateways,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=cert-manager.io,resources=certificates;issuers,verbs=get;list;watch;create;update;patch;delete
 
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.13.0/pkg/reconcile
 func (r *SSANginxReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
@@ -631,32 +1187,77 @@ func (r *SSANginxReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
+	if !ssanginx.GetDeletionTimestamp().IsZero() {
+		return ctrl.Result{}, r.finalize(ctx, log, ssanginx)
+	}
+
+	if !controllerutil.ContainsFinalizer(&ssanginx, constants.Finalizer) {
+		controllerutil.AddFinalizer(&ssanginx, constants.Finalizer)
+		if err := r.Client.Update(ctx, &ssanginx); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
 	// Create Configmap
 	// Generate default.conf and index.html
-	if err := r.applyConfigMap(ctx, constants.FieldManager, log, ssanginx); err != nil {
-		return ctrl.Result{}, err
+	// An invalid configuration or a missing upstream Service only keeps the ConfigMap as it is.
+	// The other resources are still reconciled, and the error is reported after them.
+	var (
+		invalidConfig  *invalidConfigError
+		pendingService *pendingServiceError
+	)
+	configErr := r.applyConfigMap(ctx, constants.FieldManager, log, ssanginx)
+	if configErr != nil && !stderrors.As(configErr, &invalidConfig) && !stderrors.As(configErr, &pendingService) {
+		return ctrl.Result{}, r.updateStatus(ctx, log, &ssanginx, ssanginxv1.ReasonConfigMapApplyFailed, configErr)
 	}
 
 	// Create Deployment
 	if err := r.applyDeployment(ctx, constants.FieldManager, log, ssanginx); err != nil {
-		return ctrl.Result{}, err
+		return ctrl.Result{}, r.updateStatus(ctx, log, &ssanginx, ssanginxv1.ReasonDeploymentApplyFailed, err)
 	}
 
 	// Create Service
 	if err := r.applyService(ctx, constants.FieldManager, log, ssanginx); err != nil {
-		return ctrl.Result{}, err
+		return ctrl.Result{}, r.updateStatus(ctx, log, &ssanginx, ssanginxv1.ReasonServiceApplyFailed, err)
 	}
 
-	// Create Ingress
-	if err := r.applyIngress(ctx, constants.FieldManager, log, ssanginx); err != nil {
-		return ctrl.Result{}, err
+	// Create Ingress, or HTTPRoute and Gateway in GatewayAPI mode
+	// They are applied only after the server certificate covers their hosts.
+	applyRouting, reason := r.applyIngress, ssanginxv1.ReasonIngressApplyFailed
+	if gatewayAPIEnabled(ssanginx) {
+		applyRouting, reason = r.applyGatewayAPIResources, ssanginxv1.ReasonGatewayAPIApplyFailed
+	}
+	if err := applyRouting(ctx, constants.FieldManager, log, ssanginx); err != nil {
+		var pending *pendingCertificateError
+		if stderrors.As(err, &pending) {
+			log.Info(pending.Error())
+			return ctrl.Result{RequeueAfter: constants.CertificatePendingInterval},
+				r.updateStatus(ctx, log, &ssanginx, ssanginxv1.ReasonCertificatePending, err)
+		}
+		return ctrl.Result{}, r.updateStatus(ctx, log, &ssanginx, reason, err)
+	}
+
+	// The previous ConfigMap is still in use, so nothing is cleaned up.
+	if pendingService != nil {
+		log.Info(pendingService.Error())
+		return ctrl.Result{RequeueAfter: constants.UpstreamPendingInterval},
+			r.updateStatus(ctx, log, &ssanginx, ssanginxv1.ReasonUpstreamPending, configErr)
+	}
+	if configErr != nil {
+		return ctrl.Result{}, r.updateStatus(ctx, log, &ssanginx, ssanginxv1.ReasonConfigMapApplyFailed, configErr)
 	}
 
 	if err := r.deleteOwnedResources(ctx, log, ssanginx); err != nil {
+		return ctrl.Result{}, r.updateStatus(ctx, log, &ssanginx, ssanginxv1.ReasonCleanupFailed, err)
+	}
+
+	// Update Status
+	if err := r.updateStatus(ctx, log, &ssanginx, ssanginxv1.ReasonReconcileSucceeded, nil); err != nil {
 		return ctrl.Result{}, err
 	}
 
-	return ctrl.Result{}, nil
+	// Requeue until the earliest certificate has to be renewed
+	return ctrl.Result{RequeueAfter: certificateRequeueAfter(ssanginx)}, nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
@@ -756,11 +1357,67 @@ func (r *SSANginxReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		return err
 	}
 
-	return ctrl.NewControllerManagedBy(mgr).
+	// add IndexUpstreamServiceKey index to SSANginx, to find the SSANginxes referring to a Service
+	if err := mgr.GetFieldIndexer().IndexField(ctx, &ssanginxv1.SSANginx{}, constants.IndexUpstreamServiceKey, func(obj client.Object) []string {
+		var names []string
+		ssanginx := obj.(*ssanginxv1.SSANginx)
+		for _, upstream := range ssanginx.Spec.Upstreams {
+			for _, server := range upstream.Servers {
+				names = append(names, server.ServiceName)
+			}
+		}
+
+		return names
+	}); err != nil {
+		return err
+	}
+
+	// add IndexTLSSecretKey index to SSANginx, to find the SSANginxes referring to a Secret provided by the user
+	if err := mgr.GetFieldIndexer().IndexField(ctx, &ssanginxv1.SSANginx{}, constants.IndexTLSSecretKey, func(obj client.Object) []string {
+		return externalSecretNames(*obj.(*ssanginxv1.SSANginx))
+	}); err != nil {
+		return err
+	}
+
+	bldr := ctrl.NewControllerManagedBy(mgr).
 		For(&ssanginxv1.SSANginx{}).
 		Owns(&corev1.ConfigMap{}).
 		Owns(&appsv1.Deployment{}).
 		Owns(&corev1.Service{}).
 		Owns(&networkv1.Ingress{}).
-		Complete(r)
+		Watches(&source.Kind{Type: &corev1.Service{}}, handler.EnqueueRequestsFromMapFunc(r.ssanginxesForService)).
+		Watches(&source.Kind{Type: &corev1.Secret{}}, handler.EnqueueRequestsFromMapFunc(r.ssanginxesForSecret))
+
+	// The Gateway API objects are watched only if the CRDs are served when the controller starts
+	r.cache = mgr.GetCache()
+	if _, err := mgr.GetRESTMapper().RESTMapping(httpRouteGVK.GroupKind(), httpRouteGVK.Version); err != nil {
+		if !meta.IsNoMatchError(err) {
+			return err
+		}
+		return bldr.Complete(r)
+	}
+	r.gatewayAPIInstalled = true
+
+	// add IndexOwnerKey index to HTTPRoute and Gateway objects which SSANginx resource owns
+	for _, gvk := range []schema.GroupVersionKind{httpRouteGVK, gatewayGVK} {
+		obj := &unstructured.Unstructured{}
+		obj.SetGroupVersionKind(gvk)
+		if err := mgr.GetFieldIndexer().IndexField(ctx, obj, constants.IndexOwnerKey, func(obj client.Object) []string {
+			owner := metav1.GetControllerOf(obj)
+			if owner == nil {
+				return nil
+			}
+
+			if owner.APIVersion != apiGVStr || owner.Kind != constants.CrKind {
+				return nil
+			}
+
+			return []string{owner.Name}
+		}); err != nil {
+			return err
+		}
+		bldr = bldr.Owns(obj)
+	}
+
+	return bldr.Complete(r)
 }
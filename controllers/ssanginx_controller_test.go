@@ -2,6 +2,9 @@ package controllers
 
 import (
 	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -11,20 +14,30 @@ import (
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	appsv1apply "k8s.io/client-go/applyconfigurations/apps/v1"
 	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
 	networkv1apply "k8s.io/client-go/applyconfigurations/networking/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
 
 	ssanginxv1 "github.com/jnytnai0613/ssa-nginx-controller/api/v1"
 	"github.com/jnytnai0613/ssa-nginx-controller/pkg/constants"
+	"github.com/jnytnai0613/ssa-nginx-controller/pkg/imageref"
+	"github.com/jnytnai0613/ssa-nginx-controller/pkg/pki"
 )
 
 const (
-	cip                = corev1.ServiceTypeClusterIP
-	defaultconf string = `server {
+	cip                      = corev1.ServiceTypeClusterIP
+	testReloaderImage        = "ssa-nginx-reloader:test"
+	defaultconf       string = `server {
 	listen 80 default_server;
 	listen [::]:80 default_server ipv6only=on;
 	root /usr/share/nginx/html;
@@ -114,7 +127,7 @@ var _ = Describe("Test Controller", func() {
 	ctx := context.Background()
 	var stopFunc func()
 
-	BeforeEach(func() {
+	startManager := func() {
 		mgr, err := ctrl.NewManager(cfg, ctrl.Options{
 			Scheme:             scheme,
 			LeaderElection:     false,
@@ -128,6 +141,8 @@ var _ = Describe("Test Controller", func() {
 			Log:       ctrl.Log.WithName("controllers").WithName("NGINX"),
 			Scheme:    scheme,
 			Recorder:  mgr.GetEventRecorderFor("moco-controller"),
+
+			ReloaderImage: testReloaderImage,
 		}
 		err = reconciler.SetupWithManager(mgr)
 		Expect(err).ShouldNot(HaveOccurred())
@@ -141,7 +156,9 @@ var _ = Describe("Test Controller", func() {
 			}
 		}()
 		time.Sleep(100 * time.Millisecond)
-	})
+	}
+
+	BeforeEach(startManager)
 
 	AfterEach(func() {
 		stopFunc()
@@ -187,6 +204,14 @@ var _ = Describe("Test Controller", func() {
 		Expect(dep.Spec.Replicas).Should(Equal(&r))
 		Expect(dep.Spec.Template.Spec.Containers[0].Name).Should(Equal(resouceName))
 		Expect(dep.Spec.Template.Spec.Containers[0].Image).Should(Equal(image))
+		Expect(dep.Spec.Template.Spec.Containers[0].Command).Should(BeEmpty())
+		Expect(dep.Spec.Template.Spec.Containers[1].Name).Should(Equal(constants.ReloaderContainerName))
+		Expect(dep.Spec.Template.Spec.Containers[1].Image).Should(Equal(image))
+		Expect(dep.Spec.Template.Spec.InitContainers[0].Image).Should(Equal(testReloaderImage))
+		Expect(*dep.Spec.Template.Spec.ShareProcessNamespace).Should(BeTrue())
+		Expect(dep.Spec.Selector.MatchLabels).Should(HaveKeyWithValue(constants.LabelInstance, "test"))
+		Expect(dep.Spec.Template.Labels).Should(HaveKeyWithValue(constants.LabelInstance, "test"))
+		Expect(dep.Labels).Should(HaveKeyWithValue(constants.LabelManagedBy, constants.LabelManagedByName))
 	})
 
 	It("should create service resource", func() {
@@ -202,6 +227,10 @@ var _ = Describe("Test Controller", func() {
 		Expect(svc.Spec.Ports[0].Protocol).Should(Equal(corev1.ProtocolTCP))
 		Expect(svc.Spec.Ports[0].Port).Should(Equal(int32(port)))
 		Expect(svc.Spec.Ports[0].TargetPort).Should(Equal(intstr.FromInt(port)))
+		Expect(svc.Spec.Selector).Should(Equal(map[string]string{
+			constants.LabelName:     constants.LabelNameValue,
+			constants.LabelInstance: "test",
+		}))
 	})
 
 	It("should create ingress resource", func() {
@@ -224,6 +253,27 @@ var _ = Describe("Test Controller", func() {
 		Expect(ing.Spec.Rules[0].HTTP.Paths[0].Backend.Service.Port).Should(Equal(bport))
 	})
 
+	It("should update status", func() {
+		cr := &ssanginxv1.SSANginx{}
+		Eventually(func(g Gomega) {
+			key := client.ObjectKey{Namespace: constants.Namespace, Name: "test"}
+			err := kClient.Get(ctx, key, cr)
+			g.Expect(err).ShouldNot(HaveOccurred())
+			g.Expect(cr.Status.ObservedGeneration).Should(Equal(cr.GetGeneration()))
+		}, 5*time.Second).Should(Succeed())
+
+		Expect(cr.Status.ConfigMapName).Should(Equal(resouceName))
+		Expect(cr.Status.DeploymentName).Should(Equal(resouceName))
+		Expect(cr.Status.ServiceName).Should(Equal(resouceName))
+		Expect(cr.Status.IngressName).Should(Equal(resouceName))
+		Expect(cr.Status.IngressSecretName).Should(BeEmpty())
+
+		// Pods are not scheduled in envtest, so the Deployment never becomes available.
+		Expect(meta.IsStatusConditionFalse(cr.Status.Conditions, ssanginxv1.ConditionTypeDegraded)).Should(BeTrue())
+		Expect(meta.IsStatusConditionFalse(cr.Status.Conditions, ssanginxv1.ConditionTypeReady)).Should(BeTrue())
+		Expect(meta.IsStatusConditionTrue(cr.Status.Conditions, ssanginxv1.ConditionTypeProgressing)).Should(BeTrue())
+	})
+
 	It("should create ca/server/client certtificate secret resource", func() {
 		cr := &ssanginxv1.SSANginx{}
 		key := client.ObjectKey{Namespace: constants.Namespace, Name: "test"}
@@ -236,20 +286,30 @@ var _ = Describe("Test Controller", func() {
 
 		caSec := &corev1.Secret{}
 		Eventually(func(g Gomega) {
-			key := client.ObjectKey{Namespace: constants.Namespace, Name: "ca-secret"}
+			key := client.ObjectKey{Namespace: constants.Namespace, Name: "test-" + constants.ServerSecretSuffix}
 			err := kClient.Get(ctx, key, caSec)
 			g.Expect(err).ShouldNot(HaveOccurred())
 		}, 5*time.Second).Should(Succeed())
 
 		cliSec := &corev1.Secret{}
 		Eventually(func(g Gomega) {
-			key := client.ObjectKey{Namespace: constants.Namespace, Name: "cli-secret"}
+			key := client.ObjectKey{Namespace: constants.Namespace, Name: "test-" + constants.ClientSecretSuffix}
 			err := kClient.Get(ctx, key, cliSec)
 			g.Expect(err).ShouldNot(HaveOccurred())
 		}, 5*time.Second).Should(Succeed())
 
+		caKeySec := &corev1.Secret{}
+		Eventually(func(g Gomega) {
+			key := client.ObjectKey{Namespace: constants.Namespace, Name: "test-" + constants.CASecretSuffix}
+			err := kClient.Get(ctx, key, caKeySec)
+			g.Expect(err).ShouldNot(HaveOccurred())
+		}, 5*time.Second).Should(Succeed())
+
 		Expect(caSec.OwnerReferences).ShouldNot(BeEmpty())
 		Expect(cliSec.OwnerReferences).ShouldNot(BeEmpty())
+		Expect(caKeySec.OwnerReferences).ShouldNot(BeEmpty())
+		Expect(caKeySec.Data[corev1.TLSPrivateKeyKey]).ShouldNot(BeEmpty())
+		Expect(caKeySec.Data[corev1.TLSCertKey]).Should(Equal(caSec.Data["ca.crt"]))
 
 		ing := &networkingv1.Ingress{}
 		Eventually(func(g Gomega) {
@@ -262,6 +322,32 @@ var _ = Describe("Test Controller", func() {
 		Expect(ing.Spec.TLS[0].SecretName).Should(Equal(caSec.GetName()))
 	})
 
+	It("should renew certificates expiring within renewBefore", func() {
+		cr := &ssanginxv1.SSANginx{}
+		key := client.ObjectKey{Namespace: constants.Namespace, Name: "test"}
+		err := kClient.Get(ctx, key, cr)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		// The certificates issued with the default duration of one year
+		// expire within two years, so they are reissued for three years.
+		cr.Spec.PKI = &ssanginxv1.PKISpec{
+			Duration:    &metav1.Duration{Duration: 3 * 365 * 24 * time.Hour},
+			RenewBefore: &metav1.Duration{Duration: 2 * 365 * 24 * time.Hour},
+		}
+		err = kClient.Update(ctx, cr)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Eventually(func(g Gomega) {
+			err := kClient.Get(ctx, key, cr)
+			g.Expect(err).ShouldNot(HaveOccurred())
+			g.Expect(cr.Status.Certificates).ShouldNot(BeNil())
+			g.Expect(cr.Status.Certificates.ServerNotAfter).ShouldNot(BeNil())
+			g.Expect(cr.Status.Certificates.ClientNotAfter).ShouldNot(BeNil())
+			g.Expect(cr.Status.Certificates.ServerNotAfter.Time).Should(BeTemporally(">", time.Now().Add(2*365*24*time.Hour)))
+			g.Expect(cr.Status.Certificates.ClientNotAfter.Time).Should(BeTemporally(">", time.Now().Add(2*365*24*time.Hour)))
+		}, 10*time.Second).Should(Succeed())
+	})
+
 	It("should update configmap name", func() {
 		cr := &ssanginxv1.SSANginx{}
 		key := client.ObjectKey{Namespace: constants.Namespace, Name: "test"}
@@ -287,8 +373,7 @@ var _ = Describe("Test Controller", func() {
 			g.Expect(err).ShouldNot(HaveOccurred())
 
 			for i, _ := range dep.Spec.Template.Spec.Containers {
-				s := strings.Split(dep.Spec.Template.Spec.Containers[i].Image, ":")
-				if s[0] == constants.CompareImageName {
+				if imageref.IsNginx(dep.Spec.Template.Spec.Containers[i].Image) {
 					if cr.Spec.ConfigMapName == dep.Spec.Template.Spec.Containers[i].VolumeMounts[0].Name {
 						sameName := true
 						g.Expect(sameName).Should(BeTrue())
@@ -323,4 +408,1239 @@ var _ = Describe("Test Controller", func() {
 			g.Expect(dep.GetName()).Should(Equal("nameupdate"))
 		}).Should(Succeed())
 	})
+
+	It("should create resources in the namespace of custom resource", func() {
+		ns := &corev1.Namespace{}
+		ns.Name = "another"
+		err := kClient.Create(ctx, ns)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		cr := testSSANginx()
+		cr.Namespace = ns.Name
+		cr.Spec.IngressSecureEnabled = true
+		err = kClient.Create(ctx, cr)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		cm := &corev1.ConfigMap{}
+		Eventually(func(g Gomega) {
+			key := client.ObjectKey{Namespace: ns.Name, Name: resouceName}
+			err := kClient.Get(ctx, key, cm)
+			g.Expect(err).ShouldNot(HaveOccurred())
+		}, 5*time.Second).Should(Succeed())
+		Expect(cm.OwnerReferences).ShouldNot(BeEmpty())
+
+		ing := &networkingv1.Ingress{}
+		Eventually(func(g Gomega) {
+			key := client.ObjectKey{Namespace: ns.Name, Name: resouceName}
+			err := kClient.Get(ctx, key, ing)
+			g.Expect(err).ShouldNot(HaveOccurred())
+			g.Expect(ing.Spec.TLS).ShouldNot(BeEmpty())
+		}, 5*time.Second).Should(Succeed())
+		Expect(ing.Annotations["nginx.ingress.kubernetes.io/auth-tls-secret"]).Should(Equal(ns.Name + "/test-" + constants.ServerSecretSuffix))
+	})
+
+	It("should delete owned resources when custom resource is deleted", func() {
+		cr := &ssanginxv1.SSANginx{}
+		key := client.ObjectKey{Namespace: "another", Name: "test"}
+		err := kClient.Get(ctx, key, cr)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(cr.Finalizers).Should(ContainElement(constants.Finalizer))
+
+		err = kClient.Delete(ctx, cr)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Eventually(func(g Gomega) {
+			cm := &corev1.ConfigMap{}
+			key := client.ObjectKey{Namespace: "another", Name: resouceName}
+			err := kClient.Get(ctx, key, cm)
+			g.Expect(apierrors.IsNotFound(err)).Should(BeTrue())
+		}, 5*time.Second).Should(Succeed())
+
+		Eventually(func(g Gomega) {
+			err := kClient.Get(ctx, key, &ssanginxv1.SSANginx{})
+			g.Expect(apierrors.IsNotFound(err)).Should(BeTrue())
+		}, 5*time.Second).Should(Succeed())
+	})
+
+	It("should orphan owned resources with deletionPolicy Orphan", func() {
+		ns := &corev1.Namespace{}
+		ns.Name = "orphan"
+		err := kClient.Create(ctx, ns)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		cr := testSSANginx()
+		cr.Namespace = ns.Name
+		cr.Spec.DeletionPolicy = ssanginxv1.DeletionPolicyOrphan
+		err = kClient.Create(ctx, cr)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Eventually(func(g Gomega) {
+			key := client.ObjectKey{Namespace: ns.Name, Name: cr.GetName()}
+			err := kClient.Get(ctx, key, cr)
+			g.Expect(err).ShouldNot(HaveOccurred())
+			g.Expect(cr.Status.ObservedGeneration).Should(Equal(cr.GetGeneration()))
+		}, 5*time.Second).Should(Succeed())
+
+		err = kClient.Delete(ctx, cr)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Eventually(func(g Gomega) {
+			svc := &corev1.Service{}
+			key := client.ObjectKey{Namespace: ns.Name, Name: resouceName}
+			err := kClient.Get(ctx, key, svc)
+			g.Expect(err).ShouldNot(HaveOccurred())
+			g.Expect(svc.OwnerReferences).Should(BeEmpty())
+		}, 5*time.Second).Should(Succeed())
+	})
+
+	It("should report degraded when cert-manager is not installed", func() {
+		ns := &corev1.Namespace{}
+		ns.Name = "certmanager"
+		err := kClient.Create(ctx, ns)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		cr := testSSANginx()
+		cr.Namespace = ns.Name
+		cr.Spec.IngressSecureEnabled = true
+		cr.Spec.TLS = &ssanginxv1.TLSSpec{
+			Mode: ssanginxv1.TLSModeCertManager,
+			CertManager: &ssanginxv1.CertManagerSpec{
+				IssuerRef: ssanginxv1.IssuerReference{Name: "letsencrypt", Kind: "ClusterIssuer"},
+			},
+		}
+		err = kClient.Create(ctx, cr)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Eventually(func(g Gomega) {
+			key := client.ObjectKey{Namespace: ns.Name, Name: cr.GetName()}
+			err := kClient.Get(ctx, key, cr)
+			g.Expect(err).ShouldNot(HaveOccurred())
+			cond := meta.FindStatusCondition(cr.Status.Conditions, ssanginxv1.ConditionTypeDegraded)
+			g.Expect(cond).ShouldNot(BeNil())
+			g.Expect(cond.Status).Should(Equal(metav1.ConditionTrue))
+			g.Expect(cond.Reason).Should(Equal(ssanginxv1.ReasonIngressApplyFailed))
+		}, 5*time.Second).Should(Succeed())
+	})
+
+	It("should clear the cert-manager annotation after switching back to SelfSigned", func() {
+		ns := &corev1.Namespace{}
+		ns.Name = "certmanagercleanup"
+		err := kClient.Create(ctx, ns)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		// A Secret of another owner is never used as the marker
+		other := &corev1.Secret{}
+		other.Namespace = ns.Name
+		other.Name = "other-" + constants.ServerSecretSuffix
+		other.Annotations = map[string]string{constants.CertManagerCertificateNameAnnotation: "other-server"}
+		err = kClient.Create(ctx, other)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		cr := testSSANginx()
+		cr.Namespace = ns.Name
+		cr.Spec.IngressSecureEnabled = true
+		err = kClient.Create(ctx, cr)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		secret := &corev1.Secret{}
+		Eventually(func() error {
+			return kClient.Get(ctx, client.ObjectKey{Namespace: ns.Name, Name: "test-" + constants.ServerSecretSuffix}, secret)
+		}, 5*time.Second).Should(Succeed())
+
+		// The annotation left by cert-manager on the Secret adopted by the SSANginx
+		patch := client.MergeFrom(secret.DeepCopy())
+		secret.Annotations = map[string]string{constants.CertManagerCertificateNameAnnotation: "test-server"}
+		err = kClient.Patch(ctx, secret, patch)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		// Trigger a reconcile
+		err = kClient.Get(ctx, client.ObjectKeyFromObject(cr), cr)
+		Expect(err).ShouldNot(HaveOccurred())
+		cr.Spec.CommonLabels = map[string]string{"team": "web"}
+		err = kClient.Update(ctx, cr)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Eventually(func(g Gomega) {
+			err := kClient.Get(ctx, client.ObjectKeyFromObject(secret), secret)
+			g.Expect(err).ShouldNot(HaveOccurred())
+			g.Expect(secret.Annotations).ShouldNot(HaveKey(constants.CertManagerCertificateNameAnnotation))
+		}, 5*time.Second).Should(Succeed())
+
+		err = kClient.Get(ctx, client.ObjectKeyFromObject(other), other)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(other.Annotations).Should(HaveKey(constants.CertManagerCertificateNameAnnotation))
+	})
+
+	It("should validate TLS secret provided by the user without overwriting it", func() {
+		ns := &corev1.Namespace{}
+		ns.Name = "byo"
+		err := kClient.Create(ctx, ns)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		caCrt, caKey, err := pki.CreateCaCrt(*testSSANginx(), time.Hour)
+		Expect(err).ShouldNot(HaveOccurred())
+		issuer, err := pki.NewIssuer(caCrt, caKey)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		// The certificate does not cover the host of the Ingress rule.
+		other := testSSANginx()
+		*other.Spec.IngressSpec.Rules[0].Host = "other.example.com"
+		svrCrt, svrKey, err := issuer.CreateSvrCrt(*other, time.Hour)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		secret := &corev1.Secret{}
+		secret.Namespace = ns.Name
+		secret.Name = "byo-server"
+		secret.Type = corev1.SecretTypeTLS
+		secret.Data = map[string][]byte{corev1.TLSCertKey: svrCrt, corev1.TLSPrivateKeyKey: svrKey}
+		err = kClient.Create(ctx, secret)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		cr := testSSANginx()
+		cr.Namespace = ns.Name
+		cr.Spec.IngressSecureEnabled = true
+		cr.Spec.TLS = &ssanginxv1.TLSSpec{ServerSecretName: secret.Name}
+		err = kClient.Create(ctx, cr)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Eventually(func(g Gomega) {
+			key := client.ObjectKey{Namespace: ns.Name, Name: cr.GetName()}
+			err := kClient.Get(ctx, key, cr)
+			g.Expect(err).ShouldNot(HaveOccurred())
+			cond := meta.FindStatusCondition(cr.Status.Conditions, ssanginxv1.ConditionTypeTLSSecretsValid)
+			g.Expect(cond).ShouldNot(BeNil())
+			g.Expect(cond.Status).Should(Equal(metav1.ConditionFalse))
+			g.Expect(cond.Reason).Should(Equal(ssanginxv1.ReasonSecretInvalid))
+		}, 5*time.Second).Should(Succeed())
+
+		s := corev1.Secret{}
+		err = kClient.Get(ctx, client.ObjectKeyFromObject(secret), &s)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(s.Data[corev1.TLSCertKey]).Should(Equal(svrCrt))
+
+		svrCrt, svrKey, err = issuer.CreateSvrCrt(*cr, time.Hour)
+		Expect(err).ShouldNot(HaveOccurred())
+		s.Data = map[string][]byte{corev1.TLSCertKey: svrCrt, corev1.TLSPrivateKeyKey: svrKey}
+		err = kClient.Update(ctx, &s)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Eventually(func(g Gomega) {
+			key := client.ObjectKey{Namespace: ns.Name, Name: cr.GetName()}
+			err := kClient.Get(ctx, key, cr)
+			g.Expect(err).ShouldNot(HaveOccurred())
+			cond := meta.FindStatusCondition(cr.Status.Conditions, ssanginxv1.ConditionTypeTLSSecretsValid)
+			g.Expect(cond).ShouldNot(BeNil())
+			g.Expect(cond.Status).Should(Equal(metav1.ConditionTrue))
+		}, 10*time.Second).Should(Succeed())
+
+		i := networkingv1.Ingress{}
+		err = kClient.Get(ctx, client.ObjectKey{Namespace: ns.Name, Name: resouceName}, &i)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(i.Spec.TLS[0].SecretName).Should(Equal(secret.Name))
+		Expect(i.Annotations["nginx.ingress.kubernetes.io/auth-tls-secret"]).Should(Equal(ns.Name + "/" + cr.GetName() + "-ca-keypair"))
+	})
+
+	It("should validate the TLS secret again when the user creates it", func() {
+		ns := &corev1.Namespace{}
+		ns.Name = "byolater"
+		err := kClient.Create(ctx, ns)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		cr := testSSANginx()
+		cr.Namespace = ns.Name
+		cr.Spec.IngressSecureEnabled = true
+		cr.Spec.TLS = &ssanginxv1.TLSSpec{ClientCASecretName: "corporate-client-ca"}
+		err = kClient.Create(ctx, cr)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Eventually(func(g Gomega) {
+			err := kClient.Get(ctx, client.ObjectKeyFromObject(cr), cr)
+			g.Expect(err).ShouldNot(HaveOccurred())
+			cond := meta.FindStatusCondition(cr.Status.Conditions, ssanginxv1.ConditionTypeTLSSecretsValid)
+			g.Expect(cond).ShouldNot(BeNil())
+			g.Expect(cond.Status).Should(Equal(metav1.ConditionFalse))
+		}, 5*time.Second).Should(Succeed())
+
+		// The invalid Secret is not retried, so only the watch of the Secret triggers the reconcile
+		caCrt, _, err := pki.CreateCaCrt(*cr, time.Hour)
+		Expect(err).ShouldNot(HaveOccurred())
+		secret := &corev1.Secret{}
+		secret.Namespace = ns.Name
+		secret.Name = cr.Spec.TLS.ClientCASecretName
+		secret.Data = map[string][]byte{"ca.crt": caCrt}
+		err = kClient.Create(ctx, secret)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Eventually(func(g Gomega) {
+			err := kClient.Get(ctx, client.ObjectKeyFromObject(cr), cr)
+			g.Expect(err).ShouldNot(HaveOccurred())
+			cond := meta.FindStatusCondition(cr.Status.Conditions, ssanginxv1.ConditionTypeTLSSecretsValid)
+			g.Expect(cond).ShouldNot(BeNil())
+			g.Expect(cond.Status).Should(Equal(metav1.ConditionTrue))
+		}, 5*time.Second).Should(Succeed())
+
+		ing := networkingv1.Ingress{}
+		err = kClient.Get(ctx, client.ObjectKey{Namespace: ns.Name, Name: resouceName}, &ing)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(ing.Annotations["nginx.ingress.kubernetes.io/auth-tls-secret"]).Should(Equal(ns.Name + "/" + secret.Name))
+	})
+
+	It("should issue named client certificates and revoke removed ones", func() {
+		ns := &corev1.Namespace{}
+		ns.Name = "clients"
+		err := kClient.Create(ctx, ns)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		cr := testSSANginx()
+		cr.Namespace = ns.Name
+		cr.Spec.IngressSecureEnabled = true
+		cr.Spec.ClientCertificates = []ssanginxv1.ClientCertificate{
+			{Name: "team-a", Organizations: []string{"Team A"}},
+			{Name: "team-b", KeyAlgorithm: ssanginxv1.KeyAlgorithmECDSAP256},
+		}
+		err = kClient.Create(ctx, cr)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Eventually(func(g Gomega) {
+			key := client.ObjectKey{Namespace: ns.Name, Name: cr.GetName()}
+			err := kClient.Get(ctx, key, cr)
+			g.Expect(err).ShouldNot(HaveOccurred())
+			g.Expect(cr.Status.Certificates).ShouldNot(BeNil())
+			g.Expect(cr.Status.Certificates.Clients).Should(HaveLen(2))
+			for _, c := range cr.Status.Certificates.Clients {
+				g.Expect(c.SecretName).Should(Equal(cr.GetName() + "-client-" + c.Name))
+				g.Expect(c.SerialNumber).ShouldNot(BeEmpty())
+				g.Expect(c.NotAfter).ShouldNot(BeNil())
+			}
+		}, 5*time.Second).Should(Succeed())
+
+		s := corev1.Secret{}
+		err = kClient.Get(ctx, client.ObjectKey{Namespace: ns.Name, Name: "test-" + constants.ClientSecretSuffix}, &s)
+		Expect(apierrors.IsNotFound(err)).Should(BeTrue())
+
+		cr.Spec.ClientCertificates = cr.Spec.ClientCertificates[:1]
+		err = kClient.Update(ctx, cr)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Eventually(func() bool {
+			err := kClient.Get(ctx, client.ObjectKey{Namespace: ns.Name, Name: cr.GetName() + "-client-team-b"}, &s)
+			return apierrors.IsNotFound(err)
+		}, 5*time.Second).Should(BeTrue())
+
+		err = kClient.Get(ctx, client.ObjectKey{Namespace: ns.Name, Name: cr.GetName() + "-client-team-a"}, &s)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		var serialNumber string
+		for _, c := range cr.Status.Certificates.Clients {
+			if c.Name == "team-b" {
+				serialNumber = c.SerialNumber
+			}
+		}
+
+		// The revoked certificate is listed in the CRL of both Secrets read by ingress-nginx
+		for _, name := range []string{cr.GetName() + "-ca-keypair", cr.GetName() + "-" + constants.ServerSecretSuffix} {
+			Eventually(func(g Gomega) {
+				err := kClient.Get(ctx, client.ObjectKey{Namespace: ns.Name, Name: name}, &s)
+				g.Expect(err).ShouldNot(HaveOccurred())
+				block, _ := pem.Decode(s.Data["ca.crl"])
+				g.Expect(block).ShouldNot(BeNil())
+				crl, err := x509.ParseRevocationList(block.Bytes)
+				g.Expect(err).ShouldNot(HaveOccurred())
+				g.Expect(crl.RevokedCertificates).Should(HaveLen(1))
+				g.Expect(crl.RevokedCertificates[0].SerialNumber.Text(16)).Should(Equal(serialNumber))
+			}, 5*time.Second).Should(Succeed())
+		}
+	})
+
+	It("should generate certificates with the key algorithm and subject of spec.pki", func() {
+		ns := &corev1.Namespace{}
+		ns.Name = "pki"
+		err := kClient.Create(ctx, ns)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		cr := testSSANginx()
+		cr.Namespace = ns.Name
+		cr.Spec.IngressSecureEnabled = true
+		cr.Spec.PKI = &ssanginxv1.PKISpec{
+			KeyAlgorithm: ssanginxv1.KeyAlgorithmECDSAP384,
+			Subject:      &ssanginxv1.SubjectSpec{Organizations: []string{"Acme"}, Countries: []string{"US"}},
+		}
+		err = kClient.Create(ctx, cr)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		s := corev1.Secret{}
+		Eventually(func() error {
+			return kClient.Get(ctx, client.ObjectKey{Namespace: ns.Name, Name: "test-" + constants.ServerSecretSuffix}, &s)
+		}, 5*time.Second).Should(Succeed())
+
+		keyBlock, _ := pem.Decode(s.Data["tls.key"])
+		Expect(keyBlock).ShouldNot(BeNil())
+		Expect(keyBlock.Type).Should(Equal("PRIVATE KEY"))
+
+		crtBlock, _ := pem.Decode(s.Data["tls.crt"])
+		Expect(crtBlock).ShouldNot(BeNil())
+		crt, err := x509.ParseCertificate(crtBlock.Bytes)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(crt.PublicKeyAlgorithm).Should(Equal(x509.ECDSA))
+		Expect(crt.Subject.Organization).Should(Equal([]string{"Acme"}))
+		Expect(crt.Subject.Country).Should(Equal([]string{"US"}))
+		Expect(crt.SubjectKeyId).ShouldNot(BeEmpty())
+		Expect(crt.AuthorityKeyId).ShouldNot(BeEmpty())
+		Expect(crt.SerialNumber.BitLen()).Should(BeNumerically(">", 64))
+	})
+
+	It("should cover every Ingress host with the server certificate", func() {
+		ns := &corev1.Namespace{}
+		ns.Name = "multihost"
+		err := kClient.Create(ctx, ns)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		cr := testSSANginx()
+		cr.Namespace = ns.Name
+		cr.Spec.IngressSecureEnabled = true
+		cr.Spec.TLS = &ssanginxv1.TLSSpec{IPAddresses: []string{"192.0.2.10"}}
+		err = kClient.Create(ctx, cr)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		serverCertificate := func(g Gomega) *x509.Certificate {
+			s := corev1.Secret{}
+			err := kClient.Get(ctx, client.ObjectKey{Namespace: ns.Name, Name: "test-" + constants.ServerSecretSuffix}, &s)
+			g.Expect(err).ShouldNot(HaveOccurred())
+			block, _ := pem.Decode(s.Data["tls.crt"])
+			g.Expect(block).ShouldNot(BeNil())
+			crt, err := x509.ParseCertificate(block.Bytes)
+			g.Expect(err).ShouldNot(HaveOccurred())
+			return crt
+		}
+
+		var caKeypair []byte
+		Eventually(func(g Gomega) {
+			crt := serverCertificate(g)
+			g.Expect(crt.DNSNames).Should(Equal([]string{hostname}))
+			g.Expect(crt.IPAddresses[0].String()).Should(Equal("192.0.2.10"))
+			s := corev1.Secret{}
+			err := kClient.Get(ctx, client.ObjectKey{Namespace: ns.Name, Name: cr.GetName() + "-ca-keypair"}, &s)
+			g.Expect(err).ShouldNot(HaveOccurred())
+			caKeypair = s.Data[corev1.TLSCertKey]
+		}, 5*time.Second).Should(Succeed())
+
+		// Add a second host
+		err = kClient.Get(ctx, client.ObjectKeyFromObject(cr), cr)
+		Expect(err).ShouldNot(HaveOccurred())
+		rules := cr.Spec.IngressSpec.Rules
+		second := rules[0]
+		second.WithHost("www.example.com")
+		cr.Spec.IngressSpec.Rules = append(rules, second)
+		err = kClient.Update(ctx, cr)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Eventually(func(g Gomega) {
+			crt := serverCertificate(g)
+			g.Expect(crt.DNSNames).Should(ConsistOf(hostname, "www.example.com"))
+			ing := networkingv1.Ingress{}
+			err := kClient.Get(ctx, client.ObjectKey{Namespace: ns.Name, Name: resouceName}, &ing)
+			g.Expect(err).ShouldNot(HaveOccurred())
+			g.Expect(ing.Spec.TLS[0].Hosts).Should(ConsistOf(hostname, "www.example.com"))
+		}, 5*time.Second).Should(Succeed())
+
+		// The CA is kept
+		s := corev1.Secret{}
+		err = kClient.Get(ctx, client.ObjectKey{Namespace: ns.Name, Name: cr.GetName() + "-ca-keypair"}, &s)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(s.Data[corev1.TLSCertKey]).Should(Equal(caKeypair))
+	})
+
+	It("should reissue only the server certificate when the Ingress host changes", func() {
+		ns := &corev1.Namespace{}
+		ns.Name = "hostchange"
+		err := kClient.Create(ctx, ns)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		cr := testSSANginx()
+		cr.Namespace = ns.Name
+		cr.Spec.IngressSecureEnabled = true
+		err = kClient.Create(ctx, cr)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		secretData := func(g Gomega, suffix string) map[string][]byte {
+			s := corev1.Secret{}
+			err := kClient.Get(ctx, client.ObjectKey{Namespace: ns.Name, Name: "test-" + suffix}, &s)
+			g.Expect(err).ShouldNot(HaveOccurred())
+			return s.Data
+		}
+
+		var caKeypair, clientKeypair, serverKeypair map[string][]byte
+		Eventually(func(g Gomega) {
+			caKeypair = secretData(g, constants.CASecretSuffix)
+			clientKeypair = secretData(g, constants.ClientSecretSuffix)
+			serverKeypair = secretData(g, constants.ServerSecretSuffix)
+			g.Expect(pki.VerifyHosts(serverKeypair[corev1.TLSCertKey], []string{hostname})).Should(Succeed())
+		}, 5*time.Second).Should(Succeed())
+
+		err = kClient.Get(ctx, client.ObjectKeyFromObject(cr), cr)
+		Expect(err).ShouldNot(HaveOccurred())
+		cr.Spec.IngressSpec.Rules[0].WithHost("changed.example.com")
+		err = kClient.Update(ctx, cr)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Eventually(func(g Gomega) {
+			data := secretData(g, constants.ServerSecretSuffix)
+			g.Expect(data[corev1.TLSCertKey]).ShouldNot(Equal(serverKeypair[corev1.TLSCertKey]))
+			g.Expect(pki.VerifyHosts(data[corev1.TLSCertKey], []string{"changed.example.com"})).Should(Succeed())
+			g.Expect(data["ca.crt"]).Should(Equal(caKeypair[corev1.TLSCertKey]))
+
+			ing := networkingv1.Ingress{}
+			err := kClient.Get(ctx, client.ObjectKey{Namespace: ns.Name, Name: resouceName}, &ing)
+			g.Expect(err).ShouldNot(HaveOccurred())
+			g.Expect(ing.Spec.Rules[0].Host).Should(Equal("changed.example.com"))
+			g.Expect(ing.Spec.TLS[0].Hosts).Should(Equal([]string{"changed.example.com"}))
+			g.Expect(ing.Spec.TLS[0].SecretName).Should(Equal("test-" + constants.ServerSecretSuffix))
+		}, 5*time.Second).Should(Succeed())
+
+		// The CA and the client certificate are kept, so that the clients keep working
+		Expect(secretData(Default, constants.CASecretSuffix)).Should(Equal(caKeypair))
+		Expect(secretData(Default, constants.ClientSecretSuffix)).Should(Equal(clientKeypair))
+	})
+
+	It("should translate spec.mtls into ingress-nginx annotations", func() {
+		ns := &corev1.Namespace{}
+		ns.Name = "mtls"
+		err := kClient.Create(ctx, ns)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		var (
+			depth   int32 = 2
+			rewrite       = false
+		)
+		cr := testSSANginx()
+		cr.Namespace = ns.Name
+		cr.Spec.IngressSecureEnabled = true
+		cr.Spec.RewriteTargetEnabled = &rewrite
+		cr.Spec.MTLS = &ssanginxv1.MTLSSpec{
+			VerifyClient:              ssanginxv1.VerifyClientOptional,
+			VerifyDepth:               &depth,
+			PassCertificateToUpstream: true,
+			ErrorPage:                 "https://example.com/error.html",
+		}
+		err = kClient.Create(ctx, cr)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		ing := &networkingv1.Ingress{}
+		Eventually(func(g Gomega) {
+			key := client.ObjectKey{Namespace: ns.Name, Name: resouceName}
+			err := kClient.Get(ctx, key, ing)
+			g.Expect(err).ShouldNot(HaveOccurred())
+		}, 5*time.Second).Should(Succeed())
+		Expect(ing.Annotations).Should(HaveKeyWithValue(constants.AnnotationAuthTLSVerifyClient, "optional"))
+		Expect(ing.Annotations).Should(HaveKeyWithValue(constants.AnnotationAuthTLSVerifyDepth, "2"))
+		Expect(ing.Annotations).Should(HaveKeyWithValue(constants.AnnotationAuthTLSPassCertToUpstream, "true"))
+		Expect(ing.Annotations).Should(HaveKeyWithValue(constants.AnnotationAuthTLSErrorPage, "https://example.com/error.html"))
+		Expect(ing.Annotations).ShouldNot(HaveKey(constants.AnnotationRewriteTarget))
+	})
+
+	It("should configure HAProxy Ingress selected by the IngressClass", func() {
+		ns := &corev1.Namespace{}
+		ns.Name = "haproxy"
+		err := kClient.Create(ctx, ns)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		ic := &networkingv1.IngressClass{}
+		ic.Name = "haproxy-internal"
+		ic.Spec.Controller = constants.HAProxyIngressController
+		err = kClient.Create(ctx, ic)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		cr := testSSANginx()
+		cr.Namespace = ns.Name
+		cr.Spec.IngressSecureEnabled = true
+		cr.Spec.IngressClassName = ic.Name
+		cr.Spec.MTLS = &ssanginxv1.MTLSSpec{PassCertificateToUpstream: true}
+		err = kClient.Create(ctx, cr)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		ing := &networkingv1.Ingress{}
+		Eventually(func(g Gomega) {
+			key := client.ObjectKey{Namespace: ns.Name, Name: resouceName}
+			err := kClient.Get(ctx, key, ing)
+			g.Expect(err).ShouldNot(HaveOccurred())
+		}, 5*time.Second).Should(Succeed())
+		Expect(*ing.Spec.IngressClassName).Should(Equal(ic.Name))
+		Expect(ing.Annotations).Should(HaveKeyWithValue(constants.AnnotationHAProxyAuthTLSSecret, ns.Name+"/test-"+constants.ServerSecretSuffix))
+		Expect(ing.Annotations).Should(HaveKeyWithValue(constants.AnnotationHAProxyAuthTLSVerifyClient, "on"))
+		Expect(ing.Annotations).Should(HaveKeyWithValue(constants.AnnotationHAProxyAuthTLSCertHeader, "true"))
+		Expect(ing.Annotations).Should(HaveKeyWithValue(constants.AnnotationHAProxyRewriteTarget, "/"))
+		Expect(ing.Annotations).ShouldNot(HaveKey(constants.AnnotationAuthTLSSecret))
+	})
+
+	It("should report degraded when the Gateway API is not installed", func() {
+		ns := &corev1.Namespace{}
+		ns.Name = "gatewayapi"
+		err := kClient.Create(ctx, ns)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		cr := testSSANginx()
+		cr.Namespace = ns.Name
+		cr.Spec.Routing = &ssanginxv1.RoutingSpec{
+			Mode:    ssanginxv1.RoutingModeGatewayAPI,
+			Gateway: &ssanginxv1.GatewaySpec{GatewayClassName: "example"},
+		}
+		err = kClient.Create(ctx, cr)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Eventually(func(g Gomega) {
+			key := client.ObjectKey{Namespace: ns.Name, Name: cr.GetName()}
+			err := kClient.Get(ctx, key, cr)
+			g.Expect(err).ShouldNot(HaveOccurred())
+			cond := meta.FindStatusCondition(cr.Status.Conditions, ssanginxv1.ConditionTypeDegraded)
+			g.Expect(cond).ShouldNot(BeNil())
+			g.Expect(cond.Status).Should(Equal(metav1.ConditionTrue))
+			g.Expect(cond.Reason).Should(Equal(ssanginxv1.ReasonGatewayAPIApplyFailed))
+		}, 5*time.Second).Should(Succeed())
+	})
+
+	It("should split the HTTPRoutes by host", func() {
+		cr := testSSANginx()
+		(*networkv1apply.IngressSpecApplyConfiguration)(cr.Spec.IngressSpec).WithRules(networkv1apply.IngressRule().
+			WithHost("other.example.com").
+			WithHTTP(networkv1apply.HTTPIngressRuleValue().
+				WithPaths(networkv1apply.HTTPIngressPath().
+					WithPath("/other").
+					WithPathType(networkingv1.PathTypeExact).
+					WithBackend(networkv1apply.IngressBackend().
+						WithService(networkv1apply.IngressServiceBackend().
+							WithName("nginx").
+							WithPort(networkv1apply.ServiceBackendPort().
+								WithNumber(port)))))))
+
+		hosts := httpRouteHosts(*cr)
+		Expect(hosts).Should(Equal([]string{hostname, "other.example.com"}))
+		Expect(httpRouteName(*cr, 0)).Should(Equal(resouceName))
+		Expect(httpRouteName(*cr, 1)).Should(Equal(resouceName + "-1"))
+
+		for n, path := range []string{"/", "/other"} {
+			rules, err := httpRouteRules(*cr, hosts[n])
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(rules).Should(HaveLen(1))
+			match := rules[0].(map[string]interface{})["matches"].([]interface{})[0].(map[string]interface{})
+			Expect(match["path"]).Should(HaveKeyWithValue("value", path))
+		}
+	})
+
+	It("should keep the configmap when the nginx configuration is invalid", func() {
+		ns := &corev1.Namespace{}
+		ns.Name = "configinvalid"
+		err := kClient.Create(ctx, ns)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		cr := testSSANginx()
+		cr.Namespace = ns.Name
+		err = kClient.Create(ctx, cr)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		key := client.ObjectKey{Namespace: ns.Name, Name: cr.GetName()}
+		Eventually(func(g Gomega) {
+			err := kClient.Get(ctx, key, cr)
+			g.Expect(err).ShouldNot(HaveOccurred())
+			cond := meta.FindStatusCondition(cr.Status.Conditions, ssanginxv1.ConditionTypeConfigInvalid)
+			g.Expect(cond).ShouldNot(BeNil())
+			g.Expect(cond.Status).Should(Equal(metav1.ConditionFalse))
+		}, 5*time.Second).Should(Succeed())
+
+		// The other resources are still reconciled
+		cr.Spec.ConfigMapData["default.conf"] = "server {\n    listen 80\n}\n"
+		(*appsv1apply.DeploymentSpecApplyConfiguration)(cr.Spec.DeploymentSpec).WithReplicas(1)
+		err = kClient.Update(ctx, cr)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Eventually(func(g Gomega) {
+			err := kClient.Get(ctx, key, cr)
+			g.Expect(err).ShouldNot(HaveOccurred())
+			cond := meta.FindStatusCondition(cr.Status.Conditions, ssanginxv1.ConditionTypeConfigInvalid)
+			g.Expect(cond).ShouldNot(BeNil())
+			g.Expect(cond.Status).Should(Equal(metav1.ConditionTrue))
+			g.Expect(cond.Reason).Should(Equal(ssanginxv1.ReasonConfigSyntaxError))
+			g.Expect(cond.Message).Should(ContainSubstring("default.conf:3"))
+		}, 5*time.Second).Should(Succeed())
+
+		cm := corev1.ConfigMap{}
+		err = kClient.Get(ctx, client.ObjectKey{Namespace: ns.Name, Name: cr.Spec.ConfigMapName}, &cm)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(cm.Data["default.conf"]).Should(Equal(defaultconf))
+
+		dep := appsv1.Deployment{}
+		Eventually(func(g Gomega) {
+			err := kClient.Get(ctx, client.ObjectKey{Namespace: ns.Name, Name: cr.Spec.DeploymentName}, &dep)
+			g.Expect(err).ShouldNot(HaveOccurred())
+			g.Expect(*dep.Spec.Replicas).Should(Equal(int32(1)))
+		}, 5*time.Second).Should(Succeed())
+	})
+
+	It("should mount every configmap key in conf.d or the html root", func() {
+		ns := &corev1.Namespace{}
+		ns.Name = "configmapfiles"
+		err := kClient.Create(ctx, ns)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		cr := testSSANginx()
+		cr.Namespace = ns.Name
+		cr.Spec.ConfigMapData["api.conf"] = "server {\n    listen 8080;\n}\n"
+		cr.Spec.ConfigMapData["style.css"] = "body { margin: 0; }"
+		cr.Spec.ConfigMapData["logo.svg"] = "<svg></svg>"
+		cr.Spec.ConfigMapFiles = []ssanginxv1.ConfigMapFile{{Key: "logo.svg", Path: "img/logo.svg"}}
+		err = kClient.Create(ctx, cr)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		dep := &appsv1.Deployment{}
+		Eventually(func(g Gomega) {
+			key := client.ObjectKey{Namespace: ns.Name, Name: resouceName}
+			err := kClient.Get(ctx, key, dep)
+			g.Expect(err).ShouldNot(HaveOccurred())
+		}, 5*time.Second).Should(Succeed())
+
+		items := make(map[string][]corev1.KeyToPath)
+		for _, v := range dep.Spec.Template.Spec.Volumes {
+			if v.ConfigMap != nil {
+				items[v.Name] = v.ConfigMap.Items
+			}
+		}
+		Expect(items[constants.ConfVolumeName]).Should(Equal([]corev1.KeyToPath{
+			{Key: "api.conf", Path: "api.conf"},
+			{Key: "default.conf", Path: "default.conf"},
+		}))
+		Expect(items[constants.IndexVolumeName]).Should(Equal([]corev1.KeyToPath{
+			{Key: "index.html", Path: "index.html"},
+			{Key: "logo.svg", Path: "img/logo.svg"},
+			{Key: "style.css", Path: "style.css"},
+		}))
+	})
+
+	It("should populate the html root from the content source", func() {
+		ns := &corev1.Namespace{}
+		ns.Name = "contentsource"
+		err := kClient.Create(ctx, ns)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		cr := testSSANginx()
+		cr.Namespace = ns.Name
+		delete(cr.Spec.ConfigMapData, "index.html")
+		cr.Spec.ConfigMapBinaryData = map[string][]byte{"error.conf": []byte("error_page 404 /404.html;")}
+		cr.Spec.ContentSource = &ssanginxv1.ContentSource{
+			Type:    ssanginxv1.ContentSourceArchive,
+			Archive: &ssanginxv1.ArchiveContentSource{ConfigMapName: "site", Key: "site.tar.gz"},
+		}
+		err = kClient.Create(ctx, cr)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		dep := &appsv1.Deployment{}
+		Eventually(func(g Gomega) {
+			key := client.ObjectKey{Namespace: ns.Name, Name: resouceName}
+			err := kClient.Get(ctx, key, dep)
+			g.Expect(err).ShouldNot(HaveOccurred())
+		}, 5*time.Second).Should(Succeed())
+
+		cm := corev1.ConfigMap{}
+		err = kClient.Get(ctx, client.ObjectKey{Namespace: ns.Name, Name: cr.Spec.ConfigMapName}, &cm)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(cm.BinaryData).Should(HaveKey("error.conf"))
+
+		initContainers := dep.Spec.Template.Spec.InitContainers
+		Expect(initContainers).Should(HaveLen(2))
+		Expect(initContainers[1].Name).Should(Equal(constants.ContentContainerName))
+		Expect(initContainers[1].Image).Should(Equal(testReloaderImage))
+		Expect(initContainers[1].Command).Should(ContainElement(constants.ContentArchiveMountPath + "site.tar.gz"))
+
+		volumes := make(map[string]corev1.Volume)
+		for _, v := range dep.Spec.Template.Spec.Volumes {
+			volumes[v.Name] = v
+		}
+		Expect(volumes).ShouldNot(HaveKey(constants.IndexVolumeName))
+		Expect(volumes[constants.ContentVolumeName].EmptyDir).ShouldNot(BeNil())
+		Expect(volumes[constants.ArchiveVolumeName].ConfigMap.Name).Should(Equal("site"))
+		Expect(volumes[constants.ConfVolumeName].ConfigMap.Items).Should(HaveLen(2))
+	})
+
+	It("should render default.conf from spec.nginx", func() {
+		ns := &corev1.Namespace{}
+		ns.Name = "nginxspec"
+		err := kClient.Create(ctx, ns)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		cr := testSSANginx()
+		cr.Namespace = ns.Name
+		delete(cr.Spec.ConfigMapData, "default.conf")
+		cr.Spec.Nginx = &ssanginxv1.NginxSpec{
+			ServerNames: []string{hostname},
+			Locations: []ssanginxv1.NginxLocation{
+				{Path: "/api", ProxyPass: "http://backend:8080"},
+			},
+			ServerSnippet: "access_log off;",
+		}
+		err = kClient.Create(ctx, cr)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		cm := corev1.ConfigMap{}
+		Eventually(func(g Gomega) {
+			key := client.ObjectKey{Namespace: ns.Name, Name: cr.Spec.ConfigMapName}
+			err := kClient.Get(ctx, key, &cm)
+			g.Expect(err).ShouldNot(HaveOccurred())
+		}, 5*time.Second).Should(Succeed())
+		Expect(cm.Data["default.conf"]).Should(ContainSubstring(`server_name "` + hostname + `";`))
+		Expect(cm.Data["default.conf"]).Should(ContainSubstring(`proxy_pass "http://backend:8080";`))
+		Expect(cm.Data["default.conf"]).Should(ContainSubstring("    access_log off;"))
+
+		dep := &appsv1.Deployment{}
+		Eventually(func(g Gomega) {
+			key := client.ObjectKey{Namespace: ns.Name, Name: resouceName}
+			err := kClient.Get(ctx, key, dep)
+			g.Expect(err).ShouldNot(HaveOccurred())
+		}, 5*time.Second).Should(Succeed())
+		Expect(dep.Spec.Template.Spec.Volumes).Should(ContainElement(HaveField("ConfigMap.Items",
+			ContainElement(corev1.KeyToPath{Key: "default.conf", Path: "default.conf"}))))
+	})
+
+	It("should render upstreams of services and refresh them when the service is created", func() {
+		ns := &corev1.Namespace{}
+		ns.Name = "upstreams"
+		err := kClient.Create(ctx, ns)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		cr := testSSANginx()
+		cr.Namespace = ns.Name
+		cr.Spec.Upstreams = []ssanginxv1.Upstream{{
+			Name:    "backend",
+			Method:  ssanginxv1.LoadBalancingLeastConn,
+			Servers: []ssanginxv1.UpstreamServer{{ServiceName: "api", Port: intstr.FromString("http")}},
+		}}
+		err = kClient.Create(ctx, cr)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		key := client.ObjectKey{Namespace: ns.Name, Name: cr.GetName()}
+		Eventually(func(g Gomega) {
+			err := kClient.Get(ctx, key, cr)
+			g.Expect(err).ShouldNot(HaveOccurred())
+			cond := meta.FindStatusCondition(cr.Status.Conditions, ssanginxv1.ConditionTypeProgressing)
+			g.Expect(cond).ShouldNot(BeNil())
+			g.Expect(cond.Status).Should(Equal(metav1.ConditionTrue))
+			g.Expect(cond.Reason).Should(Equal(ssanginxv1.ReasonUpstreamPending))
+			g.Expect(cond.Message).Should(ContainSubstring(`upstream Service "api"`))
+		}, 5*time.Second).Should(Succeed())
+
+		// The missing Service does not block the other resources
+		dep := &appsv1.Deployment{}
+		err = kClient.Get(ctx, client.ObjectKey{Namespace: ns.Name, Name: cr.Spec.DeploymentName}, dep)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		svc := &corev1.Service{}
+		svc.Namespace = ns.Name
+		svc.Name = "api"
+		svc.Spec.Ports = []corev1.ServicePort{{Name: "http", Port: 8080}}
+		err = kClient.Create(ctx, svc)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		cm := corev1.ConfigMap{}
+		Eventually(func(g Gomega) {
+			key := client.ObjectKey{Namespace: ns.Name, Name: cr.Spec.ConfigMapName}
+			err := kClient.Get(ctx, key, &cm)
+			g.Expect(err).ShouldNot(HaveOccurred())
+			g.Expect(cm.Data["upstreams.conf"]).Should(ContainSubstring("least_conn;"))
+			g.Expect(cm.Data["upstreams.conf"]).Should(ContainSubstring("server " + svc.Spec.ClusterIP + ":8080;"))
+		}, 5*time.Second).Should(Succeed())
+	})
+
+	It("should roll the pods when the configmap changes with the RollingRestart reload strategy", func() {
+		ns := &corev1.Namespace{}
+		ns.Name = "rollingrestart"
+		err := kClient.Create(ctx, ns)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		cr := testSSANginx()
+		cr.Namespace = ns.Name
+		cr.Spec.ReloadStrategy = ssanginxv1.ReloadStrategyRollingRestart
+		err = kClient.Create(ctx, cr)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		dep := &appsv1.Deployment{}
+		depKey := client.ObjectKey{Namespace: ns.Name, Name: resouceName}
+		Eventually(func(g Gomega) {
+			err := kClient.Get(ctx, depKey, dep)
+			g.Expect(err).ShouldNot(HaveOccurred())
+		}, 5*time.Second).Should(Succeed())
+
+		checksum := dep.Spec.Template.Annotations[constants.AnnotationConfigChecksum]
+		Expect(checksum).ShouldNot(BeEmpty())
+		Expect(dep.Spec.Template.Spec.Containers).Should(HaveLen(1))
+		Expect(dep.Spec.Template.Spec.InitContainers).Should(BeEmpty())
+
+		err = kClient.Get(ctx, client.ObjectKey{Namespace: ns.Name, Name: cr.GetName()}, cr)
+		Expect(err).ShouldNot(HaveOccurred())
+		cr.Spec.ConfigMapData["index.html"] = "<h1>updated</h1>"
+		err = kClient.Update(ctx, cr)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Eventually(func(g Gomega) {
+			err := kClient.Get(ctx, depKey, dep)
+			g.Expect(err).ShouldNot(HaveOccurred())
+			g.Expect(dep.Spec.Template.Annotations[constants.AnnotationConfigChecksum]).ShouldNot(Equal(checksum))
+		}, 5*time.Second).Should(Succeed())
+	})
+
+	It("should mount the volumes in the container named by nginxContainerName", func() {
+		ns := &corev1.Namespace{}
+		ns.Name = "nginxcontainername"
+		err := kClient.Create(ctx, ns)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		cr := testSSANginx()
+		cr.Namespace = ns.Name
+		cr.Spec.DeploymentSpec.Template.Spec.Containers[0].
+			WithName("web").
+			WithImage("registry.example.com:5000/team/web@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef")
+		cr.Spec.DeploymentSpec.Template.Spec.WithContainers(corev1apply.Container().
+			WithName("exporter").
+			WithImage("nginx/nginx-prometheus-exporter:0.11.0"))
+		cr.Spec.NginxContainerName = "web"
+		err = kClient.Create(ctx, cr)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		dep := &appsv1.Deployment{}
+		Eventually(func(g Gomega) {
+			err := kClient.Get(ctx, client.ObjectKey{Namespace: ns.Name, Name: resouceName}, dep)
+			g.Expect(err).ShouldNot(HaveOccurred())
+		}, 5*time.Second).Should(Succeed())
+
+		containers := dep.Spec.Template.Spec.Containers
+		Expect(containers).Should(HaveLen(3))
+		Expect(containers[0].Name).Should(Equal("web"))
+		Expect(containers[0].VolumeMounts).ShouldNot(BeEmpty())
+		Expect(containers[1].Name).Should(Equal("exporter"))
+		Expect(containers[1].VolumeMounts).Should(BeEmpty())
+		Expect(containers[2].Name).Should(Equal(constants.ReloaderContainerName))
+		Expect(containers[2].Image).Should(Equal(containers[0].Image))
+	})
+
+	It("should create the deployment without the reloader when no conf file is mounted", func() {
+		ns := &corev1.Namespace{}
+		ns.Name = "noconf"
+		err := kClient.Create(ctx, ns)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		cr := testSSANginx()
+		cr.Namespace = ns.Name
+		delete(cr.Spec.ConfigMapData, "default.conf")
+		err = kClient.Create(ctx, cr)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		dep := &appsv1.Deployment{}
+		Eventually(func(g Gomega) {
+			err := kClient.Get(ctx, client.ObjectKey{Namespace: ns.Name, Name: resouceName}, dep)
+			g.Expect(err).ShouldNot(HaveOccurred())
+		}, 5*time.Second).Should(Succeed())
+
+		Expect(dep.Spec.Template.Spec.Containers).Should(HaveLen(1))
+		Expect(dep.Spec.Template.Spec.InitContainers).Should(BeEmpty())
+		for _, v := range dep.Spec.Template.Spec.Volumes {
+			Expect(v.Name).ShouldNot(Equal(constants.ConfVolumeName))
+		}
+		for _, m := range dep.Spec.Template.Spec.Containers[0].VolumeMounts {
+			Expect(m.Name).ShouldNot(Equal(constants.ConfVolumeName))
+		}
+	})
+
+	It("should generate a restricted pod template with the Restricted security profile", func() {
+		ns := &corev1.Namespace{}
+		ns.Name = "restricted"
+		err := kClient.Create(ctx, ns)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		cr := testSSANginx()
+		cr.Namespace = ns.Name
+		cr.Spec.SecurityProfile = ssanginxv1.SecurityProfileRestricted
+		cr.Spec.ConfigMapData["default.conf"] = strings.ReplaceAll(defaultconf, "80", "8080")
+		cr.Spec.DeploymentSpec.Template.Spec.Containers[0].
+			WithPorts(
+				corev1apply.ContainerPort().WithName("http").WithContainerPort(80),
+				corev1apply.ContainerPort().WithName("https").WithContainerPort(443)).
+			WithReadinessProbe(corev1apply.Probe().
+				WithHTTPGet(corev1apply.HTTPGetAction().WithPath("/").WithPort(intstr.FromString("https")))).
+			WithLivenessProbe(corev1apply.Probe().
+				WithTCPSocket(corev1apply.TCPSocketAction().WithPort(intstr.FromInt(80))))
+		err = kClient.Create(ctx, cr)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		dep := &appsv1.Deployment{}
+		Eventually(func(g Gomega) {
+			err := kClient.Get(ctx, client.ObjectKey{Namespace: ns.Name, Name: resouceName}, dep)
+			g.Expect(err).ShouldNot(HaveOccurred())
+		}, 5*time.Second).Should(Succeed())
+
+		podSC := dep.Spec.Template.Spec.SecurityContext
+		Expect(podSC).ShouldNot(BeNil())
+		Expect(*podSC.RunAsNonRoot).Should(BeTrue())
+		Expect(*podSC.RunAsUser).Should(Equal(int64(constants.RestrictedUserID)))
+		Expect(podSC.SeccompProfile.Type).Should(Equal(corev1.SeccompProfileTypeRuntimeDefault))
+
+		containers := append(dep.Spec.Template.Spec.InitContainers, dep.Spec.Template.Spec.Containers...)
+		for _, c := range containers {
+			Expect(c.SecurityContext).ShouldNot(BeNil(), c.Name)
+			Expect(*c.SecurityContext.AllowPrivilegeEscalation).Should(BeFalse(), c.Name)
+			Expect(*c.SecurityContext.ReadOnlyRootFilesystem).Should(BeTrue(), c.Name)
+			Expect(c.SecurityContext.Capabilities.Drop).Should(ConsistOf(corev1.Capability("ALL")), c.Name)
+		}
+
+		var mountPaths []string
+		for _, m := range dep.Spec.Template.Spec.Containers[0].VolumeMounts {
+			mountPaths = append(mountPaths, m.MountPath)
+		}
+		Expect(mountPaths).Should(ContainElements(constants.CacheVolumeMountPath, constants.RunVolumeMountPath, constants.TmpVolumeMountPath))
+
+		// The privileged ports are merged into the unprivileged one
+		nginx := dep.Spec.Template.Spec.Containers[0]
+		Expect(nginx.Ports).Should(HaveLen(1))
+		Expect(nginx.Ports[0].ContainerPort).Should(Equal(int32(constants.UnprivilegedPort)))
+		Expect(nginx.ReadinessProbe.HTTPGet.Port).Should(Equal(intstr.FromInt(constants.UnprivilegedPort)))
+		Expect(nginx.LivenessProbe.TCPSocket.Port).Should(Equal(intstr.FromInt(constants.UnprivilegedPort)))
+
+		svc := &corev1.Service{}
+		Eventually(func(g Gomega) {
+			err := kClient.Get(ctx, client.ObjectKey{Namespace: ns.Name, Name: cr.Spec.ServiceName}, svc)
+			g.Expect(err).ShouldNot(HaveOccurred())
+			g.Expect(svc.Spec.Ports[0].TargetPort).Should(Equal(intstr.FromInt(constants.UnprivilegedPort)))
+		}, 5*time.Second).Should(Succeed())
+	})
+
+	// The CRDs of cert-manager and the Gateway API are installed before the controller is restarted,
+	// since it checks the Gateway API when it starts.
+	installCRDs := func() {
+		crds, err := envtest.InstallCRDs(cfg, envtest.CRDInstallOptions{Paths: []string{filepath.Join("testdata", "crds")}})
+		Expect(err).ShouldNot(HaveOccurred())
+		DeferCleanup(func() {
+			err := envtest.UninstallCRDs(cfg, envtest.CRDInstallOptions{CRDs: crds})
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+		stopFunc()
+		time.Sleep(100 * time.Millisecond)
+		startManager()
+	}
+
+	// Create an SSANginx issuing its certificates with cert-manager and another one routing with the Gateway API,
+	// so that every kind of resource owned by an SSANginx exists.
+	createOwnedResources := func(namespace string, policy ssanginxv1.DeletionPolicy) ([]*ssanginxv1.SSANginx, []*unstructured.Unstructured) {
+		installCRDs()
+
+		ns := &corev1.Namespace{}
+		ns.Name = namespace
+		err := kClient.Create(ctx, ns)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		issuing := testSSANginx()
+		issuing.Namespace = ns.Name
+		issuing.Spec.DeletionPolicy = policy
+		issuing.Spec.IngressSecureEnabled = true
+		issuing.Spec.TLS = &ssanginxv1.TLSSpec{
+			Mode: ssanginxv1.TLSModeCertManager,
+			CertManager: &ssanginxv1.CertManagerSpec{
+				IssuerRef: ssanginxv1.IssuerReference{Name: "letsencrypt", Kind: "ClusterIssuer"},
+			},
+		}
+		err = kClient.Create(ctx, issuing)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		routing := testSSANginx()
+		routing.Namespace = ns.Name
+		routing.Name = "route"
+		routing.Spec.ConfigMapName = routing.Name
+		routing.Spec.DeploymentName = routing.Name
+		routing.Spec.ServiceName = routing.Name
+		routing.Spec.IngressName = routing.Name
+		routing.Spec.DeletionPolicy = policy
+		routing.Spec.Routing = &ssanginxv1.RoutingSpec{
+			Mode:    ssanginxv1.RoutingModeGatewayAPI,
+			Gateway: &ssanginxv1.GatewaySpec{GatewayClassName: "example"},
+		}
+		err = kClient.Create(ctx, routing)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		var objs []*unstructured.Unstructured
+		for _, res := range []struct {
+			gvk  schema.GroupVersionKind
+			name string
+		}{
+			{certificateGVK, "test-" + constants.ServerCertificateSuffix},
+			{certificateGVK, "test-" + constants.ClientCertificateSuffix},
+			{certificateGVK, "test-" + constants.CACertificateSuffix},
+			{issuerGVK, "test-" + constants.CAIssuerSuffix},
+			{issuerGVK, "test-" + constants.SelfSignedIssuerSuffix},
+			{httpRouteGVK, routing.Name},
+			{gatewayGVK, routing.Name},
+		} {
+			obj := &unstructured.Unstructured{}
+			obj.SetGroupVersionKind(res.gvk)
+			Eventually(func() error {
+				return kClient.Get(ctx, client.ObjectKey{Namespace: ns.Name, Name: res.name}, obj)
+			}, 5*time.Second).Should(Succeed(), res.gvk.Kind+" "+res.name)
+			objs = append(objs, obj)
+		}
+
+		return []*ssanginxv1.SSANginx{issuing, routing}, objs
+	}
+
+	It("should delete the cert-manager and Gateway API resources with deletionPolicy Delete", func() {
+		crs, objs := createOwnedResources("policydelete", ssanginxv1.DeletionPolicyDelete)
+		for _, cr := range crs {
+			err := kClient.Delete(ctx, cr)
+			Expect(err).ShouldNot(HaveOccurred())
+		}
+
+		for _, obj := range objs {
+			Eventually(func(g Gomega) {
+				err := kClient.Get(ctx, client.ObjectKeyFromObject(obj), obj)
+				g.Expect(apierrors.IsNotFound(err)).Should(BeTrue())
+			}, 5*time.Second).Should(Succeed(), obj.GetKind()+" "+obj.GetName())
+		}
+	})
+
+	It("should orphan the cert-manager and Gateway API resources with deletionPolicy Orphan", func() {
+		crs, objs := createOwnedResources("policyorphan", ssanginxv1.DeletionPolicyOrphan)
+		for _, cr := range crs {
+			err := kClient.Delete(ctx, cr)
+			Expect(err).ShouldNot(HaveOccurred())
+		}
+
+		for _, obj := range objs {
+			Eventually(func(g Gomega) {
+				err := kClient.Get(ctx, client.ObjectKeyFromObject(obj), obj)
+				g.Expect(err).ShouldNot(HaveOccurred())
+				g.Expect(obj.GetOwnerReferences()).Should(BeEmpty())
+			}, 5*time.Second).Should(Succeed(), obj.GetKind()+" "+obj.GetName())
+		}
+	})
+
+	It("should delete the cert-manager and Gateway API resources but keep the secrets with deletionPolicy RetainSecrets", func() {
+		crs, objs := createOwnedResources("policyretainsecrets", ssanginxv1.DeletionPolicyRetainSecrets)
+
+		// The Secret written by cert-manager and adopted by the SSANginx
+		secret := &corev1.Secret{}
+		secret.Namespace = crs[0].Namespace
+		secret.Name = "test-" + constants.ServerSecretSuffix
+		err := controllerutil.SetControllerReference(crs[0], secret, scheme)
+		Expect(err).ShouldNot(HaveOccurred())
+		err = kClient.Create(ctx, secret)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		for _, cr := range crs {
+			err := kClient.Delete(ctx, cr)
+			Expect(err).ShouldNot(HaveOccurred())
+		}
+
+		for _, obj := range objs {
+			Eventually(func(g Gomega) {
+				err := kClient.Get(ctx, client.ObjectKeyFromObject(obj), obj)
+				g.Expect(apierrors.IsNotFound(err)).Should(BeTrue())
+			}, 5*time.Second).Should(Succeed(), obj.GetKind()+" "+obj.GetName())
+		}
+
+		Eventually(func(g Gomega) {
+			err := kClient.Get(ctx, client.ObjectKeyFromObject(secret), secret)
+			g.Expect(err).ShouldNot(HaveOccurred())
+			g.Expect(secret.OwnerReferences).Should(BeEmpty())
+		}, 5*time.Second).Should(Succeed())
+	})
+
+	It("should report CertificatePending until the cert-manager Secret covers the new Ingress host", func() {
+		installCRDs()
+
+		ns := &corev1.Namespace{}
+		ns.Name = "hostchangecertmanager"
+		err := kClient.Create(ctx, ns)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		cr := testSSANginx()
+		cr.Namespace = ns.Name
+		cr.Spec.IngressSecureEnabled = true
+		cr.Spec.TLS = &ssanginxv1.TLSSpec{
+			Mode: ssanginxv1.TLSModeCertManager,
+			CertManager: &ssanginxv1.CertManagerSpec{
+				IssuerRef: ssanginxv1.IssuerReference{Name: "letsencrypt", Kind: "ClusterIssuer"},
+			},
+		}
+		err = kClient.Create(ctx, cr)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		// Write the Secrets as cert-manager does
+		caCrt, caKey, err := pki.CreateCaCrt(*cr, time.Hour)
+		Expect(err).ShouldNot(HaveOccurred())
+		issuer, err := pki.NewIssuer(caCrt, caKey)
+		Expect(err).ShouldNot(HaveOccurred())
+		writeSecret := func(suffix, certificate string, crt, key []byte) *corev1.Secret {
+			secret := &corev1.Secret{}
+			secret.Namespace = ns.Name
+			secret.Name = "test-" + suffix
+			_, err := controllerutil.CreateOrUpdate(ctx, kClient, secret, func() error {
+				secret.Annotations = map[string]string{constants.CertManagerCertificateNameAnnotation: "test-" + certificate}
+				secret.Data = map[string][]byte{corev1.TLSCertKey: crt, corev1.TLSPrivateKeyKey: key, "ca.crt": caCrt}
+				return nil
+			})
+			Expect(err).ShouldNot(HaveOccurred())
+			return secret
+		}
+		svrCrt, svrKey, err := issuer.CreateSvrCrt(*cr, time.Hour)
+		Expect(err).ShouldNot(HaveOccurred())
+		cliCrt, cliKey, err := issuer.CreateClientCrt(*cr, ssanginxv1.ClientCertificate{Name: constants.DefaultClientName}, time.Hour)
+		Expect(err).ShouldNot(HaveOccurred())
+		caSecret := writeSecret(constants.CASecretSuffix, constants.CACertificateSuffix, caCrt, caKey)
+		clientSecret := writeSecret(constants.ClientSecretSuffix, constants.ClientCertificateSuffix, cliCrt, cliKey)
+		writeSecret(constants.ServerSecretSuffix, constants.ServerCertificateSuffix, svrCrt, svrKey)
+
+		ing := &networkingv1.Ingress{}
+		Eventually(func(g Gomega) {
+			err := kClient.Get(ctx, client.ObjectKey{Namespace: ns.Name, Name: resouceName}, ing)
+			g.Expect(err).ShouldNot(HaveOccurred())
+			g.Expect(ing.Spec.TLS[0].Hosts).Should(Equal([]string{hostname}))
+		}, 10*time.Second).Should(Succeed())
+
+		err = kClient.Get(ctx, client.ObjectKeyFromObject(cr), cr)
+		Expect(err).ShouldNot(HaveOccurred())
+		cr.Spec.IngressSpec.Rules[0].WithHost("changed.example.com")
+		err = kClient.Update(ctx, cr)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		// The Ingress keeps the previous host until the certificate covers the new one
+		Eventually(func(g Gomega) {
+			err := kClient.Get(ctx, client.ObjectKeyFromObject(cr), cr)
+			g.Expect(err).ShouldNot(HaveOccurred())
+			cond := meta.FindStatusCondition(cr.Status.Conditions, ssanginxv1.ConditionTypeProgressing)
+			g.Expect(cond).ShouldNot(BeNil())
+			g.Expect(cond.Status).Should(Equal(metav1.ConditionTrue))
+			g.Expect(cond.Reason).Should(Equal(ssanginxv1.ReasonCertificatePending))
+			g.Expect(cond.ObservedGeneration).Should(Equal(cr.GetGeneration()))
+		}, 5*time.Second).Should(Succeed())
+		err = kClient.Get(ctx, client.ObjectKeyFromObject(ing), ing)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(ing.Spec.TLS[0].Hosts).Should(Equal([]string{hostname}))
+
+		// cert-manager reissues the server certificate for the new host
+		svrCrt, svrKey, err = issuer.CreateSvrCrt(*cr, time.Hour)
+		Expect(err).ShouldNot(HaveOccurred())
+		writeSecret(constants.ServerSecretSuffix, constants.ServerCertificateSuffix, svrCrt, svrKey)
+
+		Eventually(func(g Gomega) {
+			err := kClient.Get(ctx, client.ObjectKeyFromObject(cr), cr)
+			g.Expect(err).ShouldNot(HaveOccurred())
+			cond := meta.FindStatusCondition(cr.Status.Conditions, ssanginxv1.ConditionTypeProgressing)
+			g.Expect(cond).ShouldNot(BeNil())
+			g.Expect(cond.Reason).ShouldNot(Equal(ssanginxv1.ReasonCertificatePending))
+			err = kClient.Get(ctx, client.ObjectKeyFromObject(ing), ing)
+			g.Expect(err).ShouldNot(HaveOccurred())
+			g.Expect(ing.Spec.Rules[0].Host).Should(Equal("changed.example.com"))
+			g.Expect(ing.Spec.TLS[0].Hosts).Should(Equal([]string{"changed.example.com"}))
+		}, 10*time.Second).Should(Succeed())
+
+		// The CA and the client certificate are kept
+		for _, secret := range []*corev1.Secret{caSecret, clientSecret} {
+			current := &corev1.Secret{}
+			err := kClient.Get(ctx, client.ObjectKeyFromObject(secret), current)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(current.Data).Should(Equal(secret.Data), secret.Name)
+		}
+	})
 })
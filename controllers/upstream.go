@@ -0,0 +1,209 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"text/template"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	ssanginxv1 "github.com/jnytnai0613/ssa-nginx-controller/api/v1"
+	"github.com/jnytnai0613/ssa-nginx-controller/pkg/constants"
+)
+
+// Directives of the load balancing methods. Round robin is the default of nginx.
+var loadBalancingDirectives = map[ssanginxv1.LoadBalancingMethod]string{
+	ssanginxv1.LoadBalancingRoundRobin: "",
+	ssanginxv1.LoadBalancingLeastConn:  "least_conn",
+	ssanginxv1.LoadBalancingIPHash:     "ip_hash",
+	ssanginxv1.LoadBalancingRandom:     "random",
+}
+
+// upstreamData is an upstream with the addresses of its Services
+type upstreamData struct {
+	ssanginxv1.Upstream
+	Servers []upstreamServerData
+}
+
+type upstreamServerData struct {
+	ssanginxv1.UpstreamServer
+	// Service and port as written in the spec, rendered as a comment
+	Ref     string
+	Address string
+}
+
+var upstreamsConfTemplate = template.Must(template.New(constants.UpstreamsConfKey).Funcs(template.FuncMap{
+	"duration": nginxDuration,
+	"method":   func(m ssanginxv1.LoadBalancingMethod) string { return loadBalancingDirectives[m] },
+}).Parse(`# Generated from spec.upstreams by ssa-nginx-controller. Do not edit.
+{{- range . }}
+
+upstream {{ .Name }} {
+{{- with method .Method }}
+    {{ . }};
+{{- end }}
+{{- range .Servers }}
+    # {{ .Ref }}
+    server {{ .Address }}
+{{- with .Weight }} weight={{ . }}{{ end }}
+{{- with .MaxFails }} max_fails={{ . }}{{ end }}
+{{- with .FailTimeout }} fail_timeout={{ duration . }}{{ end }}
+{{- if .Backup }} backup{{ end }};
+{{- end }}
+{{- with .Keepalive }}
+    keepalive {{ . }};
+{{- end }}
+}
+{{- end }}
+`))
+
+// Format a duration as an nginx time in seconds, or in milliseconds if it is not a whole second
+func nginxDuration(d *metav1.Duration) string {
+	if d.Duration%time.Second == 0 {
+		return fmt.Sprintf("%ds", d.Duration/time.Second)
+	}
+
+	return fmt.Sprintf("%dms", d.Duration/time.Millisecond)
+}
+
+// Resolve the address of a server of an upstream from its Service.
+// The ClusterIP is used, so that nginx does not depend on DNS when it starts.
+// Headless and ExternalName Services are rendered with their DNS names, resolved by nginx on reload.
+func upstreamAddress(service corev1.Service, port intstr.IntOrString) (string, error) {
+	var number int32
+
+	for _, p := range service.Spec.Ports {
+		if (port.Type == intstr.String && p.Name == port.StrVal) || (port.Type == intstr.Int && p.Port == port.IntVal) {
+			number = p.Port
+			break
+		}
+	}
+
+	switch {
+	case service.Spec.Type == corev1.ServiceTypeExternalName:
+		// An ExternalName Service has no port, so the number is used as it is
+		if port.Type == intstr.String {
+			return "", fmt.Errorf("Service %q of type ExternalName has no port %q", service.GetName(), port.StrVal)
+		}
+		return net.JoinHostPort(service.Spec.ExternalName, port.String()), nil
+	case number == 0:
+		return "", fmt.Errorf("Service %q has no port %s", service.GetName(), port.String())
+	case service.Spec.ClusterIP == "" || service.Spec.ClusterIP == corev1.ClusterIPNone:
+		host := fmt.Sprintf("%s.%s.svc", service.GetName(), service.GetNamespace())
+		return net.JoinHostPort(host, fmt.Sprint(number)), nil
+	}
+
+	return net.JoinHostPort(service.Spec.ClusterIP, fmt.Sprint(number)), nil
+}
+
+// pendingServiceError is returned while a Service of spec.upstreams does not exist.
+// The ConfigMap is kept until the Service is created, and the reconcile is requeued.
+type pendingServiceError struct {
+	name string
+}
+
+func (e *pendingServiceError) Error() string {
+	return fmt.Sprintf("waiting for the upstream Service %q", e.name)
+}
+
+// Render upstreams.conf from spec.upstreams.
+// A missing Service may be created later, so it is not reported as an invalid configuration.
+func (r *SSANginxReconciler) renderUpstreamsConf(ctx context.Context, ssanginx ssanginxv1.SSANginx) (string, error) {
+	var (
+		buf       bytes.Buffer
+		upstreams []upstreamData
+	)
+
+	for _, upstream := range ssanginx.Spec.Upstreams {
+		data := upstreamData{Upstream: upstream}
+		for _, server := range upstream.Servers {
+			var service corev1.Service
+			if err := r.Client.Get(ctx, client.ObjectKey{Namespace: ssanginx.GetNamespace(), Name: server.ServiceName}, &service); err != nil {
+				if errors.IsNotFound(err) {
+					return "", &pendingServiceError{name: server.ServiceName}
+				}
+				return "", err
+			}
+
+			address, err := upstreamAddress(service, server.Port)
+			if err != nil {
+				return "", &invalidConfigError{key: constants.UpstreamsConfKey, err: err}
+			}
+			data.Servers = append(data.Servers, upstreamServerData{
+				UpstreamServer: server,
+				Ref:            fmt.Sprintf("%s:%s", server.ServiceName, server.Port.String()),
+				Address:        address,
+			})
+		}
+		upstreams = append(upstreams, data)
+	}
+
+	if err := upstreamsConfTemplate.Execute(&buf, upstreams); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// Find the upstream a location proxies to, matched by the host of proxyPass
+func proxiedUpstream(location ssanginxv1.NginxLocation, upstreams []ssanginxv1.Upstream) *ssanginxv1.Upstream {
+	for i := range upstreams {
+		for _, scheme := range []string{"http://", "https://"} {
+			host := scheme + upstreams[i].Name
+			if location.ProxyPass == host || strings.HasPrefix(location.ProxyPass, host+"/") {
+				return &upstreams[i]
+			}
+		}
+	}
+
+	return nil
+}
+
+// Map a Service to the SSANginxes referring to it in spec.upstreams, so that the
+// configuration is refreshed when the Service is created, changed or deleted.
+func (r *SSANginxReconciler) ssanginxesForService(obj client.Object) []reconcile.Request {
+	var (
+		ssanginxList ssanginxv1.SSANginxList
+		requests     []reconcile.Request
+	)
+
+	if err := r.Client.List(context.Background(), &ssanginxList,
+		client.InNamespace(obj.GetNamespace()),
+		client.MatchingFields{constants.IndexUpstreamServiceKey: obj.GetName()}); err != nil {
+		r.Log.Error(err, "unable to list SSANginx referring to Service", "service", obj.GetName())
+		return nil
+	}
+
+	for _, ssanginx := range ssanginxList.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: client.ObjectKeyFromObject(&ssanginx),
+		})
+	}
+
+	return requests
+}
@@ -17,9 +17,11 @@ limitations under the License.
 package main
 
 import (
+	"errors"
 	"flag"
 	"log"
 	"os"
+	"strings"
 	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
@@ -32,6 +34,7 @@ import (
 	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
@@ -69,11 +72,18 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var watchNamespaces string
+	var reloaderImage string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&watchNamespaces, "watch-namespaces", "",
+		"Comma separated list of namespaces to watch for SSANginx resources. "+
+			"If empty, all namespaces are watched.")
+	flag.StringVar(&reloaderImage, "reloader-image", "",
+		"The image containing the reloader binary that is copied into the nginx pods. Required.")
 	opts := zap.Options{
 		Development: true,
 		TimeEncoder: zapcore.ISO8601TimeEncoder,
@@ -82,9 +92,13 @@ func main() {
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+	if reloaderImage == "" {
+		setupLog.Error(errors.New("--reloader-image is required"), "invalid flags")
+		os.Exit(1)
+	}
 	var resyncPeriod = time.Second * 30
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+	options := ctrl.Options{
 		Scheme:                 scheme,
 		SyncPeriod:             &resyncPeriod,
 		MetricsBindAddress:     metricsAddr,
@@ -103,18 +117,41 @@ func main() {
 		// if you are doing or is intended to do any operation such as perform cleanups
 		// after the manager stops then its usage might be unsafe.
 		// LeaderElectionReleaseOnCancel: true,
-	})
+	}
+
+	// Restrict the cache to the specified namespaces.
+	// The children of SSANginx are always created in the namespace of the CR,
+	// so they are also covered by the same cache.
+	var namespaces []string
+	for _, ns := range strings.Split(watchNamespaces, ",") {
+		if ns = strings.TrimSpace(ns); ns != "" {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	switch len(namespaces) {
+	case 0:
+	case 1:
+		options.Namespace = namespaces[0]
+	default:
+		options.NewCache = cache.MultiNamespacedCacheBuilder(namespaces)
+	}
+	if len(namespaces) > 0 {
+		setupLog.Info("watching namespaces", "namespaces", namespaces)
+	}
+
+	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), options)
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
 	if err = (&controllers.SSANginxReconciler{
-		Client:    mgr.GetClient(),
-		Clientset: kclientset,
-		Log:       ctrl.Log.WithName("controllers").WithName("NGINX"),
-		Recorder:  mgr.GetEventRecorderFor("nginx-controller"),
-		Scheme:    mgr.GetScheme(),
+		Client:        mgr.GetClient(),
+		Clientset:     kclientset,
+		Log:           ctrl.Log.WithName("controllers").WithName("NGINX"),
+		Recorder:      mgr.GetEventRecorderFor("nginx-controller"),
+		Scheme:        mgr.GetScheme(),
+		ReloaderImage: reloaderImage,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "nginx-controller")
 		os.Exit(1)
@@ -1,48 +1,69 @@
 package constants
 
+import "time"
+
 // CR metadata
 const (
 	CrKind       = "SSANginx"
 	FieldManager = "ssanginx-fieldmanager"
 	Namespace    = "ssa-nginx-controller-system"
+	Finalizer    = "ssanginx.jnytnai0613.github.io/finalizer"
 )
 
 // The field corresponding to the index specified in FieldIndexer.
 // There is no problem even if the path is different from the actual index path.
+// The index only holds the owner name, so lookups must always be combined with
+// client.InNamespace to avoid matching a CR with the same name in another namespace.
 const (
 	IndexOwnerKey = ".metadata.ownerReference.name"
+	// The names of the Services referred to by spec.upstreams of SSANginx
+	IndexUpstreamServiceKey = ".spec.upstreams.servers.serviceName"
+	// The names of the Secrets provided by the user in spec.tls of SSANginx
+	IndexTLSSecretKey = ".spec.tls.secretName"
+)
+
+// Labels given to the resources owned by SSANginx
+// - https://kubernetes.io/docs/concepts/overview/working-with-objects/common-labels/
+const (
+	LabelName          = "app.kubernetes.io/name"
+	LabelInstance      = "app.kubernetes.io/instance"
+	LabelManagedBy     = "app.kubernetes.io/managed-by"
+	LabelNameValue     = "nginx"
+	LabelManagedByName = "ssa-nginx-controller"
 )
 
 // Container info
+// The init container copies the reloader binary into the emptyDir volume,
+// and the reloader sidecar runs it with the image of the nginx container,
+// so that "nginx -t" validates the configuration with the same nginx.
+const (
+	InitConatainerName    = "init"
+	ReloaderContainerName = "reloader"
+	ReloaderBinaryPath    = "/reloader"
+	ReloaderMetricsPort   = 9091
+	ReloaderMetricsName   = "reloader-metrics"
+)
+
+// Annotation of the pod template holding the SHA-256 checksum of the ConfigMap with the RollingRestart reload strategy
+const (
+	AnnotationConfigChecksum = "ssanginx.jnytnai0613.github.io/config-checksum"
+)
+
+// Content source
+// The content init container populates the emptyDir volume mounted as the html root.
 const (
-	InitConatainerName  = "init"
-	InitConatainerImage = "alpine"
-	CompareImageName    = "nginx"
+	ContentContainerName    = "content"
+	ContentArchiveMountPath = "/opt/ssanginx-archive/"
+	ContentMountPath        = "/opt/ssanginx-content/"
+	DefaultGitImage         = "alpine/git:latest"
 )
 
-// container command
+// Restricted security profile
+// The pods run as the nginx user of the nginx and nginx-unprivileged images.
+// nginx cannot bind privileged ports without NET_BIND_SERVICE, so it listens on UnprivilegedPort.
 const (
-	InitCommand = `cat << EOT > /tmp/run-nginx.sh
-apt-get update
-apt-get install inotify-tools -y
-nginx
-EOT
-chmod 500 /tmp/run-nginx.sh
-cat << EOT > /tmp/auto-reload-nginx.sh
-oldcksum=\` + "`" + `cksum /etc/nginx/conf.d/default.conf\` + "`" + `
-inotifywait -e modify,move,create,delete -mr --timefmt '%Y/%m/%d %H:%M:%S' --format '%T' /etc/nginx/conf.d/ | \
-while read date time; do
-  newcksum=\` + "`" + `cksum /etc/nginx/conf.d/default.conf\` + "`" + `
-  if [ "\${newcksum}" != "\${oldcksum}" ]; then
-    echo "At \${time} on \${date}, config file update detected."
-    oldcksum=\${newcksum}
-    service nginx restart
-  fi
-done
-EOT
-chmod 500 /tmp/auto-reload-nginx.sh
-`
-	ContainerCommand = `/tmp/run-nginx.sh && /tmp/auto-reload-nginx.sh`
+	RestrictedUserID = 101
+	UnprivilegedPort = 8080
 )
 
 // volume names
@@ -50,27 +71,104 @@ const (
 	ConfVolumeName     = "conf"
 	EmptyDirVolumeName = "nginx-reload"
 	IndexVolumeName    = "index"
+	ContentVolumeName  = "content"
+	ArchiveVolumeName  = "content-archive"
+	// Writable directories of the read-only root filesystem with the Restricted security profile
+	CacheVolumeName = "nginx-cache"
+	RunVolumeName   = "nginx-run"
+	TmpVolumeName   = "tmp"
 )
 
-// configmap volume key
+// configmap keys of the files rendered by the controller
 const (
-	ConfVolumeKeyPath = "default.conf"
+	DefaultConfKey   = "default.conf"
+	UpstreamsConfKey = "upstreams.conf"
 )
 
 // volume mountpath
 const (
 	ConfVolumeMountPath     = "/etc/nginx/conf.d/"
-	EmptyDirVolumeMountPath = "/tmp/"
+	EmptyDirVolumeMountPath = "/opt/ssanginx/"
 	IndexVolumeMountPath    = "/usr/share/nginx/html/"
+	CacheVolumeMountPath    = "/var/cache/nginx/"
+	RunVolumeMountPath      = "/var/run/"
+	TmpVolumeMountPath      = "/tmp/"
 )
 
 // Secret Info
+// The CA keypair Secret is named "<SSANginx name>-" + CASecretSuffix.
+// The server certificate Secret is named "<SSANginx name>-" + ServerSecretSuffix.
+// The client certificate Secret is named "<SSANginx name>-" + ClientSecretSuffix,
+// or "<SSANginx name>-" + ClientSecretSuffix + "-<client name>" for spec.clientCertificates.
+const (
+	CASecretSuffix     = "ca-keypair"
+	ServerSecretSuffix = "server-tls"
+	ClientSecretSuffix = "client"
+	// Client certificate Secret shared by every SSANginx in older versions
+	LegacyClientSecretName = "cli-secret"
+	// Client issued when spec.clientCertificates is empty
+	DefaultClientName = "client"
+	// Label holding the client name on the Secrets of client certificates
+	LabelClientCertificate = "ssanginx.jnytnai0613.github.io/client-certificate"
+)
+
+// cert-manager resources are named "<SSANginx name>-" + suffix.
+const (
+	CertManagerGroup        = "cert-manager.io"
+	CertManagerVersion      = "v1"
+	SelfSignedIssuerSuffix  = "selfsigned"
+	CAIssuerSuffix          = "ca"
+	CACertificateSuffix     = "ca"
+	ServerCertificateSuffix = "server"
+	ClientCertificateSuffix = "client"
+	// Annotation given by cert-manager to the Secrets it manages
+	CertManagerCertificateNameAnnotation = "cert-manager.io/certificate-name"
+)
+
+// Certificate defaults
+const (
+	DefaultCADuration          = 10 * 365 * 24 * time.Hour
+	DefaultCertificateDuration = 365 * 24 * time.Hour
+	DefaultRenewBefore         = 30 * 24 * time.Hour
+	// Delay of requeue when a certificate should already have been renewed
+	RenewRetryInterval = 10 * time.Second
+	// The CRL is valid for CRLDuration and reissued within CRLRenewBefore of its nextUpdate.
+	CRLDuration    = 7 * 24 * time.Hour
+	CRLRenewBefore = 24 * time.Hour
+	// Interval to check whether cert-manager has issued the server certificate for new Ingress hosts
+	CertificatePendingInterval = 5 * time.Second
+	// Interval to check whether the Services of spec.upstreams have been created
+	UpstreamPendingInterval = 5 * time.Second
+)
+
+// Gateway API
 const (
-	IngressSecretName = "ca-secret"
-	ClientSecretName  = "cli-secret"
+	GatewayAPIGroup   = "gateway.networking.k8s.io"
+	GatewayAPIVersion = "v1beta1"
+	// Listener port of the Gateway created by the controller
+	GatewayHTTPPort = 80
 )
 
 // Ingress Info
 const (
+	// IngressClass used when spec.ingressClassName is not specified
 	IngressClassName = "nginx"
+	// IngressClass names that select a provider when no IngressClass object is found
+	HAProxyIngressClassName = "haproxy"
+	// spec.controller of the IngressClass of each supported ingress controller
+	IngressNginxController   = "k8s.io/ingress-nginx"
+	HAProxyIngressController = "haproxy-ingress.github.io/controller"
+	// Annotations of ingress-nginx set by the controller
+	AnnotationRewriteTarget             = "nginx.ingress.kubernetes.io/rewrite-target"
+	AnnotationAuthTLSSecret             = "nginx.ingress.kubernetes.io/auth-tls-secret"
+	AnnotationAuthTLSVerifyClient       = "nginx.ingress.kubernetes.io/auth-tls-verify-client"
+	AnnotationAuthTLSVerifyDepth        = "nginx.ingress.kubernetes.io/auth-tls-verify-depth"
+	AnnotationAuthTLSPassCertToUpstream = "nginx.ingress.kubernetes.io/auth-tls-pass-certificate-to-upstream"
+	AnnotationAuthTLSErrorPage          = "nginx.ingress.kubernetes.io/auth-tls-error-page"
+	// Annotations of HAProxy Ingress set by the controller
+	AnnotationHAProxyRewriteTarget       = "haproxy-ingress.github.io/rewrite-target"
+	AnnotationHAProxyAuthTLSSecret       = "haproxy-ingress.github.io/auth-tls-secret"
+	AnnotationHAProxyAuthTLSVerifyClient = "haproxy-ingress.github.io/auth-tls-verify-client"
+	AnnotationHAProxyAuthTLSCertHeader   = "haproxy-ingress.github.io/auth-tls-cert-header"
+	AnnotationHAProxyAuthTLSErrorPage    = "haproxy-ingress.github.io/auth-tls-error-page"
 )
@@ -0,0 +1,74 @@
+package imageref
+
+import (
+	"path"
+	"strings"
+)
+
+// DefaultRegistry is the registry of image references without a registry host
+const DefaultRegistry = "docker.io"
+
+// Reference is a parsed container image reference such as "registry:5000/team/nginx:1.23@sha256:..."
+type Reference struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// Parse splits an image reference into its registry, repository, tag and digest, following the rules of Docker.
+// The first component is a registry host if it contains "." or ":", or is "localhost".
+// Official images of Docker Hub are in the "library" namespace.
+func Parse(image string) Reference {
+	var ref Reference
+
+	if i := strings.Index(image, "@"); i >= 0 {
+		image, ref.Digest = image[:i], image[i+1:]
+	}
+
+	// A ":" after the last "/" separates the tag. Otherwise it is the port of the registry.
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image, ref.Tag = image[:i], image[i+1:]
+	}
+
+	ref.Registry = DefaultRegistry
+	if i := strings.Index(image, "/"); i >= 0 {
+		host := image[:i]
+		if strings.ContainsAny(host, ".:") || host == "localhost" {
+			ref.Registry, image = host, image[i+1:]
+		}
+	}
+
+	if ref.Registry == DefaultRegistry && !strings.Contains(image, "/") {
+		image = "library/" + image
+	}
+	ref.Repository = image
+
+	return ref
+}
+
+// Name returns the last component of the repository, such as "nginx" of "bitnami/nginx".
+func (r Reference) Name() string {
+	return path.Base(r.Repository)
+}
+
+// UnprivilegedName is the nginx image listening on port 8080 as a non-root user.
+const UnprivilegedName = "nginx-unprivileged"
+
+// Names of the nginx images. Other images named "nginx-*", such as nginx-prometheus-exporter,
+// are often run next to nginx and are not nginx.
+var nginxNames = map[string]bool{
+	"nginx":          true,
+	UnprivilegedName: true,
+}
+
+// IsNginx reports whether the image is nginx or nginx-unprivileged in any registry or namespace,
+// such as bitnami/nginx and nginxinc/nginx-unprivileged.
+func IsNginx(image string) bool {
+	return nginxNames[Parse(image).Name()]
+}
+
+// IsUnprivileged reports whether the image is nginx-unprivileged in any registry or namespace.
+func IsUnprivileged(image string) bool {
+	return Parse(image).Name() == UnprivilegedName
+}
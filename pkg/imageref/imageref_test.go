@@ -0,0 +1,99 @@
+package imageref
+
+import (
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	const digest = "sha256:0d17b565c37bcbd895e9d92315a05c1c3c9a29f762b011a10c54a66cd53c9b31"
+
+	tests := []struct {
+		name  string
+		image string
+		want  Reference
+	}{
+		{
+			name:  "official image",
+			image: "nginx",
+			want:  Reference{Registry: DefaultRegistry, Repository: "library/nginx"},
+		},
+		{
+			name:  "official image with tag",
+			image: "nginx:1.23",
+			want:  Reference{Registry: DefaultRegistry, Repository: "library/nginx", Tag: "1.23"},
+		},
+		{
+			name:  "namespace of Docker Hub",
+			image: "nginxinc/nginx-unprivileged:1.23-alpine",
+			want:  Reference{Registry: DefaultRegistry, Repository: "nginxinc/nginx-unprivileged", Tag: "1.23-alpine"},
+		},
+		{
+			name:  "explicit Docker Hub",
+			image: "docker.io/nginx:latest",
+			want:  Reference{Registry: DefaultRegistry, Repository: "library/nginx", Tag: "latest"},
+		},
+		{
+			name:  "registry with domain",
+			image: "ghcr.io/team/web/nginx:1.23",
+			want:  Reference{Registry: "ghcr.io", Repository: "team/web/nginx", Tag: "1.23"},
+		},
+		{
+			name:  "registry with port",
+			image: "registry.local:5000/team/nginx:1.23",
+			want:  Reference{Registry: "registry.local:5000", Repository: "team/nginx", Tag: "1.23"},
+		},
+		{
+			name:  "registry with port without tag",
+			image: "registry.local:5000/nginx",
+			want:  Reference{Registry: "registry.local:5000", Repository: "nginx"},
+		},
+		{
+			name:  "localhost",
+			image: "localhost/nginx:dev",
+			want:  Reference{Registry: "localhost", Repository: "nginx", Tag: "dev"},
+		},
+		{
+			name:  "digest",
+			image: "nginx@" + digest,
+			want:  Reference{Registry: DefaultRegistry, Repository: "library/nginx", Digest: digest},
+		},
+		{
+			name:  "tag and digest",
+			image: "registry.local:5000/team/nginx:1.23@" + digest,
+			want:  Reference{Registry: "registry.local:5000", Repository: "team/nginx", Tag: "1.23", Digest: digest},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Parse(tt.image); got != tt.want {
+				t.Errorf("Parse(%q) = %+v, want %+v", tt.image, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsNginx(t *testing.T) {
+	tests := []struct {
+		image        string
+		nginx        bool
+		unprivileged bool
+	}{
+		{image: "nginx:1.23", nginx: true},
+		{image: "bitnami/nginx", nginx: true},
+		{image: "registry.local:5000/nginxinc/nginx-unprivileged:1.23", nginx: true, unprivileged: true},
+		{image: "nginx/nginx-prometheus-exporter:0.11"},
+		{image: "registry.local:5000/httpd"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			if got := IsNginx(tt.image); got != tt.nginx {
+				t.Errorf("IsNginx(%q) = %v, want %v", tt.image, got, tt.nginx)
+			}
+			if got := IsUnprivileged(tt.image); got != tt.unprivileged {
+				t.Errorf("IsUnprivileged(%q) = %v, want %v", tt.image, got, tt.unprivileged)
+			}
+		})
+	}
+}
@@ -0,0 +1,391 @@
+package nginxconf
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SyntaxError reports an error in a configuration file in the format of "nginx -t".
+type SyntaxError struct {
+	File string
+	Line int
+	Msg  string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s in %s:%d", e.Msg, e.File, e.Line)
+}
+
+// Directives that must be followed by a block
+var blockDirectives = map[string]bool{
+	"events":        true,
+	"geo":           true,
+	"http":          true,
+	"if":            true,
+	"limit_except":  true,
+	"location":      true,
+	"map":           true,
+	"server":        true,
+	"split_clients": true,
+	"stream":        true,
+	"types":         true,
+	"upstream":      true,
+}
+
+// Context is the block in which directives are placed
+type Context string
+
+// Contexts in which a block is parsed
+const (
+	ContextHTTP     Context = "http"
+	ContextServer   Context = "server"
+	ContextLocation Context = "location"
+	contextUpstream Context = "upstream"
+	contextOther    Context = "other"
+)
+
+// Validate checks the syntax of a file included in the http context, such as the files in conf.d.
+// It finds unbalanced braces, unterminated directives and quotes, and blocks placed in a wrong context.
+// It does not know the arguments of each directive, so it does not replace "nginx -t".
+func Validate(file string, data string) error {
+	_, err := Directives(file, data, ContextHTTP)
+	return err
+}
+
+// Directives checks the syntax of a snippet placed in context like Validate,
+// and returns the names of its top-level directives.
+func Directives(file string, data string, context Context) ([]string, error) {
+	p := &parser{lexer: lexer{file: file, data: data, line: 1}}
+
+	if err := p.parseBlock(context, false); err != nil {
+		return nil, err
+	}
+
+	return p.directives, nil
+}
+
+// Listen is a listen directive of a server block
+type Listen struct {
+	Line    int
+	Address string
+}
+
+// Port returns the port of the address, which defaults to 80 like nginx.
+// ok is false for UNIX-domain sockets and addresses containing variables.
+func (l Listen) Port() (port int, ok bool) {
+	address := l.Address
+	if strings.HasPrefix(address, "unix:") || strings.Contains(address, "$") {
+		return 0, false
+	}
+
+	// Strip the host. The brackets of an IPv6 address contain ":".
+	if i := strings.LastIndex(address, "]"); i >= 0 {
+		address = strings.TrimPrefix(address[i+1:], ":")
+	} else if i := strings.LastIndex(address, ":"); i >= 0 {
+		address = address[i+1:]
+	}
+	if address == "" {
+		return 80, true
+	}
+
+	port, err := strconv.Atoi(address)
+	if err != nil {
+		// Only a host is given
+		return 80, true
+	}
+
+	return port, true
+}
+
+// Listens checks the syntax of a file included in the http context like Validate,
+// and returns the listen directives of its server blocks.
+func Listens(file string, data string) ([]Listen, error) {
+	p := &parser{lexer: lexer{file: file, data: data, line: 1}}
+
+	if err := p.parseBlock(ContextHTTP, false); err != nil {
+		return nil, err
+	}
+
+	return p.listens, nil
+}
+
+type parser struct {
+	lexer
+	// Names of the top-level directives
+	directives []string
+	// listen directives of the server blocks
+	listens []Listen
+}
+
+// Parse directives until the closing brace of the block, or the end of the file at the top level.
+func (p *parser) parseBlock(context Context, nested bool) error {
+	for {
+		words, term, err := p.readDirective()
+		if err != nil {
+			return err
+		}
+
+		switch term {
+		case tokenEOF:
+			if len(words) > 0 {
+				return p.errorf("unexpected end of file, expecting \";\" or \"}\"")
+			}
+			if nested {
+				return p.errorf("unexpected end of file, expecting \"}\"")
+			}
+			return nil
+		case tokenBlockEnd:
+			if len(words) > 0 || !nested {
+				return p.errorf("unexpected \"}\"")
+			}
+			return nil
+		case tokenSemicolon:
+			if len(words) == 0 {
+				return p.errorf("unexpected \";\"")
+			}
+			if !nested {
+				p.directives = append(p.directives, words[0])
+			}
+			if words[0] == "listen" && context == ContextServer && len(words) > 1 {
+				p.listens = append(p.listens, Listen{Line: p.line, Address: words[1]})
+			}
+			// server in upstream is a simple directive
+			if blockDirectives[words[0]] && !(words[0] == "server" && context == contextUpstream) {
+				return p.errorf("directive %q has no opening \"{\"", words[0])
+			}
+		case tokenBlockStart:
+			if len(words) == 0 {
+				return p.errorf("unexpected \"{\"")
+			}
+			if !nested {
+				p.directives = append(p.directives, words[0])
+			}
+			next, err := p.blockContext(words, context)
+			if err != nil {
+				return err
+			}
+			// The content of the *_by_lua_block directives is Lua code
+			if strings.HasSuffix(words[0], "_by_lua_block") {
+				if err := p.skipBlock(); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := p.parseBlock(next, true); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Check that the block directive is allowed in context, and return the context of its content
+func (p *parser) blockContext(words []string, context Context) (Context, error) {
+	name := words[0]
+
+	switch name {
+	case "http", "events", "stream", "mail":
+		return "", p.errorf("%q directive is not allowed here", name)
+	case "server":
+		if context != ContextHTTP {
+			return "", p.errorf("%q directive is not allowed here", name)
+		}
+		return ContextServer, nil
+	case "location":
+		if context != ContextServer && context != ContextLocation {
+			return "", p.errorf("%q directive is not allowed here", name)
+		}
+		if len(words) < 2 {
+			return "", p.errorf("invalid number of arguments in %q directive", name)
+		}
+		return ContextLocation, nil
+	case "upstream":
+		if context != ContextHTTP {
+			return "", p.errorf("%q directive is not allowed here", name)
+		}
+		if len(words) != 2 {
+			return "", p.errorf("invalid number of arguments in %q directive", name)
+		}
+		return contextUpstream, nil
+	case "if":
+		if len(words) < 2 {
+			return "", p.errorf("invalid number of arguments in %q directive", name)
+		}
+		return context, nil
+	}
+
+	return contextOther, nil
+}
+
+// Read the words of a directive and the token terminating it
+func (p *parser) readDirective() ([]string, token, error) {
+	var words []string
+
+	for {
+		tok, word, err := p.next()
+		if err != nil {
+			return nil, 0, err
+		}
+		if tok != tokenWord {
+			return words, tok, nil
+		}
+		words = append(words, word)
+	}
+}
+
+// Skip a block of Lua code, counting the braces outside of strings and comments
+func (p *parser) skipBlock() error {
+	depth := 1
+	for ; p.pos < len(p.data); p.pos++ {
+		switch ch := p.data[p.pos]; ch {
+		case '\n':
+			p.line++
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				p.pos++
+				return nil
+			}
+		case '"', '\'':
+			for p.pos++; p.pos < len(p.data) && p.data[p.pos] != ch; p.pos++ {
+				if p.data[p.pos] == '\\' {
+					p.pos++
+				}
+			}
+		case '-':
+			if strings.HasPrefix(p.data[p.pos:], "--") {
+				for p.pos < len(p.data) && p.data[p.pos] != '\n' {
+					p.pos++
+				}
+				p.line++
+			}
+		}
+	}
+
+	return p.errorf("unexpected end of file, expecting \"}\"")
+}
+
+type token int
+
+const (
+	tokenWord token = iota
+	tokenSemicolon
+	tokenBlockStart
+	tokenBlockEnd
+	tokenEOF
+)
+
+// lexer splits the configuration into tokens like ngx_conf_read_token of nginx
+type lexer struct {
+	file string
+	data string
+	pos  int
+	line int
+}
+
+func (l *lexer) errorf(format string, args ...interface{}) error {
+	return &SyntaxError{File: l.file, Line: l.line, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (l *lexer) next() (token, string, error) {
+	// Skip spaces and comments
+	for l.pos < len(l.data) {
+		ch := l.data[l.pos]
+		if ch == '#' {
+			for l.pos < len(l.data) && l.data[l.pos] != '\n' {
+				l.pos++
+			}
+			continue
+		}
+		if !isSpace(ch) {
+			break
+		}
+		if ch == '\n' {
+			l.line++
+		}
+		l.pos++
+	}
+
+	if l.pos >= len(l.data) {
+		return tokenEOF, "", nil
+	}
+
+	switch ch := l.data[l.pos]; ch {
+	case ';':
+		l.pos++
+		return tokenSemicolon, "", nil
+	case '{':
+		l.pos++
+		return tokenBlockStart, "", nil
+	case '}':
+		l.pos++
+		return tokenBlockEnd, "", nil
+	case '"', '\'':
+		return l.quoted(ch)
+	}
+
+	return l.word()
+}
+
+func (l *lexer) quoted(quote byte) (token, string, error) {
+	var b strings.Builder
+
+	line := l.line
+	for l.pos++; l.pos < len(l.data); l.pos++ {
+		ch := l.data[l.pos]
+		switch {
+		case ch == '\\' && l.pos+1 < len(l.data):
+			l.pos++
+			b.WriteByte(l.data[l.pos])
+			if l.data[l.pos] == '\n' {
+				l.line++
+			}
+		case ch == quote:
+			l.pos++
+			return tokenWord, b.String(), nil
+		default:
+			if ch == '\n' {
+				l.line++
+			}
+			b.WriteByte(ch)
+		}
+	}
+
+	l.line = line
+	return 0, "", l.errorf("unexpected end of file, expecting closing quote")
+}
+
+func (l *lexer) word() (token, string, error) {
+	start := l.pos
+	variable := false
+
+	for ; l.pos < len(l.data); l.pos++ {
+		ch := l.data[l.pos]
+		// "${name}" is a part of the word
+		if ch == '{' && variable {
+			for l.pos < len(l.data) && l.data[l.pos] != '}' {
+				l.pos++
+			}
+			if l.pos >= len(l.data) {
+				return 0, "", l.errorf("unexpected end of file, expecting \"}\"")
+			}
+			variable = false
+			continue
+		}
+		if isSpace(ch) || ch == ';' || ch == '{' || ch == '}' {
+			break
+		}
+		if ch == '\\' && l.pos+1 < len(l.data) {
+			l.pos++
+		}
+		variable = ch == '$'
+	}
+
+	return tokenWord, l.data[start:l.pos], nil
+}
+
+func isSpace(ch byte) bool {
+	return ch == ' ' || ch == '\t' || ch == '\r' || ch == '\n'
+}
@@ -0,0 +1,215 @@
+package nginxconf
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		// Line and message of the SyntaxError, or 0 if the configuration is valid
+		line int
+		msg  string
+	}{
+		{
+			name: "valid server",
+			data: "server {\n    listen 80;\n    location / {\n        root /usr/share/nginx/html;\n    }\n}\n",
+		},
+		{
+			name: "comments and quoted braces",
+			data: "# server {\nserver {\n    return 200 \"}{;\"; # }\n}\n",
+		},
+		{
+			name: "variable with braces",
+			data: "server {\n    location / {\n        return 200 ${scheme}://${host};\n    }\n}\n",
+		},
+		{
+			name: "unterminated variable",
+			data: "server {\n    return 200 ${host",
+			line: 2,
+			msg:  "unexpected end of file, expecting \"}\"",
+		},
+		{
+			name: "server in upstream",
+			data: "upstream backend {\n    server 10.0.0.1:80;\n    server 10.0.0.2:80 backup;\n}\n",
+		},
+		{
+			name: "missing closing brace",
+			data: "server {\n    listen 80;\n",
+			line: 3,
+			msg:  "unexpected end of file, expecting \"}\"",
+		},
+		{
+			name: "extra closing brace",
+			data: "server {\n    listen 80;\n}\n}\n",
+			line: 4,
+			msg:  "unexpected \"}\"",
+		},
+		{
+			name: "missing semicolon",
+			data: "server {\n    listen 80\n}\n",
+			line: 3,
+			msg:  "unexpected \"}\"",
+		},
+		{
+			name: "unterminated directive at the end of file",
+			data: "server_tokens off",
+			line: 1,
+			msg:  "unexpected end of file, expecting \";\" or \"}\"",
+		},
+		{
+			name: "unterminated quote",
+			data: "server {\n    return 200 \"hello;\n}\n",
+			line: 2,
+			msg:  "unexpected end of file, expecting closing quote",
+		},
+		{
+			name: "block directive without a block",
+			data: "server;\n",
+			line: 1,
+			msg:  "directive \"server\" has no opening \"{\"",
+		},
+		{
+			name: "server block in upstream",
+			data: "upstream backend {\n    server {\n    }\n}\n",
+			line: 2,
+			msg:  "\"server\" directive is not allowed here",
+		},
+		{
+			name: "location at the http level",
+			data: "location / {\n}\n",
+			line: 1,
+			msg:  "\"location\" directive is not allowed here",
+		},
+		{
+			name: "http in conf.d",
+			data: "\n\nhttp {\n}\n",
+			line: 3,
+			msg:  "\"http\" directive is not allowed here",
+		},
+		{
+			name: "location without arguments",
+			data: "server {\n    location {\n    }\n}\n",
+			line: 2,
+			msg:  "invalid number of arguments in \"location\" directive",
+		},
+		{
+			name: "lua block with braces in strings and comments",
+			data: "server {\n    location / {\n        content_by_lua_block {\n" +
+				"            -- a comment with }\n" +
+				"            ngx.say(\"}\")\n" +
+				"            ngx.say('{')\n" +
+				"            if true then local t = {} end\n" +
+				"        }\n    }\n}\n",
+		},
+		{
+			name: "error after a lua block",
+			data: "server {\n    access_by_lua_block {\n        -- }\n        ngx.exit(200)\n    }\n    listen 80\n}\n",
+			line: 7,
+			msg:  "unexpected \"}\"",
+		},
+		{
+			name: "unterminated lua block",
+			data: "server {\n    content_by_lua_block {\n        ngx.say(\"}\")\n",
+			line: 4,
+			msg:  "unexpected end of file, expecting \"}\"",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate("default.conf", tt.data)
+			if tt.line == 0 {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("Validate() = %v, want a SyntaxError", err)
+			}
+			if syntaxErr.File != "default.conf" || syntaxErr.Line != tt.line || syntaxErr.Msg != tt.msg {
+				t.Errorf("Validate() = %q, want %q in default.conf:%d", syntaxErr, tt.msg, tt.line)
+			}
+		})
+	}
+}
+
+func TestDirectives(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		context Context
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:    "top-level directives of a server snippet",
+			data:    "listen 8080;\nlocation / {\n    root /srv;\n}\n",
+			context: ContextServer,
+			want:    []string{"listen", "location"},
+		},
+		{
+			name:    "location in a location snippet",
+			data:    "location /api {\n    proxy_pass http://backend;\n}\n",
+			context: ContextLocation,
+			want:    []string{"location"},
+		},
+		{
+			name:    "server in a server snippet",
+			data:    "server {\n}\n",
+			context: ContextServer,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Directives("snippet", tt.data, tt.context)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Directives() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Directives() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListens(t *testing.T) {
+	data := "server {\n    listen 80;\n    listen [::]:8080 ipv6only=on;\n}\n" +
+		"server {\n    listen 127.0.0.1:8443 ssl;\n    listen localhost;\n    listen unix:/var/run/nginx.sock;\n}\n" +
+		"upstream backend {\n    server 10.0.0.1:80;\n}\n"
+
+	listens, err := Listens("default.conf", data)
+	if err != nil {
+		t.Fatalf("Listens() error = %v", err)
+	}
+
+	want := []struct {
+		line int
+		port int
+		ok   bool
+	}{
+		{2, 80, true},
+		{3, 8080, true},
+		{6, 8443, true},
+		{7, 80, true},
+		{8, 0, false},
+	}
+	if len(listens) != len(want) {
+		t.Fatalf("Listens() = %v, want %d listens", listens, len(want))
+	}
+	for i, w := range want {
+		port, ok := listens[i].Port()
+		if listens[i].Line != w.line || port != w.port || ok != w.ok {
+			t.Errorf("listen %q on line %d = (%d, %v), want line %d (%d, %v)",
+				listens[i].Address, listens[i].Line, port, ok, w.line, w.port, w.ok)
+		}
+	}
+}
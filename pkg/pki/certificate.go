@@ -1,47 +1,148 @@
 package pki
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha1"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/asn1"
 	"encoding/pem"
+	"errors"
+	"fmt"
 	"math/big"
+	"net"
 	"time"
 
 	ssanginxv1 "github.com/jnytnai0613/ssa-nginx-controller/api/v1"
 )
 
-var (
-	caTempl      = &x509.Certificate{}
-	privateCaKey *rsa.PrivateKey
-)
+// Issuer signs server and client certificates with the given CA.
+// It holds no package-level state, so it is safe to use from concurrent reconciles.
+type Issuer struct {
+	caCrt         []byte
+	caCertificate *x509.Certificate
+	caKey         crypto.Signer
+}
+
+// NewIssuer parses the PEM encoded CA certificate and private key created by CreateCaCrt.
+func NewIssuer(caCrt, caKey []byte) (*Issuer, error) {
+	caCertificate, err := parseCertificate(caCrt)
+	if err != nil {
+		return nil, err
+	}
+	if !caCertificate.IsCA {
+		return nil, errors.New("certificate is not a CA")
+	}
+
+	privateCaKey, err := parsePrivateKey(caKey)
+	if err != nil {
+		return nil, err
+	}
+	publicCaKey, ok := privateCaKey.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !publicCaKey.Equal(caCertificate.PublicKey) {
+		return nil, errors.New("CA private key does not match CA certificate")
+	}
+
+	return &Issuer{
+		caCrt:         caCrt,
+		caCertificate: caCertificate,
+		caKey:         privateCaKey,
+	}, nil
+}
+
+// CACrt returns the PEM encoded CA certificate.
+func (i *Issuer) CACrt() []byte {
+	return i.caCrt
+}
+
+// CANotAfter returns the expiry of the CA certificate.
+func (i *Issuer) CANotAfter() time.Time {
+	return i.caCertificate.NotAfter
+}
+
+// Issued reports whether the PEM encoded certificate was signed by the CA of the Issuer.
+func (i *Issuer) Issued(crt []byte) bool {
+	certificate, err := parseCertificate(crt)
+	if err != nil {
+		return false
+	}
+
+	return certificate.CheckSignatureFrom(i.caCertificate) == nil
+}
 
-func CreateCaCrt() ([]byte, []byte, error) {
-	var err error
+// NotAfter returns the expiry of the PEM encoded certificate.
+func NotAfter(crt []byte) (time.Time, error) {
+	certificate, err := parseCertificate(crt)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return certificate.NotAfter, nil
+}
 
-	privateCaKey, err = rsa.GenerateKey(rand.Reader, 2048)
+// SerialNumber returns the serial number of the PEM encoded certificate in hexadecimal.
+func SerialNumber(crt []byte) (string, error) {
+	certificate, err := parseCertificate(crt)
+	if err != nil {
+		return "", err
+	}
+
+	return certificate.SerialNumber.Text(16), nil
+}
+
+func parseCertificate(crt []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(crt)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("failed to decode certificate PEM")
+	}
+
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// The certificates signed by the CA must not outlive the CA.
+func (i *Issuer) notAfter(notBefore time.Time, validity time.Duration) time.Time {
+	notAfter := notBefore.Add(validity)
+	if notAfter.After(i.caCertificate.NotAfter) {
+		return i.caCertificate.NotAfter
+	}
+
+	return notAfter
+}
+
+func CreateCaCrt(ssanginx ssanginxv1.SSANginx, validity time.Duration) ([]byte, []byte, error) {
+	privateCaKey, caKey, err := generateKey(keyAlgorithm(ssanginx), keyEncoding(ssanginx))
 	if err != nil {
 		return nil, nil, err
 	}
 	publicCaKey := privateCaKey.Public()
 
+	serialNumber, err := newSerialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+	subjectKeyID, err := subjectKeyID(publicCaKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	//[RFC5280]
-	subjectCa := pkix.Name{
-		CommonName:         "ca",
-		OrganizationalUnit: []string{"Example Org Unit"},
-		Organization:       []string{"Example Org"},
-		Country:            []string{"JP"},
-	}
-
-	caTempl = &x509.Certificate{
-		SerialNumber:          big.NewInt(1),
-		Subject:               subjectCa,
-		NotAfter:              time.Date(2031, 12, 31, 0, 0, 0, 0, time.UTC),
-		NotBefore:             time.Now(),
+	notBefore := time.Now()
+	caTempl := &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               subject(ssanginx, "ca"),
+		NotAfter:              notBefore.Add(validity),
+		NotBefore:             notBefore,
 		IsCA:                  true,
 		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
 		BasicConstraintsValid: true,
+		SubjectKeyId:          subjectKeyID,
+		// Self-signed, so the CA identifies itself as the authority
+		AuthorityKeyId: subjectKeyID,
 	}
 
 	//Self Sign CA Certificate
@@ -53,39 +154,41 @@ func CreateCaCrt() ([]byte, []byte, error) {
 	//Convert to ASN.1 PEM encoded form
 	caCrt := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCertificate})
 
-	derCaPrivateKey := x509.MarshalPKCS1PrivateKey(privateCaKey)
-
-	caKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: derCaPrivateKey})
-
 	return caCrt, caKey, nil
 }
 
-func CreateSvrCrt(ssanginx ssanginxv1.SSANginx) ([]byte, []byte, error) {
-	privateSvrKey, err := rsa.GenerateKey(rand.Reader, 2048)
+func (i *Issuer) CreateSvrCrt(ssanginx ssanginxv1.SSANginx, validity time.Duration) ([]byte, []byte, error) {
+	privateSvrKey, svrKey, err := generateKey(keyAlgorithm(ssanginx), keyEncoding(ssanginx))
 	if err != nil {
 		return nil, nil, err
 	}
 	publicSvrKey := privateSvrKey.Public()
 
-	subjectSvr := pkix.Name{
-		CommonName:         "server",
-		OrganizationalUnit: []string{"Example Org Unit"},
-		Organization:       []string{"Example Org"},
-		Country:            []string{"JP"},
+	serialNumber, err := newSerialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+	subjectKeyID, err := subjectKeyID(publicSvrKey)
+	if err != nil {
+		return nil, nil, err
 	}
 
+	notBefore := time.Now()
 	svrTempl := &x509.Certificate{
-		SerialNumber: big.NewInt(123),
-		Subject:      subjectSvr,
-		NotAfter:     time.Date(2031, 12, 31, 0, 0, 0, 0, time.UTC),
-		NotBefore:    time.Now(),
-		KeyUsage:     x509.KeyUsageDigitalSignature,
-		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
-		DNSNames:     []string{*ssanginx.Spec.IngressSpec.Rules[0].Host},
+		SerialNumber:   serialNumber,
+		Subject:        subject(ssanginx, "server"),
+		NotAfter:       i.notAfter(notBefore, validity),
+		NotBefore:      notBefore,
+		KeyUsage:       x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:       DNSNames(ssanginx),
+		IPAddresses:    ipAddresses(ssanginx),
+		SubjectKeyId:   subjectKeyID,
+		AuthorityKeyId: i.caCertificate.SubjectKeyId,
 	}
 
 	//Server Certificate
-	derSvrCertificate, err := x509.CreateCertificate(rand.Reader, svrTempl, caTempl, publicSvrKey, privateCaKey)
+	derSvrCertificate, err := x509.CreateCertificate(rand.Reader, svrTempl, i.caCertificate, publicSvrKey, i.caKey)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -93,38 +196,41 @@ func CreateSvrCrt(ssanginx ssanginxv1.SSANginx) ([]byte, []byte, error) {
 	//Convert to ASN.1 PEM encoded form
 	svrCrt := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derSvrCertificate})
 
-	derPrivateSvrKey := x509.MarshalPKCS1PrivateKey(privateSvrKey)
-
-	svrKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: derPrivateSvrKey})
-
 	return svrCrt, svrKey, nil
 }
 
-func CreateClientCrt() ([]byte, []byte, error) {
-	privateClientKey, err := rsa.GenerateKey(rand.Reader, 2048)
+// CreateClientCrt issues the client certificate described by client.
+// The serial number is random, so that the certificate can be revoked individually.
+func (i *Issuer) CreateClientCrt(ssanginx ssanginxv1.SSANginx, client ssanginxv1.ClientCertificate, validity time.Duration) ([]byte, []byte, error) {
+	privateClientKey, cliKey, err := generateKey(clientKeyAlgorithm(ssanginx, client), keyEncoding(ssanginx))
 	if err != nil {
 		return nil, nil, err
 	}
 	publicClientKey := privateClientKey.Public()
 
-	subjectClient := pkix.Name{
-		CommonName:         "client",
-		OrganizationalUnit: []string{"Example Org Unit"},
-		Organization:       []string{"Example Org"},
-		Country:            []string{"JP"},
+	serialNumber, err := newSerialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+	subjectKeyID, err := subjectKeyID(publicClientKey)
+	if err != nil {
+		return nil, nil, err
 	}
 
+	notBefore := time.Now()
 	cliTempl := &x509.Certificate{
-		SerialNumber: big.NewInt(456),
-		Subject:      subjectClient,
-		NotAfter:     time.Date(2031, 12, 31, 0, 0, 0, 0, time.UTC),
-		NotBefore:    time.Now(),
-		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
-		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		SerialNumber:   serialNumber,
+		Subject:        clientSubject(ssanginx, client),
+		NotAfter:       i.notAfter(notBefore, validity),
+		NotBefore:      notBefore,
+		KeyUsage:       x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		SubjectKeyId:   subjectKeyID,
+		AuthorityKeyId: i.caCertificate.SubjectKeyId,
 	}
 
 	// Client Certificate
-	derClientCertificate, err := x509.CreateCertificate(rand.Reader, cliTempl, caTempl, publicClientKey, privateCaKey)
+	derClientCertificate, err := x509.CreateCertificate(rand.Reader, cliTempl, i.caCertificate, publicClientKey, i.caKey)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -132,9 +238,332 @@ func CreateClientCrt() ([]byte, []byte, error) {
 	// Convert to ASN.1 PEM encoded form
 	cliCrt := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derClientCertificate})
 
-	derClientPrivateKey := x509.MarshalPKCS1PrivateKey(privateClientKey)
+	return cliCrt, cliKey, nil
+}
 
-	cliKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: derClientPrivateKey})
+// ServerCrtMatches reports whether the PEM encoded server certificate still has the
+// subject and key algorithm configured in spec.pki and the SANs of the SSANginx.
+func ServerCrtMatches(crt []byte, ssanginx ssanginxv1.SSANginx) bool {
+	if !crtMatches(crt, subject(ssanginx, "server"), keyAlgorithm(ssanginx)) {
+		return false
+	}
 
-	return cliCrt, cliKey, nil
+	certificate, err := parseCertificate(crt)
+	if err != nil {
+		return false
+	}
+
+	var certIPs, specIPs []string
+	for _, ip := range certificate.IPAddresses {
+		certIPs = append(certIPs, ip.String())
+	}
+	for _, ip := range ipAddresses(ssanginx) {
+		specIPs = append(specIPs, ip.String())
+	}
+
+	return equalSet(certificate.DNSNames, DNSNames(ssanginx)) && equalSet(certIPs, specIPs)
+}
+
+// DNSNames returns the DNS SANs of the server certificate:
+// the hosts of all Ingress rules followed by spec.tls.dnsNames, without duplicates.
+func DNSNames(ssanginx ssanginxv1.SSANginx) []string {
+	var (
+		names []string
+		seen  = make(map[string]bool)
+	)
+
+	add := func(name string) {
+		if name == "" || seen[name] {
+			return
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+
+	if ssanginx.Spec.IngressSpec != nil {
+		for _, rule := range ssanginx.Spec.IngressSpec.Rules {
+			if rule.Host != nil {
+				add(*rule.Host)
+			}
+		}
+	}
+	if ssanginx.Spec.TLS != nil {
+		for _, name := range ssanginx.Spec.TLS.DNSNames {
+			add(name)
+		}
+	}
+
+	return names
+}
+
+// IP SANs of the server certificate. Invalid addresses are rejected by the webhook.
+func ipAddresses(ssanginx ssanginxv1.SSANginx) []net.IP {
+	var ips []net.IP
+
+	if ssanginx.Spec.TLS == nil {
+		return nil
+	}
+	for _, address := range ssanginx.Spec.TLS.IPAddresses {
+		if ip := net.ParseIP(address); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+
+	return ips
+}
+
+// ClientCrtMatches reports whether the PEM encoded certificate still has the
+// subject and key algorithm described by client.
+func ClientCrtMatches(crt []byte, ssanginx ssanginxv1.SSANginx, client ssanginxv1.ClientCertificate) bool {
+	return crtMatches(crt, clientSubject(ssanginx, client), clientKeyAlgorithm(ssanginx, client))
+}
+
+func crtMatches(crt []byte, name pkix.Name, algorithm ssanginxv1.KeyAlgorithm) bool {
+	certificate, err := parseCertificate(crt)
+	if err != nil {
+		return false
+	}
+
+	fields := [][2][]string{
+		{certificate.Subject.Organization, name.Organization},
+		{certificate.Subject.OrganizationalUnit, name.OrganizationalUnit},
+		{certificate.Subject.Country, name.Country},
+		{certificate.Subject.Province, name.Province},
+		{certificate.Subject.Locality, name.Locality},
+	}
+	for _, f := range fields {
+		if !equalStrings(f[0], f[1]) {
+			return false
+		}
+	}
+
+	return certificate.Subject.CommonName == name.CommonName &&
+		keyAlgorithmMatches(certificate.PublicKey, algorithm)
+}
+
+// Compare the elements regardless of order
+func equalSet(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	elements := make(map[string]bool, len(a))
+	for _, e := range a {
+		elements[e] = true
+	}
+	for _, e := range b {
+		if !elements[e] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for n := range a {
+		if a[n] != b[n] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Subject of the generated certificates with the given CommonName
+func subject(ssanginx ssanginxv1.SSANginx, commonName string) pkix.Name {
+	if ssanginx.Spec.PKI == nil || ssanginx.Spec.PKI.Subject == nil {
+		return pkix.Name{
+			CommonName:         commonName,
+			OrganizationalUnit: []string{"Example Org Unit"},
+			Organization:       []string{"Example Org"},
+			Country:            []string{"JP"},
+		}
+	}
+
+	s := ssanginx.Spec.PKI.Subject
+	return pkix.Name{
+		CommonName:         commonName,
+		OrganizationalUnit: s.OrganizationalUnits,
+		Organization:       s.Organizations,
+		Country:            s.Countries,
+		Province:           s.Provinces,
+		Locality:           s.Localities,
+	}
+}
+
+func clientSubject(ssanginx ssanginxv1.SSANginx, client ssanginxv1.ClientCertificate) pkix.Name {
+	commonName := client.CommonName
+	if commonName == "" {
+		commonName = client.Name
+	}
+
+	name := subject(ssanginx, commonName)
+	if len(client.Organizations) > 0 {
+		name.Organization = client.Organizations
+	}
+
+	return name
+}
+
+func keyAlgorithm(ssanginx ssanginxv1.SSANginx) ssanginxv1.KeyAlgorithm {
+	if ssanginx.Spec.PKI == nil || ssanginx.Spec.PKI.KeyAlgorithm == "" {
+		return ssanginxv1.KeyAlgorithmRSA2048
+	}
+
+	return ssanginx.Spec.PKI.KeyAlgorithm
+}
+
+func clientKeyAlgorithm(ssanginx ssanginxv1.SSANginx, client ssanginxv1.ClientCertificate) ssanginxv1.KeyAlgorithm {
+	if client.KeyAlgorithm != "" {
+		return client.KeyAlgorithm
+	}
+
+	return keyAlgorithm(ssanginx)
+}
+
+func keyEncoding(ssanginx ssanginxv1.SSANginx) ssanginxv1.KeyEncoding {
+	if ssanginx.Spec.PKI == nil || ssanginx.Spec.PKI.KeyEncoding == "" {
+		return ssanginxv1.KeyEncodingPKCS8
+	}
+
+	return ssanginx.Spec.PKI.KeyEncoding
+}
+
+// Random 128-bit serial number
+func newSerialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// SubjectKeyId computed from the SHA-1 hash of the public key [RFC5280 4.2.1.2]
+func subjectKeyID(publicKey crypto.PublicKey) ([]byte, error) {
+	derPublicKey, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var spki struct {
+		Algorithm        pkix.AlgorithmIdentifier
+		SubjectPublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(derPublicKey, &spki); err != nil {
+		return nil, err
+	}
+
+	sum := sha1.Sum(spki.SubjectPublicKey.Bytes)
+	return sum[:], nil
+}
+
+// Generate a private key and return it together with its PEM encoded form.
+func generateKey(algorithm ssanginxv1.KeyAlgorithm, encoding ssanginxv1.KeyEncoding) (crypto.Signer, []byte, error) {
+	var (
+		privateKey crypto.Signer
+		err        error
+	)
+
+	switch algorithm {
+	case "", ssanginxv1.KeyAlgorithmRSA2048:
+		privateKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	case ssanginxv1.KeyAlgorithmRSA3072:
+		privateKey, err = rsa.GenerateKey(rand.Reader, 3072)
+	case ssanginxv1.KeyAlgorithmRSA4096:
+		privateKey, err = rsa.GenerateKey(rand.Reader, 4096)
+	case ssanginxv1.KeyAlgorithmECDSAP256:
+		privateKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case ssanginxv1.KeyAlgorithmECDSAP384:
+		privateKey, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case ssanginxv1.KeyAlgorithmEd25519:
+		_, privateKey, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, nil, fmt.Errorf("unsupported key algorithm %q", algorithm)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyPEM, err := encodePrivateKey(privateKey, encoding)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return privateKey, keyPEM, nil
+}
+
+func encodePrivateKey(privateKey crypto.Signer, encoding ssanginxv1.KeyEncoding) ([]byte, error) {
+	if encoding == ssanginxv1.KeyEncodingPKCS1 {
+		switch k := privateKey.(type) {
+		case *rsa.PrivateKey:
+			return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}), nil
+		case *ecdsa.PrivateKey:
+			derPrivateKey, err := x509.MarshalECPrivateKey(k)
+			if err != nil {
+				return nil, err
+			}
+			return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: derPrivateKey}), nil
+		default:
+			return nil, errors.New("only RSA and ECDSA keys can be encoded as PKCS1")
+		}
+	}
+
+	derPrivateKey, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: derPrivateKey}), nil
+}
+
+// Parse a PEM encoded private key in any of the encodings written by encodePrivateKey.
+// CA keys created by older versions are PKCS1 RSA keys.
+func parsePrivateKey(key []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(key)
+	if block == nil {
+		return nil, errors.New("failed to decode private key PEM")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		privateKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := privateKey.(crypto.Signer)
+		if !ok {
+			return nil, errors.New("unsupported private key type")
+		}
+		return signer, nil
+	default:
+		return nil, fmt.Errorf("unsupported private key PEM type %q", block.Type)
+	}
+}
+
+func keyAlgorithmMatches(publicKey crypto.PublicKey, algorithm ssanginxv1.KeyAlgorithm) bool {
+	switch k := publicKey.(type) {
+	case *rsa.PublicKey:
+		switch algorithm {
+		case "", ssanginxv1.KeyAlgorithmRSA2048:
+			return k.N.BitLen() == 2048
+		case ssanginxv1.KeyAlgorithmRSA3072:
+			return k.N.BitLen() == 3072
+		case ssanginxv1.KeyAlgorithmRSA4096:
+			return k.N.BitLen() == 4096
+		}
+	case *ecdsa.PublicKey:
+		switch algorithm {
+		case ssanginxv1.KeyAlgorithmECDSAP256:
+			return k.Curve == elliptic.P256()
+		case ssanginxv1.KeyAlgorithmECDSAP384:
+			return k.Curve == elliptic.P384()
+		}
+	case ed25519.PublicKey:
+		return algorithm == ssanginxv1.KeyAlgorithmEd25519
+	}
+
+	return false
 }
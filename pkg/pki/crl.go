@@ -0,0 +1,115 @@
+package pki
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// CreateCRL signs a certificate revocation list with the CA of the Issuer.
+// The entries of the PEM encoded crl are kept if it was signed by the same CA,
+// and the hexadecimal serial numbers in revoke are added to them.
+// The CRL number is incremented on every call.
+func (i *Issuer) CreateCRL(crl []byte, revoke []string, validity time.Duration) ([]byte, error) {
+	var (
+		revoked  []pkix.RevokedCertificate
+		number   = big.NewInt(1)
+		listed   = make(map[string]bool)
+		thisTime = time.Now()
+	)
+
+	// A CRL signed by a previous CA is discarded together with its entries,
+	// since the certificates it lists are no longer trusted anyway.
+	if current, err := i.parseCRL(crl); err == nil {
+		revoked = current.RevokedCertificates
+		if current.Number != nil {
+			number.Add(current.Number, big.NewInt(1))
+		}
+	}
+	for _, r := range revoked {
+		listed[r.SerialNumber.Text(16)] = true
+	}
+
+	for _, serialNumber := range revoke {
+		if listed[serialNumber] {
+			continue
+		}
+		n, ok := new(big.Int).SetString(serialNumber, 16)
+		if !ok {
+			return nil, fmt.Errorf("invalid serial number %q", serialNumber)
+		}
+		revoked = append(revoked, pkix.RevokedCertificate{
+			SerialNumber:   n,
+			RevocationTime: thisTime,
+		})
+		listed[serialNumber] = true
+	}
+
+	crlTempl := &x509.RevocationList{
+		RevokedCertificates: revoked,
+		Number:              number,
+		ThisUpdate:          thisTime,
+		NextUpdate:          i.notAfter(thisTime, validity),
+	}
+
+	derCRL, err := x509.CreateRevocationList(rand.Reader, crlTempl, i.caCertificate, i.caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// ingress-nginx reads the PEM encoded CRL from ca.crl
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: derCRL}), nil
+}
+
+// CRLNeedsUpdate reports whether the PEM encoded crl is missing, was not signed by
+// the CA of the Issuer, or reaches its nextUpdate within renewBefore.
+func (i *Issuer) CRLNeedsUpdate(crl []byte, renewBefore time.Duration) bool {
+	current, err := i.parseCRL(crl)
+	if err != nil {
+		return true
+	}
+
+	// A CRL expiring together with the CA cannot be extended
+	if !current.NextUpdate.Before(i.caCertificate.NotAfter) {
+		return false
+	}
+
+	return time.Until(current.NextUpdate) < renewBefore
+}
+
+// CRLNextUpdate returns the nextUpdate of the PEM encoded CRL.
+func CRLNextUpdate(crl []byte) (time.Time, error) {
+	list, err := parseRevocationList(crl)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return list.NextUpdate, nil
+}
+
+func parseRevocationList(crl []byte) (*x509.RevocationList, error) {
+	block, _ := pem.Decode(crl)
+	if block == nil || block.Type != "X509 CRL" {
+		return nil, errors.New("failed to decode CRL PEM")
+	}
+
+	return x509.ParseRevocationList(block.Bytes)
+}
+
+// Parse the CRL only if it was signed by the CA of the Issuer
+func (i *Issuer) parseCRL(crl []byte) (*x509.RevocationList, error) {
+	list, err := parseRevocationList(crl)
+	if err != nil {
+		return nil, err
+	}
+	if err := list.CheckSignatureFrom(i.caCertificate); err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
@@ -0,0 +1,89 @@
+package pki
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ValidateKeyPair checks a PEM encoded server certificate and private key supplied by the user.
+// The key must match the certificate, the certificate must be valid at now,
+// and its SANs must cover every host.
+func ValidateKeyPair(crt, key []byte, hosts []string, now time.Time) error {
+	keyPair, err := tls.X509KeyPair(crt, key)
+	if err != nil {
+		return err
+	}
+
+	leaf, err := x509.ParseCertificate(keyPair.Certificate[0])
+	if err != nil {
+		return err
+	}
+
+	if now.Before(leaf.NotBefore) {
+		return fmt.Errorf("certificate is not valid before %s", leaf.NotBefore.Format(time.RFC3339))
+	}
+	if now.After(leaf.NotAfter) {
+		return fmt.Errorf("certificate expired at %s", leaf.NotAfter.Format(time.RFC3339))
+	}
+
+	return verifyHosts(leaf, hosts)
+}
+
+// VerifyHosts checks that the SANs of the PEM encoded certificate cover every host.
+func VerifyHosts(crt []byte, hosts []string) error {
+	certificate, err := parseCertificate(crt)
+	if err != nil {
+		return err
+	}
+
+	return verifyHosts(certificate, hosts)
+}
+
+func verifyHosts(certificate *x509.Certificate, hosts []string) error {
+	for _, host := range hosts {
+		if err := certificate.VerifyHostname(host); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ValidateCABundle checks a PEM encoded bundle of CA certificates used to verify client certificates.
+// Every certificate must be a CA valid at now.
+func ValidateCABundle(bundle []byte, now time.Time) error {
+	var count int
+
+	for rest := bundle; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return err
+		}
+		if !certificate.IsCA {
+			return fmt.Errorf("certificate %q is not a CA", certificate.Subject.CommonName)
+		}
+		if now.After(certificate.NotAfter) {
+			return fmt.Errorf("CA certificate %q expired at %s", certificate.Subject.CommonName, certificate.NotAfter.Format(time.RFC3339))
+		}
+		count++
+	}
+
+	if count == 0 {
+		return errors.New("no CA certificate found in PEM data")
+	}
+
+	return nil
+}
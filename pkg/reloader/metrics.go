@@ -0,0 +1,32 @@
+package reloader
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	reloadsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ssanginx_reloader_reloads_total",
+		Help: "Number of nginx reloads triggered by configuration changes, partitioned by result.",
+	}, []string{"result"})
+
+	lastReloadSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "ssanginx_reloader_last_reload_success_timestamp_seconds",
+		Help: "Unix time of the last successful nginx reload.",
+	})
+
+	configValid = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "ssanginx_reloader_config_valid",
+		Help: "Whether the last validation of the nginx configuration with nginx -t succeeded.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(reloadsTotal, lastReloadSuccess, configValid)
+
+	// Report every result from the start so that rate() works on the first reload
+	for _, result := range []string{ResultSuccess, ResultInvalidConfig, ResultSignalError} {
+		reloadsTotal.WithLabelValues(result)
+	}
+	configValid.Set(1)
+}
@@ -0,0 +1,134 @@
+package reloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-logr/logr"
+)
+
+// Results of a reload recorded in the metrics
+const (
+	ResultSuccess       = "success"
+	ResultInvalidConfig = "invalid_config"
+	ResultSignalError   = "signal_error"
+)
+
+// Title of the nginx master process in /proc/<pid>/cmdline
+var masterProcessTitle = []byte("nginx: master process")
+
+// Reloader watches the directories of the nginx configuration and reloads nginx when a file changes.
+// It runs in a sidecar sharing the process namespace of the pod, so that the
+// nginx master process can be signaled from the sidecar.
+type Reloader struct {
+	// Dirs are watched for any file change. The files of a ConfigMap volume are
+	// replaced by swapping a symlink, so the directories are watched instead of the files.
+	Dirs []string
+	// NginxBinary validates the configuration with "nginx -t".
+	NginxBinary string
+	// ProcDir is the mount point of procfs used to find the nginx master process.
+	ProcDir string
+	// Debounce delays the reload until no file has changed for the duration.
+	Debounce time.Duration
+	Log      logr.Logger
+}
+
+// Run watches Dirs until ctx is done.
+func (r *Reloader) Run(ctx context.Context) error {
+	var pending <-chan time.Time
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	for _, dir := range r.Dirs {
+		if err := watcher.Add(dir); err != nil {
+			return fmt.Errorf("unable to watch %s: %w", dir, err)
+		}
+		r.Log.Info("watching", "dir", dir)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			r.Log.V(1).Info("file changed", "name", event.Name, "op", event.Op.String())
+			pending = time.After(r.Debounce)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			r.Log.Error(err, "watch error")
+		case <-pending:
+			pending = nil
+			r.reload(ctx)
+		}
+	}
+}
+
+// Validate the configuration and send SIGHUP to the nginx master process only if it is valid.
+// nginx keeps serving with the current configuration when the validation fails.
+func (r *Reloader) reload(ctx context.Context) {
+	out, err := exec.CommandContext(ctx, r.NginxBinary, "-t").CombinedOutput()
+	if err != nil {
+		configValid.Set(0)
+		reloadsTotal.WithLabelValues(ResultInvalidConfig).Inc()
+		r.Log.Error(err, "invalid nginx configuration, keep the current configuration", "output", string(out))
+		return
+	}
+	configValid.Set(1)
+
+	pid, err := FindMasterProcess(r.ProcDir)
+	if err == nil {
+		err = syscall.Kill(pid, syscall.SIGHUP)
+	}
+	if err != nil {
+		reloadsTotal.WithLabelValues(ResultSignalError).Inc()
+		r.Log.Error(err, "unable to reload nginx")
+		return
+	}
+
+	reloadsTotal.WithLabelValues(ResultSuccess).Inc()
+	lastReloadSuccess.SetToCurrentTime()
+	r.Log.Info("nginx reloaded", "pid", pid)
+}
+
+// FindMasterProcess returns the PID of the nginx master process found in procDir.
+func FindMasterProcess(procDir string) (int, error) {
+	entries, err := os.ReadDir(procDir)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+		// The process may have exited in the meantime
+		cmdline, err := os.ReadFile(filepath.Join(procDir, entry.Name(), "cmdline"))
+		if err != nil {
+			continue
+		}
+		if bytes.HasPrefix(cmdline, masterProcessTitle) {
+			return pid, nil
+		}
+	}
+
+	return 0, errors.New("nginx master process not found, the pod must share the process namespace")
+}
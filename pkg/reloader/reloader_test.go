@@ -0,0 +1,227 @@
+package reloader
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/funcr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// fakeNginx writes a script standing in for "nginx -t", which records each call in the returned file.
+func fakeNginx(t *testing.T, exitCode int) (binary, calls string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	binary = filepath.Join(dir, "nginx")
+	calls = filepath.Join(dir, "calls")
+	script := "#!/bin/sh\necho \"$@\" >> " + calls + "\necho 'nginx: configuration file test'\nexit " + strconv.Itoa(exitCode) + "\n"
+	if err := os.WriteFile(binary, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	return binary, calls
+}
+
+// fakeProc creates a procfs with the test process as the nginx master process and another one as a worker.
+func fakeProc(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for pid, cmdline := range map[int]string{
+		1:               "/reloader\x00--dir\x00/etc/nginx/conf.d\x00",
+		os.Getpid():     "nginx: master process nginx -g daemon off;\x00",
+		os.Getpid() + 1: "nginx: worker process\x00",
+	} {
+		if err := os.MkdirAll(filepath.Join(dir, strconv.Itoa(pid)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, strconv.Itoa(pid), "cmdline"), []byte(cmdline), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "uptime"), []byte("1.0 1.0\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+// notifySIGHUP catches the SIGHUP sent to the test process by the reloader.
+func notifySIGHUP(t *testing.T) <-chan os.Signal {
+	t.Helper()
+
+	ch := make(chan os.Signal, 10)
+	signal.Notify(ch, syscall.SIGHUP)
+	t.Cleanup(func() { signal.Stop(ch) })
+
+	return ch
+}
+
+func countCalls(t *testing.T, calls string) int {
+	t.Helper()
+
+	b, err := os.ReadFile(calls)
+	if os.IsNotExist(err) {
+		return 0
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return strings.Count(string(b), "\n")
+}
+
+func TestFindMasterProcess(t *testing.T) {
+	pid, err := FindMasterProcess(fakeProc(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pid != os.Getpid() {
+		t.Errorf("pid = %d, want %d", pid, os.Getpid())
+	}
+
+	if _, err := FindMasterProcess(t.TempDir()); err == nil {
+		t.Error("expected an error without the nginx master process")
+	}
+}
+
+func TestReload(t *testing.T) {
+	tests := []struct {
+		name       string
+		exitCode   int
+		noMaster   bool
+		result     string
+		signaled   bool
+		validation float64
+	}{
+		{
+			name:       "valid configuration",
+			result:     ResultSuccess,
+			signaled:   true,
+			validation: 1,
+		},
+		{
+			name:       "invalid configuration",
+			exitCode:   1,
+			result:     ResultInvalidConfig,
+			validation: 0,
+		},
+		{
+			name:       "master process not found",
+			noMaster:   true,
+			result:     ResultSignalError,
+			validation: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sighup := notifySIGHUP(t)
+			binary, calls := fakeNginx(t, tt.exitCode)
+			procDir := fakeProc(t)
+			if tt.noMaster {
+				procDir = t.TempDir()
+			}
+			r := &Reloader{NginxBinary: binary, ProcDir: procDir, Log: logr.Discard()}
+			before := testutil.ToFloat64(reloadsTotal.WithLabelValues(tt.result))
+
+			r.reload(context.Background())
+
+			if got := countCalls(t, calls); got != 1 {
+				t.Errorf("nginx called %d times, want 1", got)
+			}
+			if got := testutil.ToFloat64(reloadsTotal.WithLabelValues(tt.result)) - before; got != 1 {
+				t.Errorf("reloads_total{result=%q} increased by %v, want 1", tt.result, got)
+			}
+			if got := testutil.ToFloat64(configValid); got != tt.validation {
+				t.Errorf("config_valid = %v, want %v", got, tt.validation)
+			}
+			select {
+			case <-sighup:
+				if !tt.signaled {
+					t.Error("nginx must not be reloaded")
+				}
+			case <-time.After(500 * time.Millisecond):
+				if tt.signaled {
+					t.Error("nginx was not reloaded")
+				}
+			}
+		})
+	}
+}
+
+func TestRunDebounce(t *testing.T) {
+	const debounce = 300 * time.Millisecond
+
+	sighup := notifySIGHUP(t)
+	binary, calls := fakeNginx(t, 0)
+	dir := t.TempDir()
+
+	// Wait until the directory is watched before changing it
+	watching := make(chan struct{}, 1)
+	log := funcr.New(func(_, args string) {
+		if strings.Contains(args, `"msg"="watching"`) {
+			watching <- struct{}{}
+		}
+	}, funcr.Options{})
+
+	r := &Reloader{
+		Dirs:        []string{dir},
+		NginxBinary: binary,
+		ProcDir:     fakeProc(t),
+		Debounce:    debounce,
+		Log:         log,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- r.Run(ctx) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}()
+
+	select {
+	case <-watching:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the directory was not watched")
+	}
+
+	// A ConfigMap update changes several files in a row, which must result in a single reload
+	var lastChange time.Time
+	for i := 0; i < 3; i++ {
+		if err := os.WriteFile(filepath.Join(dir, "default.conf"), []byte(strconv.Itoa(i)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		lastChange = time.Now()
+		time.Sleep(debounce / 3)
+	}
+
+	select {
+	case <-sighup:
+		if elapsed := time.Since(lastChange); elapsed < debounce {
+			t.Errorf("reloaded %v after the last change, want at least %v", elapsed, debounce)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("nginx was not reloaded")
+	}
+
+	select {
+	case <-sighup:
+		t.Error("nginx was reloaded more than once")
+	case <-time.After(2 * debounce):
+	}
+	if got := countCalls(t, calls); got != 1 {
+		t.Errorf("nginx called %d times, want 1", got)
+	}
+}